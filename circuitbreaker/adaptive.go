@@ -0,0 +1,84 @@
+package circuitbreaker
+
+import "sync/atomic"
+
+const (
+	// contentionWindow is the number of samples HotPathAdaptive decides on.
+	// On the mutex, a sample is whether taking it had to wait, and the atomic
+	// hot path is enabled once at least a quarter of a window's did. On the
+	// atomic hot path, a sample is an admitted request, and the mutex is
+	// back once fewer than one in sixteen of a window's lost a
+	// compare-and-swap to another request. The gap between the two keeps it
+	// from switching back and forth under a steady load. Either way the
+	// Counts are the same: switching takes the mutex, which folds in what the
+	// atomic hot path counted, and a request admitted before a switch has its
+	// outcome recorded by whichever path is on when it completes
+	contentionWindow = 128
+
+	// contendedLocks is how many of a window's samples on the mutex have to
+	// wait for it to switch to the atomic hot path
+	contendedLocks = contentionWindow / 4
+
+	// contendedAdds is how many of a window's samples on the atomic hot path
+	// have to lose a compare-and-swap for it to stay on
+	contendedAdds = contentionWindow / 16
+)
+
+// contention is what HotPathAdaptive measures to switch between the mutex
+// and the atomic hot path
+type contention struct {
+	// locks and waited are the number of times the mutex was taken in the
+	// current window and how many of them had to wait for it. They're
+	// guarded by the mutex
+	locks  uint32
+	waited uint32
+
+	// retries is the number of compare-and-swaps the atomic hot path lost in
+	// the current window
+	retries atomic.Uint32
+}
+
+// sampleLock records whether taking the mutex had to wait, switching to the
+// atomic hot path at the end of a contended window. It must be called with
+// the mutex held
+func (cb *CircuitBreaker) sampleLock(waited bool) {
+	c := &cb.contention
+	if cb.fastEnabled {
+		return
+	}
+	c.locks++
+	if waited {
+		c.waited++
+	}
+	if c.locks < contentionWindow {
+		return
+	}
+	if c.waited >= contendedLocks {
+		cb.fastEnabled = true
+		c.retries.Store(0)
+		cb.publish()
+	}
+	c.locks, c.waited = 0, 0
+}
+
+// sampleFast records the compare-and-swaps lost admitting the n-th request
+// without the mutex, switching back to it at the end of an uncontended window.
+// Windows are counted in requests so that it costs nothing but the
+// occasional check
+func (cb *CircuitBreaker) sampleFast(n uint64, retries uint32) {
+	c := &cb.contention
+	if retries > 0 {
+		c.retries.Add(retries)
+	}
+	if n%contentionWindow != 0 || c.retries.Swap(0) >= contendedAdds {
+		return
+	}
+
+	cb.lock()
+	defer cb.unlock()
+
+	if cb.fastEnabled {
+		cb.fastEnabled = false
+		cb.publish()
+	}
+}
@@ -0,0 +1,92 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotPathAdaptive(t *testing.T) {
+	cb := NewCircuitBreaker(Config{HotPath: HotPathAdaptive})
+	assert.False(t, cb.view.Load().fast)
+
+	// uncontended, it stays on the mutex
+	for i := 0; i < 2*contentionWindow; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	assert.False(t, cb.view.Load().fast)
+
+	// a quarter of a window waiting for the mutex switches to the atomic hot
+	// path
+	cb.mu.Lock()
+	cb.contention.locks, cb.contention.waited = 0, 0
+	for i := 0; i < contentionWindow-1; i++ {
+		cb.sampleLock(i%4 == 0)
+	}
+	assert.False(t, cb.view.Load().fast)
+	cb.sampleLock(false)
+	cb.unlock()
+	assert.True(t, cb.view.Load().fast)
+
+	// which stays on while its requests contend with each other
+	cb.sampleFast(contentionWindow, contendedAdds)
+	assert.True(t, cb.view.Load().fast)
+	cb.sampleFast(contentionWindow+1, 0)
+	assert.True(t, cb.view.Load().fast)
+
+	// and is off again once they don't
+	for i := 0; i < contentionWindow && cb.view.Load().fast; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	assert.False(t, cb.view.Load().fast)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, cb.Stats().Requests, uint64(cb.Counts().CurrRequests))
+	assert.Equal(t, uint32(0), cb.Counts().ConsecutiveSuccesses)
+	assert.Equal(t, uint32(1), cb.Counts().TotalFailures)
+
+	// features that need every outcome under the mutex rule it out
+	cb = NewCircuitBreaker(Config{
+		HotPath:   HotPathAdaptive,
+		OnSuccess: func(counts Counts) {},
+	})
+	assert.False(t, cb.adaptive)
+}
+
+func TestHotPathAdaptiveSwitching(t *testing.T) {
+	cb := NewCircuitBreaker(Config{HotPath: HotPathAdaptive})
+
+	// requests are counted exactly, whichever path admits them and whichever
+	// records their outcome
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			cb.lock()
+			cb.fastEnabled = !cb.fastEnabled
+			cb.publish()
+			cb.unlock()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				assert.Nil(t, succeed(cb))
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-stopped
+	assert.Equal(t, Counts{4000, 4000, 0, 4000, 0, 0}, cb.Counts())
+	assert.Equal(t, uint64(4000), cb.Stats().Successes)
+}
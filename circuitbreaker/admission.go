@@ -0,0 +1,67 @@
+package circuitbreaker
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// HalfOpenAdmissionStrategy decides which requests a half-open
+// CircuitBreaker admits as probes. ShouldAdmit is called with the counts of
+// the half-open generation so far, CurrRequests being the probes admitted
+// before this one. It's called with the mutex held and mustn't call back into
+// the CircuitBreaker. A request it declines fails with ErrTooManyRequests
+type HalfOpenAdmissionStrategy interface {
+	ShouldAdmit(counts Counts) bool
+}
+
+// CapAdmission admits up to Max probes. It's the default
+// HalfOpenAdmissionStrategy, with Max being MaxRequestsWhileHalfOpen, or
+// HalfOpenMaxRequests when HalfOpenSuccessRatio is set
+type CapAdmission struct {
+	Max uint32
+}
+
+// ShouldAdmit implements HalfOpenAdmissionStrategy
+func (a CapAdmission) ShouldAdmit(counts Counts) bool {
+	return counts.CurrRequests < a.Max
+}
+
+// LinearRampAdmission admits probes at random for a slow start: each request
+// is admitted with a probability that rises linearly with the consecutive
+// successful probes, from 1/steps before the first one to certainty after
+// steps-1 of them. There's no cap on the number of probes, so
+// SuccessThreshold, which is capped at MaxRequestsWhileHalfOpen, decides how
+// many successes close the CircuitBreaker. It's safe to share between
+// CircuitBreakers
+type LinearRampAdmission struct {
+	steps uint32
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewLinearRampAdmission returns a LinearRampAdmission ramping up over steps,
+// at least 1, drawing from src. If src is nil, a source seeded with the
+// current time is used
+func NewLinearRampAdmission(steps uint32, src rand.Source) *LinearRampAdmission {
+	if steps == 0 {
+		steps = 1
+	}
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return &LinearRampAdmission{steps: steps, rand: rand.New(src)}
+}
+
+// ShouldAdmit implements HalfOpenAdmissionStrategy
+func (a *LinearRampAdmission) ShouldAdmit(counts Counts) bool {
+	p := float64(counts.ConsecutiveSuccesses+1) / float64(a.steps)
+	if p >= 1 {
+		return true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rand.Float64() < p
+}
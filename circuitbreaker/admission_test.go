@@ -0,0 +1,65 @@
+package circuitbreaker
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapAdmission(t *testing.T) {
+	a := CapAdmission{Max: 2}
+	assert.True(t, a.ShouldAdmit(Counts{CurrRequests: 1}))
+	assert.False(t, a.ShouldAdmit(Counts{CurrRequests: 2}))
+
+	assert.Equal(t, CapAdmission{Max: 3}, NewCircuitBreaker(Config{MaxRequestsWhileHalfOpen: 3}).halfOpenAdmission)
+	assert.Equal(t, CapAdmission{Max: 5}, NewCircuitBreaker(Config{
+		MaxRequestsWhileHalfOpen: 3,
+		HalfOpenSuccessRatio:     0.5,
+		HalfOpenMaxRequests:      5,
+	}).halfOpenAdmission)
+}
+
+func TestLinearRampAdmission(t *testing.T) {
+	a := NewLinearRampAdmission(4, rand.NewSource(1))
+	admitted := func(successes uint32) int {
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if a.ShouldAdmit(Counts{ConsecutiveSuccesses: successes}) {
+				n++
+			}
+		}
+		return n
+	}
+	assert.InDelta(t, 250, admitted(0), 50)
+	assert.InDelta(t, 500, admitted(1), 50)
+	assert.InDelta(t, 750, admitted(2), 50)
+	assert.Equal(t, 1000, admitted(3))
+	assert.True(t, NewLinearRampAdmission(0, nil).ShouldAdmit(Counts{}))
+}
+
+type scriptedAdmission []bool
+
+func (a *scriptedAdmission) ShouldAdmit(counts Counts) bool {
+	admit := (*a)[0]
+	*a = (*a)[1:]
+	return admit
+}
+
+func TestHalfOpenAdmissionStrategy(t *testing.T) {
+	admission := &scriptedAdmission{false, true, false, true}
+	cb := NewCircuitBreaker(Config{
+		MaxRequestsWhileHalfOpen:  2,
+		HalfOpenAdmissionStrategy: admission,
+	})
+	cb.ForceOpen()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+
+	assert.ErrorIs(t, succeed(cb), ErrTooManyRequests)
+	assert.Nil(t, succeed(cb))
+	assert.ErrorIs(t, succeed(cb), ErrTooManyRequests)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Empty(t, *admission)
+}
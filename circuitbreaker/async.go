@@ -0,0 +1,58 @@
+package circuitbreaker
+
+import "context"
+
+// Future is the result of a request started with DoAsync
+type Future struct {
+	done       chan struct{}
+	result     interface{}
+	err        error
+	panicked   bool
+	panicValue interface{}
+}
+
+// Done returns a channel that's closed once the request is over, e.g. to
+// select on several Futures
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the request is over and returns what Do would have. If
+// the request panicked, and RecoverPanics isn't set, Wait panics with the same
+// value, on the goroutine calling it. Wait can be called any number of times
+func (f *Future) Wait() (interface{}, error) {
+	<-f.done
+	if f.panicked {
+		panic(f.panicValue)
+	}
+	return f.result, f.err
+}
+
+// DoAsync is like Do but runs the request on its own goroutine, e.g. to start
+// several requests and gather their results later. Whether the request is
+// admitted is decided before DoAsync returns: a rejected request never runs
+// and its Future is over right away, with the rejection error. The outcome of
+// an admitted request is recorded in the generation it was admitted in, like
+// any other, panics included. Fallback doesn't apply to asynchronous requests
+func (cb *CircuitBreaker) DoAsync(req func() (interface{}, error)) *Future {
+	f := &Future{done: make(chan struct{})}
+	_, generation, err := cb.beforeRequest()
+	if err != nil {
+		f.err = err
+		close(f.done)
+		return f
+	}
+
+	go func() {
+		defer close(f.done)
+		defer func() {
+			if e := recover(); e != nil {
+				f.panicked, f.panicValue = true, e
+			}
+		}()
+		f.result, f.err = runAdmitted(cb, nil, generation, func(context.Context) (interface{}, error) {
+			return req()
+		})
+	}()
+	return f
+}
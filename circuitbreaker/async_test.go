@@ -0,0 +1,80 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoAsync(t *testing.T) {
+	errFail := errors.New("fail")
+	cb := NewCircuitBreaker(Config{})
+	release := make(chan struct{})
+
+	futures := make([]*Future, 3)
+	for i := range futures {
+		i := i
+		futures[i] = cb.DoAsync(func() (interface{}, error) {
+			<-release
+			if i == 1 {
+				return nil, errFail
+			}
+			return i, nil
+		})
+	}
+	assert.Equal(t, uint32(3), cb.ConcurrentRequests())
+	close(release)
+
+	result, err := futures[0].Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result)
+	_, err = futures[1].Wait()
+	assert.Equal(t, errFail, err)
+	result, err = futures[2].Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result)
+	assert.Equal(t, uint32(2), cb.Counts().TotalSuccesses)
+	assert.Equal(t, uint32(1), cb.Counts().TotalFailures)
+
+	// rejected right away
+	cb.ForceOpen()
+	f := cb.DoAsync(func() (interface{}, error) {
+		panic("not called")
+	})
+	select {
+	case <-f.Done():
+	default:
+		t.Fatal("a rejected Future should be over")
+	}
+	_, err = f.Wait()
+	assert.ErrorIs(t, err, ErrOpenState)
+}
+
+func TestDoAsyncPanic(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	f := cb.DoAsync(func() (interface{}, error) {
+		panic("oops")
+	})
+	assert.PanicsWithValue(t, "oops", func() { _, _ = f.Wait() })
+	assert.PanicsWithValue(t, "oops", func() { _, _ = f.Wait() })
+	assert.Equal(t, Counts{1, 0, 1, 0, 1, 1}, cb.Counts())
+
+	cb = NewCircuitBreaker(Config{RecoverPanics: true})
+	_, err := cb.DoAsync(func() (interface{}, error) {
+		panic("oops")
+	}).Wait()
+	assert.ErrorIs(t, err, ErrRequestPanicked)
+
+	// recorded in the generation it was admitted in
+	release := make(chan struct{})
+	f = cb.DoAsync(func() (interface{}, error) {
+		<-release
+		panic("oops")
+	})
+	cb.Reset()
+	close(release)
+	_, err = f.Wait()
+	assert.ErrorIs(t, err, ErrRequestPanicked)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+}
@@ -0,0 +1,186 @@
+package circuitbreaker
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// DoBatch runs a batch of requests to the same dependency one after the other
+// under a single admission decision: if the CircuitBreaker rejects the batch,
+// none of the requests run and each gets the rejection error. Once admitted,
+// the batch takes up a single place among the requests in flight and isn't
+// subject to the RateLimiter again.
+//
+// While closed, each request counts individually towards the Counts, and the
+// batch can trip the CircuitBreaker partway through: the requests that
+// haven't started by then don't run and get the rejection error instead.
+// While half-open, the batch is a single probe, like AllowBulk: every request
+// runs and the probe is a success if none of them failed.
+//
+// The results and errors are in the order of reqs. A panicking request counts
+// as a failure, and the panic is propagated once the batch is over, unless
+// RecoverPanics is set, in which case its error is a PanicError. Fallback
+// and RequestTimeout don't apply to batched requests
+func (cb *CircuitBreaker) DoBatch(reqs []func() (interface{}, error)) ([]interface{}, []error) {
+	return cb.DoBatchConcurrent(reqs, 1)
+}
+
+// DoBatchConcurrent is like DoBatch but runs up to limit requests at once, or
+// all of them if limit is 0 or less
+func (cb *CircuitBreaker) DoBatchConcurrent(reqs []func() (interface{}, error), limit int) ([]interface{}, []error) {
+	results, errs := make([]interface{}, len(reqs)), make([]error, len(reqs))
+	if len(reqs) == 0 {
+		return results, errs
+	}
+
+	state, generation, err := cb.beforeRequest()
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return results, errs
+	}
+
+	b := &batch{
+		cb:         cb,
+		reqs:       reqs,
+		results:    results,
+		errs:       errs,
+		generation: generation,
+		probe:      state == StateHalfOpen,
+		outcomes:   make([]outcome, len(reqs)),
+	}
+	b.run(limit)
+
+	if b.probe {
+		cb.afterRequest(generation, b.probeOutcome())
+	} else {
+		cb.releaseActive()
+	}
+	if b.panicked {
+		panic(b.panicValue)
+	}
+	return results, errs
+}
+
+// batch is a DoBatch in progress
+type batch struct {
+	cb         *CircuitBreaker
+	reqs       []func() (interface{}, error)
+	results    []interface{}
+	errs       []error
+	generation uint64
+	probe      bool
+	outcomes   []outcome
+
+	mu         sync.Mutex
+	panicked   bool
+	panicValue interface{}
+}
+
+func (b *batch) run(limit int) {
+	if limit <= 0 || limit > len(b.reqs) {
+		limit = len(b.reqs)
+	}
+
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := range b.reqs {
+		sem <- struct{}{}
+		generation, err := b.admit(i)
+		if err != nil {
+			b.errs[i] = err
+			<-sem
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, generation uint64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			b.do(i, generation)
+		}(i, generation)
+	}
+	wg.Wait()
+}
+
+// admit returns the generation the i-th request of the batch is counted in.
+// While closed, every request after the first is counted in the current
+// generation, provided the CircuitBreaker hasn't tripped in the meantime
+func (b *batch) admit(i int) (uint64, error) {
+	if i == 0 || b.probe {
+		return b.generation, nil
+	}
+
+	cb := b.cb
+	cb.lock()
+	defer cb.unlock()
+
+	state, generation := cb.currentState(cb.now())
+	switch state {
+	case StateOpen:
+		cb.stats.rejections.Add(1)
+		cb.rejected++
+		return 0, cb.rejectError(ErrOpenState, state)
+	case StateHalfOpen:
+		cb.stats.rejections.Add(1)
+		cb.rejected++
+		return 0, cb.rejectError(ErrTooManyRequests, state)
+	}
+	cb.counts.CurrRequests++
+	cb.stats.requests.Add(1)
+	return generation, nil
+}
+
+// do runs the i-th request of the batch and records its outcome
+func (b *batch) do(i int, generation uint64) {
+	start := b.cb.now()
+	defer func() {
+		if e := recover(); e != nil {
+			if b.cb.recoverPanics {
+				b.results[i], b.errs[i] = nil, PanicError{Value: e, Stack: debug.Stack()}
+			} else {
+				b.mu.Lock()
+				if !b.panicked {
+					b.panicked, b.panicValue = true, e
+				}
+				b.mu.Unlock()
+			}
+			b.record(i, generation, outcome{success: false, duration: b.cb.since(start), panicked: true, err: ErrRequestPanicked})
+		}
+	}()
+
+	b.results[i], b.errs[i] = b.reqs[i]()
+	b.record(i, generation, b.cb.responseOutcome(nil, b.results[i], b.errs[i], start))
+}
+
+func (b *batch) record(i int, generation uint64, o outcome) {
+	if b.probe {
+		b.outcomes[i] = o
+		return
+	}
+	b.cb.recordOutcome(generation, o)
+}
+
+// probeOutcome is the outcome of a half-open batch as a whole: a failure if
+// any of its requests failed, a success if any succeeded, and ignored
+// otherwise
+func (b *batch) probeOutcome() outcome {
+	o := outcome{ignore: true}
+	for _, each := range b.outcomes {
+		if each.duration > o.duration {
+			o.duration = each.duration
+		}
+		if each.ignore {
+			continue
+		}
+		if o.ignore || !each.success {
+			o.success, o.severity, o.weight, o.err = each.success, each.severity, each.weight, each.err
+			o.backoff, o.backoffFor = each.backoff, each.backoffFor
+		}
+		o.ignore = false
+	}
+	return o
+}
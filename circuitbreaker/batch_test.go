@@ -0,0 +1,121 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoBatch(t *testing.T) {
+	errFail := errors.New("fail")
+	var calls int
+	succeeding := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+	failing := func() (interface{}, error) {
+		calls++
+		return nil, errFail
+	}
+
+	cb := NewCircuitBreaker(Config{})
+	results, errs := cb.DoBatch([]func() (interface{}, error){succeeding, failing, succeeding})
+	assert.Equal(t, []interface{}{1, nil, 3}, results)
+	assert.Equal(t, []error{nil, errFail, nil}, errs)
+	assert.Equal(t, Counts{3, 1, 0, 2, 1, 0}, cb.Counts())
+	assert.Equal(t, uint32(0), cb.ConcurrentRequests())
+
+	// trips partway through, the rest don't run
+	calls = 0
+	reqs := make([]func() (interface{}, error), 10)
+	for i := range reqs {
+		reqs[i] = failing
+	}
+	results, errs = cb.DoBatch(reqs)
+	assert.Equal(t, 6, calls)
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Len(t, results, 10)
+	for i, err := range errs {
+		if i < 6 {
+			assert.Equal(t, errFail, err)
+		} else {
+			assert.ErrorIs(t, err, ErrOpenState)
+		}
+	}
+
+	// rejected as a whole
+	calls = 0
+	_, errs = cb.DoBatch([]func() (interface{}, error){succeeding, succeeding})
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, []error{
+		RejectedError{State: StateOpen, Err: ErrOpenState},
+		RejectedError{State: StateOpen, Err: ErrOpenState},
+	}, errs)
+
+	// a single probe while half-open
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	_, errs = cb.DoBatch([]func() (interface{}, error){succeeding, failing, succeeding})
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []error{nil, errFail, nil}, errs)
+	assert.Equal(t, StateOpen, cb.State())
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	_, errs = cb.DoBatch([]func() (interface{}, error){succeeding, succeeding})
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Equal(t, StateClosed, cb.State())
+
+	results, errs = cb.DoBatch(nil)
+	assert.Empty(t, results)
+	assert.Empty(t, errs)
+}
+
+func TestDoBatchConcurrent(t *testing.T) {
+	cb := NewCircuitBreaker(Config{MaxConcurrentRequests: 1})
+	var running, peak int32
+	req := func() (interface{}, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(10) * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil, nil
+	}
+
+	reqs := make([]func() (interface{}, error), 6)
+	for i := range reqs {
+		reqs[i] = req
+	}
+	_, errs := cb.DoBatchConcurrent(reqs, 3)
+	assert.Equal(t, make([]error, 6), errs)
+	assert.Equal(t, int32(3), peak)
+	assert.Equal(t, Counts{6, 6, 0, 6, 0, 0}, cb.Counts())
+
+	// panics count as failures and are propagated once the batch is over
+	reqs[2] = func() (interface{}, error) { panic("oops") }
+	assert.PanicsWithValue(t, "oops", func() {
+		cb.DoBatchConcurrent(reqs, 0)
+	})
+	counts := cb.Counts()
+	assert.Equal(t, uint32(11), counts.TotalSuccesses)
+	assert.Equal(t, uint32(1), counts.TotalFailures)
+	assert.Equal(t, uint32(0), cb.ConcurrentRequests())
+}
+
+func TestDoBatchRecoverPanics(t *testing.T) {
+	cb := NewCircuitBreaker(Config{RecoverPanics: true})
+	results, errs := cb.DoBatch([]func() (interface{}, error){
+		func() (interface{}, error) { panic("oops") },
+		func() (interface{}, error) { return 1, nil },
+	})
+	assert.Equal(t, []interface{}{nil, 1}, results)
+	assert.ErrorIs(t, errs[0], ErrRequestPanicked)
+	assert.Nil(t, errs[1])
+	assert.Equal(t, Counts{2, 1, 0, 1, 1, 1}, cb.Counts())
+}
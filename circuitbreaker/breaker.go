@@ -28,9 +28,15 @@ THE SOFTWARE.
 package circuitbreaker
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -50,11 +56,39 @@ const (
 
 var (
 	// ErrTooManyRequests is returned when the CircuitBreaker state is half open
-	// and the current request count is over the maxRequests
+	// and the current request count is over the maxRequests. It's wrapped in a
+	// TooManyRequestsError with the counts at the time
 	ErrTooManyRequests = errors.New("too many requests")
 
 	// ErrOpenState is returned when the CircuitBreaker state is open
 	ErrOpenState = errors.New("circuit breaker is open")
+
+	// ErrRequestTimeout is returned when a request takes longer than the
+	// CircuitBreaker's RequestTimeout
+	ErrRequestTimeout = errors.New("request timed out")
+
+	// ErrRateLimited is returned when the CircuitBreaker's RateLimiter denies
+	// a request
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrTooManyConcurrent is returned when MaxConcurrentRequests requests
+	// are already in flight
+	ErrTooManyConcurrent = errors.New("too many concurrent requests")
+
+	// ErrRequestPanicked is wrapped by the PanicError returned for a request
+	// that panicked when RecoverPanics is set
+	ErrRequestPanicked = errors.New("request panicked")
+
+	// ErrRecovering is returned for requests turned away by the
+	// RecoveryRampDuration ramp after the CircuitBreaker closes from half-open
+	ErrRecovering = errors.New("circuit breaker is recovering")
+
+	// ErrSharedCountsUnavailable is returned while closed when FailMode is
+	// FailClosed and the SharedCounts store is failing
+	ErrSharedCountsUnavailable = errors.New("shared counts unavailable")
+
+	// ErrDraining is returned for requests made once Drain has been called
+	ErrDraining = errors.New("circuit breaker is draining")
 )
 
 // String implements the stringer interface
@@ -71,21 +105,79 @@ func (s State) String() string {
 	}
 }
 
+// MarshalJSON implements json.Marshaler, encoding the State as its name, e.g.
+// "half-open"
+func (s State) MarshalJSON() ([]byte, error) {
+	switch s {
+	case StateClosed, StateHalfOpen, StateOpen:
+		return json.Marshal(s.String())
+	default:
+		return nil, fmt.Errorf("circuitbreaker: can't marshal unknown state %d", uint32(s))
+	}
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts the names produced by
+// MarshalJSON as well as the numeric values encoded before States had names
+func (s *State) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var n uint32
+		if json.Unmarshal(data, &n) != nil {
+			return fmt.Errorf("circuitbreaker: state must be a string, got %s", data)
+		}
+		name = State(n).String()
+	}
+
+	for _, state := range []State{StateClosed, StateHalfOpen, StateOpen} {
+		if name == state.String() {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("circuitbreaker: unknown state %q, want closed, half-open or open", name)
+}
+
 // Counts holds the number of requests and their successes/failures.
 // CircuitBreaker clears the internal Counts either on change of state or at
 // the closed-state intervals
 type Counts struct {
-	CurrRequests         uint32
-	ConsecutiveSuccesses uint32
-	ConsecutiveFailures  uint32
+	CurrRequests         uint32 `json:"current_requests"`
+	ConsecutiveSuccesses uint32 `json:"consecutive_successes"`
+	ConsecutiveFailures  uint32 `json:"consecutive_failures"`
+	TotalSuccesses       uint32 `json:"total_successes"`
+	TotalFailures        uint32 `json:"total_failures"`
+
+	// Panics is the number of the TotalFailures that were requests panicking
+	// in Do, DoContext and the like, rather than returning an error
+	Panics uint32 `json:"panics"`
 }
 
 type Config struct {
+	// Name identifies the CircuitBreaker. If it is set, it's included in the
+	// errors returned for rejected requests
+	Name string
+
 	// MaxRequestsWhileHalfOpen is the maximum number of requests allowed to
 	// pass through when the CircuitBreaker is half-open. If it is set to zero
 	// (i.e. no value is set), only 1 request is allowed as the default
 	MaxRequestsWhileHalfOpen uint32
 
+	// SuccessThreshold is the number of consecutive successful probes that
+	// close a half-open CircuitBreaker, while MaxRequestsWhileHalfOpen remains
+	// the number of probes admitted. Since no more probes are admitted, it's
+	// capped at MaxRequestsWhileHalfOpen. If it is 0, it defaults to
+	// MaxRequestsWhileHalfOpen
+	SuccessThreshold uint32
+
+	// RecoveryRampDuration, if set, eases traffic back in after the
+	// CircuitBreaker closes from half-open: over this duration, the fraction
+	// of requests admitted grows linearly from a tenth to all, so the first
+	// request after closing is admitted, and the rest fail with ErrRecovering
+	// without being counted as failures. The ramp is part
+	// of the closed state, and tripping, Reset or a ForceMode ends it early.
+	// CanProceed doesn't consult it, since that would count a request
+	RecoveryRampDuration time.Duration
+
 	// Interval is the cyclic period/interval whereby the circuit breaker (while
 	// in the closed state) will reset the internal counts
 	Interval time.Duration
@@ -97,35 +189,576 @@ type Config struct {
 
 	// ShouldTrip is called with Counts whenever a request fails in the closed
 	// state. If ShouldTrip returns true, CircuitBreaker is set to the open
-	// state. If ShouldTrip is nil, a default callback is used which checks
-	// that number of consecutive failures is not more than 5.
+	// state. If ShouldTrip is nil, a default callback is used which trips once
+	// the number of consecutive failures exceeds ConsecutiveFailureThreshold,
+	// or once the FailureRatio reaches FailureRateThreshold
 	ShouldTrip func(counts Counts) bool
 
+	// ShouldTripContext, if set, is called instead of ShouldTrip with the
+	// Counts as well as the state history the decision might depend on, e.g.
+	// to trip more eagerly right after recovering, see TripContext
+	ShouldTripContext func(tc TripContext) bool
+
+	// ShouldTripFunc, if set, is called instead of ShouldTrip and can also
+	// decide how long to stay open for, e.g. briefly for a minor blip and
+	// longer for a hard outage. If it trips with an openFor above 0, the
+	// open state lasts openFor, in place of TimeoutOpenState as scaled by
+	// FailureSeverity and offset by TimeoutJitter. Otherwise, the open state
+	// lasts as usual. ShouldTripContext takes precedence over it
+	ShouldTripFunc func(counts Counts) (trip bool, openFor time.Duration)
+
+	// OnOutcome is called with the state and outcome of every request that's
+	// counted, successes as well as failures, before ShouldTrip is consulted,
+	// so that a ShouldTrip that keeps its own state can see every outcome, see
+	// EWMAShouldTrip. It's called with the mutex held and mustn't call back
+	// into the CircuitBreaker. Dry runs such as WouldTripOn aren't reported
+	OnOutcome func(state State, success bool)
+
+	// OnSuccess is called for every successful request that's counted, with
+	// the counts right after it was, e.g. to keep per-request metrics. It's
+	// called after the mutex is released, so it's free to call back into the
+	// CircuitBreaker. Dry runs and SubmitProbeResult aren't reported
+	OnSuccess func(counts Counts)
+
+	// OnFailure is like OnSuccess for every failed request, with the error it
+	// failed with: ErrRequestTimeout if it timed out, ErrRequestPanicked if it
+	// panicked, or nil if it was reported without one, as via a
+	// TwoStepCircuitBreaker
+	OnFailure func(counts Counts, err error)
+
+	// CanaryInterval is how long a closed CircuitBreaker can go without
+	// counting an outcome before the next request to complete is treated as a
+	// canary, so that a background poller calling Do on a rarely used
+	// dependency keeps the state fresh. A canary is a real request: it's
+	// counted in Counts and can trip the CircuitBreaker like any other. Its
+	// outcome is also reported to OnCanaryResult, and its failure weighs
+	// CanaryFailureWeight in TripContext.WeightedFailures. Ignored requests
+	// don't count as outcomes. If it is 0, there are no canaries
+	CanaryInterval time.Duration
+
+	// OnCanaryResult is called with the outcome of every canary, see
+	// CanaryInterval, once the mutex has been released
+	OnCanaryResult func(success bool)
+
+	// CanaryFailureWeight replaces FailureWeight for a failed canary, e.g. to
+	// have a failure after a long quiet spell count less towards tripping. If
+	// it is 0, a failed canary weighs as any other failure
+	CanaryFailureWeight float64
+
+	// ConsecutiveFailureThreshold is the number of consecutive failures the
+	// default ShouldTrip tolerates before tripping. If it is 0, it defaults to
+	// 5. It's ignored if ShouldTrip is set
+	ConsecutiveFailureThreshold uint32
+
+	// FailureRateThreshold is the FailureRatio, in the range (0, 1], at or
+	// above which the default ShouldTrip trips, provided at least
+	// MinimumRequests requests have completed. If it is 0, the default
+	// ShouldTrip doesn't consider the failure rate. It's ignored if ShouldTrip
+	// is set
+	FailureRateThreshold float64
+
+	// MinimumRequests is the number of requests the current generation must
+	// have seen, i.e. Counts.CurrRequests, before ShouldTrip is consulted at
+	// all, so that a failure streak on cold start doesn't trip the
+	// CircuitBreaker before there's meaningful traffic. The default
+	// ShouldTrip also waits for as many requests to have completed, counted
+	// over the RatioBasis, before it considers the failure rate. If it is 0,
+	// ShouldTrip is consulted on every failure
+	MinimumRequests uint32
+
 	// OnStateChange is called whenever the state of CircuitBreaker changes
 	OnStateChange func(from State, to State)
 
+	// RecoverPanics makes Do, DoContext and the generic variants return a
+	// PanicError for a request that panics, rather than raising the panic
+	// again on the caller's goroutine, e.g. where the caller has no recovery
+	// of its own. Either way, the panic counts as a failure, so a panicking
+	// half-open probe reopens the CircuitBreaker and hands back its slot
+	RecoverPanics bool
+
+	// OnReject is called whenever a request is rejected because the
+	// CircuitBreaker is open or over its half-open limit, with the state and
+	// the error returned for it, which wraps ErrOpenState or
+	// ErrTooManyRequests. It's called after the mutex is released, so it's
+	// free to call back into the CircuitBreaker
+	OnReject func(state State, err error)
+
+	// OnReset is called whenever a closed CircuitBreaker clears its counts
+	// because Interval, or MaxGenerationLifetime, ran out, with the counts
+	// that were discarded, e.g. to keep lifetime totals. It isn't called when
+	// the counts are cleared by a state change or by Reset. It's called after
+	// the mutex is released, so it's free to call back into the
+	// CircuitBreaker
+	OnReset func(previous Counts)
+
 	// IsSuccessful is called with the error that's returned from a request. If
 	// it returns true, the error is counted as a success. Otherwise, the error
-	// is counted as a failure. If IsSuccessful is used, a default callback is
-	// used which returns false for all non-nil errors
+	// is counted as a failure. Either way, the error is returned to the caller
+	// unchanged, see Outcome. If IsSuccessful is nil, a default callback is
+	// used which returns false for all non-nil errors. It's ignored if
+	// Classify is set
 	IsSuccessful func(err error) bool
+
+	// Classify is called with the error that's returned from a request and
+	// tells whether it counts as a success, a failure, or neither, see
+	// OutcomeIgnore. It's a richer IsSuccessful and takes precedence over it.
+	// If it is nil, the outcome is OutcomeSuccess or OutcomeFailure according
+	// to IsSuccessful
+	Classify func(err error) Outcome
+
+	// IsSuccessfulResult is like IsSuccessful but is also passed the result
+	// of the request, e.g. to count a response whose body holds an error
+	// status as a failure even though there's no error. If it's set, it takes
+	// precedence over IsSuccessful and Classify for Do, DoContext, DoBatch and
+	// the generic variants. Requests that don't hand back a result, such as
+	// two-step requests and Operations, are still classified by IsSuccessful or
+	// Classify
+	IsSuccessfulResult func(result interface{}, err error) bool
+
+	// BackoffHint, if set, is passed what every request made with Do,
+	// DoContext, DoBatch and the generic variants returned, and returns how
+	// long the dependency asked to be backed off from, e.g. parsed from a
+	// Retry-After header on a response that's otherwise fine, or 0 if it
+	// didn't. A request with a hint counts as OutcomeBackoff, whatever it's
+	// classified as, and opens the CircuitBreaker for the back-off
+	BackoffHint func(result interface{}, err error) time.Duration
+
+	// IsSuccessfulContext, if set, is used instead of IsSuccessful, Classify
+	// and IsSuccessfulResult by DoContext. It's also passed the request's
+	// context so that it can tell apart failures caused by the caller
+	// cancelling the request, which usually shouldn't count against the
+	// dependency, e.g.
+	//
+	//	func(ctx context.Context, err error) bool {
+	//		return err == nil || ctx.Err() != nil
+	//	}
+	IsSuccessfulContext func(ctx context.Context, err error) bool
+
+	// SlowCallThreshold is the duration beyond which a request is considered
+	// slow regardless of whether it succeeded. Slow calls are only counted,
+	// no latency distribution is kept so the per-request overhead is a single
+	// comparison. If SlowCallThreshold is 0, slow calls are not tracked
+	SlowCallThreshold time.Duration
+
+	// SlowCallRateThreshold is the ratio of slow calls to completed requests
+	// in the current generation, in the range (0, 1], at or above which the
+	// CircuitBreaker trips while in the closed state. It's checked after every
+	// request, not just failures, once SlowCallMinimumCalls requests have
+	// completed. If it is 0, slow calls never trip the CircuitBreaker
+	SlowCallRateThreshold float64
+
+	// SlowCallMinimumCalls is the number of requests that must complete in
+	// the current generation before SlowCallRateThreshold is checked, so that
+	// a single slow call right after a reset doesn't trip the CircuitBreaker.
+	// If it is 0, it defaults to 10
+	SlowCallMinimumCalls uint32
+
+	// MaxProbeAttempts is the number of times the CircuitBreaker may go from
+	// open to half-open before it gives up probing and stays open until Reset
+	// is called. It counts half-open entries rather than individual probe
+	// requests and is replenished whenever the CircuitBreaker closes. If it
+	// is 0, probing never stops
+	MaxProbeAttempts int
+
+	// ResetGracePeriod is the period following a call to Reset during which
+	// failures are still counted but can't trip the CircuitBreaker. It keeps
+	// stragglers from before the Reset from flipping a freshly closed
+	// CircuitBreaker straight back to open. If it is 0, there's no grace
+	// period
+	ResetGracePeriod time.Duration
+
+	// AsyncStateChange makes the CircuitBreaker deliver OnStateChange calls
+	// from a dedicated goroutine so that a slow callback never delays a
+	// request. Transitions are delivered in the order they occurred. If the
+	// queue is full, the oldest undelivered transition is dropped rather than
+	// blocking the request. Close must be called to stop the goroutine
+	AsyncStateChange bool
+
+	// StateChangeQueueSize is the number of transitions that can be waiting
+	// for delivery when AsyncStateChange is set. If it is 0, it defaults to 64
+	StateChangeQueueSize int
+
+	// RatioBasis selects the requests that FailureRatio is computed over. It
+	// defaults to RatioPerGeneration
+	RatioBasis RatioBasis
+
+	// RatioSampleSize is the number of most recent requests FailureRatio is
+	// computed over when RatioBasis is RatioLastNRequests. If it is 0, it
+	// defaults to 100
+	RatioSampleSize uint32
+
+	// IgnoreFirstN is the number of requests at the start of every generation
+	// whose outcomes are discarded, e.g. while connection pools and caches
+	// warm up. Ignored requests still execute and count towards CurrRequests.
+	// While half-open they take up a slot while in flight, but the slot is
+	// handed back once the outcome is discarded so that recovery isn't stalled
+	IgnoreFirstN uint32
+
+	// FailureSeverity classifies the error of a failed request. The severity
+	// of the failure that trips the CircuitBreaker scales how long it stays
+	// open: TimeoutOpenState is halved for SeverityTransient and doubled for
+	// SeveritySevere. If it is nil, or for failures without an error such as
+	// panics and two-step requests, failures are SeverityNormal
+	FailureSeverity func(err error) Severity
+
+	// FailureWeight weighs the error of a failed request, e.g. to have a
+	// timeout count more towards tripping than an error response. The weights
+	// of the failures in the current generation are summed up in
+	// TripContext.WeightedFailures for ShouldTripContext to decide on, while
+	// Counts keeps counting each failure as one. Weights of 0 or less count
+	// as 1: use Classify with OutcomeIgnore for failures that shouldn't count
+	// at all. If it is nil, or for failures without an error such as panics
+	// and two-step requests, failures weigh 1
+	FailureWeight func(err error) float64
+
+	// Notifiers are sent an Event on every state change, and whenever the
+	// CircuitBreaker trips, is Reset, or fails a probe and stays open. More can
+	// be added with AddNotifier. They're called in order, after the mutex is
+	// released, and a panicking Notifier doesn't affect the others or the
+	// request that caused the event
+	Notifiers []Notifier
+
+	// TraceIDs, if set, extracts the IDs of the trace and span a request made
+	// with DoContext runs under from its context, or empty strings if there
+	// are none. The IDs of the failed request that causes an EventTrip or
+	// EventSustainedOpen are then set on the event, e.g. for a metrics
+	// exemplar linking it to its trace. Only the IDs are kept, never the
+	// context. cbotel.TraceIDs extracts them for OpenTelemetry
+	TraceIDs func(ctx context.Context) (traceID, spanID string)
+
+	// MaxGenerationLifetime bounds how long a closed-state generation lasts,
+	// as a safety valve against counts that grow without bound when Interval
+	// is 0. If both are set, the shorter one decides when the counts are
+	// cleared. If it is 0, generations are only bounded by Interval
+	MaxGenerationLifetime time.Duration
+
+	// Trace is called for every request with the decision the CircuitBreaker
+	// made about it: once when it's rejected, or once its outcome has been
+	// reported. It's verbose and meant for targeted debugging. It's called
+	// after the mutex is released
+	Trace func(event TraceEvent)
+
+	// RateLimiter, if set, is consulted for every request the CircuitBreaker
+	// would otherwise admit, so that both make up a single admission check.
+	// The state is checked first: requests rejected for being open or over
+	// the half-open limit don't consume from the RateLimiter. Requests it
+	// denies fail with ErrRateLimited and aren't counted as failures
+	RateLimiter RateLimiter
+
+	// RateLimit, if set, caps the rate of admitted requests, in requests per
+	// second, with a token bucket used as the RateLimiter, refilled on the
+	// Clock's monotonic readings so that it doesn't drift with the wall
+	// clock. It's ignored if RateLimiter is set
+	RateLimit float64
+
+	// Burst is the capacity of the RateLimit token bucket, i.e. the number of
+	// requests that can be admitted at once after a quiet spell. If it is 0,
+	// it defaults to a second's worth of requests, RateLimit rounded up
+	Burst int
+
+	// ShadowShouldTrip is a candidate trip policy evaluated alongside
+	// ShouldTrip, whenever ShouldTrip is, without affecting the
+	// CircuitBreaker. Disagreements are counted in ShadowDivergence
+	ShadowShouldTrip func(counts Counts) bool
+
+	// ShadowIsSuccessful is a candidate classifier evaluated alongside
+	// IsSuccessful by Do without affecting the CircuitBreaker. Disagreements
+	// are counted in ShadowDivergence
+	ShadowIsSuccessful func(err error) bool
+
+	// RequestTimeout is how long Do and DoContext wait for a request before
+	// giving up on it with ErrRequestTimeout and counting it as a failure.
+	// The request then runs on its own goroutine and gets a context that's
+	// cancelled at the timeout, but Go can't stop a goroutine from the
+	// outside: a request that ignores its context keeps running until it
+	// returns, and whatever it returns is discarded. If it is 0, requests
+	// aren't timed out
+	RequestTimeout time.Duration
+
+	// WindowType selects how the counts passed to ShouldTrip are aggregated.
+	// It defaults to WindowInterval, which uses the counts of the current
+	// generation. With WindowCountBased or WindowTimeBased, TotalSuccesses
+	// and TotalFailures are aggregated over a sliding window instead, one
+	// that's not cleared by closed-state interval resets
+	WindowType WindowType
+
+	// WindowSize is the size of the sliding window, in requests for
+	// WindowCountBased and in seconds for WindowTimeBased. If it is 0, it
+	// defaults to 100 requests or 60 seconds respectively
+	WindowSize uint32
+
+	// Fallback, if set, is called by Do and DoContext with the error of a
+	// request the CircuitBreaker rejects, i.e. ErrOpenState,
+	// ErrTooManyRequests, ErrTooManyConcurrent or ErrRateLimited. Whatever it
+	// returns is returned instead. Fallback results aren't counted since no
+	// request was made. GenericCircuitBreaker doesn't use it since its
+	// results are typed
+	Fallback func(err error) (interface{}, error)
+
+	// FallbackOnError makes Do and DoContext call Fallback with the error of
+	// an admitted request as well, once its outcome has been counted as
+	// usual. It has no effect if Fallback isn't set
+	FallbackOnError bool
+
+	// TimeoutJitter spreads out the open-state timeouts of CircuitBreakers
+	// that trip together, so that they don't all start probing at once. Each
+	// open-state timeout is offset by a random duration in
+	// [-TimeoutJitter, +TimeoutJitter], but never ends before it starts. If
+	// it is 0, there's no jitter
+	TimeoutJitter time.Duration
+
+	// JitterSource is the source of randomness for TimeoutJitter, e.g. a
+	// seeded one for deterministic tests. If it is nil, it defaults to a
+	// source seeded with the current time. It may be shared by several
+	// CircuitBreakers, which then draw from it in turn
+	JitterSource rand.Source
+
+	// EventBufferSize is the capacity of the Events channel. Events are
+	// dropped rather than blocking when it's full. If it is 0, it defaults
+	// to 64
+	EventBufferSize int
+
+	// HalfOpenSuccessRatio, in the range (0, 1], switches half-open recovery
+	// from a streak of SuccessThreshold consecutive successes to a sample: up
+	// to HalfOpenMaxRequests probes are admitted, and once they've all
+	// completed the CircuitBreaker closes if the ratio of successful ones is
+	// at or above HalfOpenSuccessRatio, and reopens otherwise. It reopens
+	// early once the ratio is out of reach. If it is 0, the streak is used
+	HalfOpenSuccessRatio float64
+
+	// HalfOpenMaxRequests is the number of probes sampled when
+	// HalfOpenSuccessRatio is set. If it is 0, it defaults to
+	// MaxRequestsWhileHalfOpen
+	HalfOpenMaxRequests uint32
+
+	// HalfOpenTolerateFailures keeps a half-open CircuitBreaker sampling
+	// after a failed probe when HalfOpenSuccessRatio is set. Otherwise, a
+	// single failure reopens it right away, as it does without a ratio
+	HalfOpenTolerateFailures bool
+
+	// HalfOpenAdmissionStrategy decides which requests are admitted as probes
+	// while half-open, e.g. a LinearRampAdmission for a gradual ramp. If it is
+	// nil, a CapAdmission admits up to MaxRequestsWhileHalfOpen, or
+	// HalfOpenMaxRequests when HalfOpenSuccessRatio is set
+	HalfOpenAdmissionStrategy HalfOpenAdmissionStrategy
+
+	// MaxConcurrentRequests caps the number of admitted requests in flight at
+	// once, in any state, to protect the dependency. Requests over the cap
+	// fail fast with ErrTooManyConcurrent rather than queueing, and aren't
+	// counted as failures. A request is in flight from the moment it's
+	// admitted until its outcome is reported, so a two-step request whose
+	// callback is never called holds on to its place. If it is 0, there's no
+	// cap
+	MaxConcurrentRequests uint32
+
+	// UnhealthyRatio is the FailureRatio, in the range [0, 1], above which a
+	// closed CircuitBreaker reports itself unhealthy, see Healthy. It doesn't
+	// affect tripping. If it is 0, it defaults to 0.5
+	UnhealthyRatio float64
+
+	// HalfOpenSingleProbe admits a single half-open request at a time, so that
+	// a dependency that may still be down isn't hit by several probes at once.
+	// Further requests fail with ErrTooManyRequests until the probe's outcome
+	// is reported, after which the next one is admitted, up to the usual
+	// number of half-open requests
+	HalfOpenSingleProbe bool
+
+	// HalfOpenCloseOnFirstSuccess closes a half-open CircuitBreaker on its
+	// first successful probe rather than after SuccessThreshold consecutive
+	// ones, for faster recovery. A failed probe still reopens it. It takes
+	// precedence over HalfOpenSuccessRatio. The other probes admitted
+	// alongside it still run, but their outcomes belong to the half-open
+	// generation and are discarded
+	HalfOpenCloseOnFirstSuccess bool
+
+	// Counts, if set, is shared with other CircuitBreakers guarding the same
+	// dependency. Every CircuitBreaker records its closed-state outcomes in
+	// it, and ShouldTrip is called with its Snapshot rather than the
+	// CircuitBreaker's own counts, so that a failure seen by any of them can
+	// trip it on the aggregate. Each CircuitBreaker still has its own state
+	// and generations: interval resets and trips don't clear the aggregate,
+	// so that the others trip as well on their next failure. It's Reset
+	// whenever one of them closes, e.g. after recovering or on Reset, since
+	// the dependency is then deemed healthy again. Half-open probes and the
+	// slow call rate only use the CircuitBreaker's own counts, and WindowType
+	// has no effect on what ShouldTrip sees
+	Counts SharedCounts
+
+	// FailMode is what happens while Counts is a FallibleSharedCounts whose
+	// store is failing: with FailOpen, the default, ShouldTrip is called with
+	// the CircuitBreaker's own counts instead, and with FailClosed, requests
+	// are rejected with ErrSharedCountsUnavailable while closed. It has no
+	// effect otherwise
+	FailMode FailMode
+
+	// Clock is what the CircuitBreaker reads the time from, e.g. a
+	// cbtest.ManualClock in tests that need to move past Interval or
+	// TimeoutOpenState without waiting. Timers, i.e. RequestTimeout and the
+	// deadlines of two-step requests, still run on real time. If it is nil,
+	// it defaults to the system clock
+	Clock Clock
+
+	// HotPath is how requests are admitted and their successes counted while
+	// closed. With HotPathMutex, the default, every request takes the mutex,
+	// and with HotPathAtomic, a busy CircuitBreaker's admissions and
+	// successes are counted with atomic operations instead, see HotPathAtomic
+	// for the features it's incompatible with. HotPathAdaptive switches
+	// between the two depending on how contended the mutex is. Either way,
+	// the Counts, the transitions and the callbacks are the same
+	HotPath HotPath
 }
 
 // CircuitBreaker is a state machine  that prevents making requests that are
 // likely to fail
 type CircuitBreaker struct {
-	maxRequestsWhileHalfOpen uint32
-	interval                 time.Duration
-	timeoutOpenState         time.Duration
-	shouldTrip               func(counts Counts) bool
-	onStateChange            func(from State, to State)
-	isSuccessful             func(err error) bool
+	name                        string
+	maxRequestsWhileHalfOpen    uint32
+	successThreshold            uint32
+	recoveryRampDuration        time.Duration
+	ramp                        recoveryRamp
+	interval                    time.Duration
+	timeoutOpenState            time.Duration
+	shouldTrip                  func(counts Counts) bool
+	shouldTripContext           func(tc TripContext) bool
+	shouldTripFunc              func(counts Counts) (bool, time.Duration)
+	onOutcome                   func(state State, success bool)
+	onSuccess                   func(counts Counts)
+	onFailure                   func(counts Counts, err error)
+	canaryInterval              time.Duration
+	onCanaryResult              func(success bool)
+	canaryFailureWeight         float64
+	prevState                   State
+	consecutiveTrips            uint32
+	onStateChange               func(from State, to State)
+	onReject                    func(state State, err error)
+	onReset                     func(previous Counts)
+	recoverPanics               bool
+	isSuccessful                func(err error) bool
+	classifyErr                 func(err error) Outcome
+	isSuccessfulResult          func(result interface{}, err error) bool
+	backoffHint                 func(result interface{}, err error) time.Duration
+	isSuccessfulContext         func(ctx context.Context, err error) bool
+	slowCallThreshold           time.Duration
+	slowCallRateThreshold       float64
+	slowCallMinimumCalls        uint32
+	maxProbeAttempts            int
+	resetGracePeriod            time.Duration
+	stateChanges                chan stateChange
+	sample                      *outcomeSample
+	consecutiveFailureThreshold uint32
+	failureRateThreshold        float64
+	minimumRequests             uint32
+	ignoreFirstN                uint32
+	failureSeverity             func(err error) Severity
+	failureWeight               func(err error) float64
+	notifiers                   []Notifier
+	traceIDs                    func(ctx context.Context) (traceID, spanID string)
+	maxGenerationLifetime       time.Duration
+	trace                       func(event TraceEvent)
+	rateLimiter                 RateLimiter
+	shadowShouldTrip            func(counts Counts) bool
+	shadowIsSuccessful          func(err error) bool
+	requestTimeout              time.Duration
+	window                      slidingWindow
+	fallback                    func(err error) (interface{}, error)
+	fallbackOnError             bool
+	timeoutJitter               time.Duration
+	jitterRand                  *rand.Rand
+	eventBufferSize             int
+	halfOpenSuccessRatio        float64
+	halfOpenMaxRequests         uint32
+	halfOpenTolerateFailures    bool
+	halfOpenAdmission           HalfOpenAdmissionStrategy
+	maxConcurrentRequests       uint32
+	activeRequests              atomic.Uint32
+	draining                    atomic.Bool
+	view                        atomic.Pointer[stateView]
+	atomicEligible              bool
+	adaptive                    bool
+	fastAdmitted                genCounter
+	fastSucceeded               genCounter
+	lastStateChange             time.Time
+	unhealthyRatio              float64
+	halfOpenSingleProbe         bool
+	halfOpenCloseOnFirstSuccess bool
+	probeInFlight               bool
+	sharedCounts                SharedCounts
+	failMode                    FailMode
+	clock                       Clock
+	stats                       lifetimeStats
+	config                      Config
+	effective                   Config
+	dispatcherDone              chan struct{}
 
-	mu         sync.Mutex
-	state      State
-	generation uint64
-	counts     Counts
-	expiry     time.Time
+	mu            sync.Mutex
+	state         State
+	generation    uint64
+	counts        Counts
+	slowCalls     uint32
+	ignored       uint32
+	lastSeverity  Severity
+	probeAttempts int
+	forceMode     ForceMode
+	graceExpiry   time.Time
+	expiry        time.Time
+	closed        bool
+	drained       chan struct{}
+	pending       []func()
+	shadow        shadowDivergence
+	events        chan Event
+	droppedEvents uint64
+	history       []Event
+	failureLabels []string
+	failureTrace  traceRef
+	fastEnabled   bool
+	contention    contention
+	weighted      float64
+	lastOutcome   time.Time
+	newGeneration chan struct{}
+	openFor       time.Duration
+	rejected      uint32
+}
+
+// outcome describes how a request admitted by beforeRequest went
+type outcome struct {
+	success  bool
+	duration time.Duration
+	severity Severity
+
+	// probe is set for results submitted via SubmitProbeResult, which don't
+	// hold a slot and are never ignored
+	probe bool
+
+	// dryRun is set for WouldTripOn, which mustn't reach the shadow policy
+	dryRun bool
+
+	// label is the request's label, see WithLabel
+	label string
+
+	// trace identifies the trace of a failed request, see TraceIDs
+	trace traceRef
+
+	// ignore is set for OutcomeIgnore, in which case success is false but
+	// the request isn't a failure either
+	ignore bool
+
+	// backoff is set for OutcomeBackoff, in which case success is false but
+	// the request isn't a failure either. backoffFor is the back-off asked
+	// for, see BackoffHint, or 0 for TimeoutOpenState
+	backoff    bool
+	backoffFor time.Duration
+
+	// panicked is set for a failure that was a panic
+	panicked bool
+
+	// weight is the weight of a failure, see FailureWeight. If it is 0, the
+	// failure weighs 1
+	weight float64
+
+	// err is the error a failed request returned, ErrRequestTimeout if it
+	// timed out or ErrRequestPanicked if it panicked. It's nil for failures
+	// reported without one, e.g. via a TwoStepCircuitBreaker
+	err error
 }
 
 func (cfg *Config) setDefaults() {
@@ -133,6 +766,10 @@ func (cfg *Config) setDefaults() {
 		cfg.MaxRequestsWhileHalfOpen = 1
 	}
 
+	if cfg.SuccessThreshold == 0 || cfg.SuccessThreshold > cfg.MaxRequestsWhileHalfOpen {
+		cfg.SuccessThreshold = cfg.MaxRequestsWhileHalfOpen
+	}
+
 	if cfg.Interval <= 0 {
 		cfg.Interval = time.Duration(0) * time.Second
 	}
@@ -141,12 +778,55 @@ func (cfg *Config) setDefaults() {
 		cfg.TimeoutOpenState = time.Duration(60) * time.Second
 	}
 
-	if cfg.ShouldTrip == nil {
-		cfg.ShouldTrip = func(counts Counts) bool {
-			return counts.ConsecutiveFailures > 5
+	if cfg.HalfOpenMaxRequests == 0 {
+		cfg.HalfOpenMaxRequests = cfg.MaxRequestsWhileHalfOpen
+	}
+
+	if cfg.StateChangeQueueSize <= 0 {
+		cfg.StateChangeQueueSize = 64
+	}
+
+	if cfg.SlowCallMinimumCalls == 0 {
+		cfg.SlowCallMinimumCalls = 10
+	}
+
+	if cfg.UnhealthyRatio <= 0 {
+		cfg.UnhealthyRatio = 0.5
+	}
+
+	if cfg.EventBufferSize <= 0 {
+		cfg.EventBufferSize = 64
+	}
+
+	if cfg.RatioSampleSize == 0 {
+		cfg.RatioSampleSize = 100
+	}
+
+	if cfg.TimeoutJitter > 0 && cfg.JitterSource == nil {
+		cfg.JitterSource = rand.NewSource(time.Now().UnixNano())
+	}
+
+	if cfg.WindowSize == 0 {
+		switch cfg.WindowType {
+		case WindowCountBased:
+			cfg.WindowSize = 100
+		case WindowTimeBased:
+			cfg.WindowSize = 60
 		}
 	}
 
+	if cfg.ConsecutiveFailureThreshold == 0 {
+		cfg.ConsecutiveFailureThreshold = 5
+	}
+
+	if cfg.Clock == nil {
+		cfg.Clock = realClock{}
+	}
+
+	if cfg.RateLimit > 0 && cfg.Burst <= 0 {
+		cfg.Burst = int(math.Ceil(cfg.RateLimit))
+	}
+
 	if cfg.IsSuccessful == nil {
 		cfg.IsSuccessful = func(err error) bool {
 			return err == nil
@@ -156,96 +836,577 @@ func (cfg *Config) setDefaults() {
 
 // NewCircuitBreaker returns a new instance of CircuitBreaker with the given configuration
 func NewCircuitBreaker(cfg Config) *CircuitBreaker {
+	config := cfg
 	cfg.setDefaults()
 
 	cb := &CircuitBreaker{
-		onStateChange:            cfg.OnStateChange,
-		maxRequestsWhileHalfOpen: cfg.MaxRequestsWhileHalfOpen,
-		interval:                 cfg.Interval,
-		timeoutOpenState:         cfg.TimeoutOpenState,
-		shouldTrip:               cfg.ShouldTrip,
-		isSuccessful:             cfg.IsSuccessful,
-	}
-	cb.toNewGeneration(time.Now())
+		config:                      config,
+		effective:                   cfg,
+		name:                        cfg.Name,
+		onStateChange:               cfg.OnStateChange,
+		onReject:                    cfg.OnReject,
+		onReset:                     cfg.OnReset,
+		onSuccess:                   cfg.OnSuccess,
+		onFailure:                   cfg.OnFailure,
+		recoverPanics:               cfg.RecoverPanics,
+		maxRequestsWhileHalfOpen:    cfg.MaxRequestsWhileHalfOpen,
+		successThreshold:            cfg.SuccessThreshold,
+		recoveryRampDuration:        cfg.RecoveryRampDuration,
+		interval:                    cfg.Interval,
+		timeoutOpenState:            cfg.TimeoutOpenState,
+		shouldTrip:                  cfg.ShouldTrip,
+		shouldTripContext:           cfg.ShouldTripContext,
+		shouldTripFunc:              cfg.ShouldTripFunc,
+		onOutcome:                   cfg.OnOutcome,
+		canaryInterval:              cfg.CanaryInterval,
+		onCanaryResult:              cfg.OnCanaryResult,
+		canaryFailureWeight:         cfg.CanaryFailureWeight,
+		isSuccessful:                cfg.IsSuccessful,
+		classifyErr:                 cfg.Classify,
+		isSuccessfulResult:          cfg.IsSuccessfulResult,
+		backoffHint:                 cfg.BackoffHint,
+		isSuccessfulContext:         cfg.IsSuccessfulContext,
+		slowCallThreshold:           cfg.SlowCallThreshold,
+		slowCallRateThreshold:       cfg.SlowCallRateThreshold,
+		slowCallMinimumCalls:        cfg.SlowCallMinimumCalls,
+		maxProbeAttempts:            cfg.MaxProbeAttempts,
+		resetGracePeriod:            cfg.ResetGracePeriod,
+		consecutiveFailureThreshold: cfg.ConsecutiveFailureThreshold,
+		failureRateThreshold:        cfg.FailureRateThreshold,
+		minimumRequests:             cfg.MinimumRequests,
+		ignoreFirstN:                cfg.IgnoreFirstN,
+		failureSeverity:             cfg.FailureSeverity,
+		failureWeight:               cfg.FailureWeight,
+		notifiers:                   append([]Notifier(nil), cfg.Notifiers...),
+		traceIDs:                    cfg.TraceIDs,
+		maxGenerationLifetime:       cfg.MaxGenerationLifetime,
+		trace:                       cfg.Trace,
+		rateLimiter:                 cfg.RateLimiter,
+		shadowShouldTrip:            cfg.ShadowShouldTrip,
+		shadowIsSuccessful:          cfg.ShadowIsSuccessful,
+		requestTimeout:              cfg.RequestTimeout,
+		window:                      newSlidingWindow(cfg.WindowType, cfg.WindowSize),
+		fallback:                    cfg.Fallback,
+		fallbackOnError:             cfg.FallbackOnError,
+		timeoutJitter:               cfg.TimeoutJitter,
+		eventBufferSize:             cfg.EventBufferSize,
+		halfOpenSuccessRatio:        cfg.HalfOpenSuccessRatio,
+		halfOpenMaxRequests:         cfg.HalfOpenMaxRequests,
+		halfOpenTolerateFailures:    cfg.HalfOpenTolerateFailures,
+		halfOpenAdmission:           cfg.HalfOpenAdmissionStrategy,
+		maxConcurrentRequests:       cfg.MaxConcurrentRequests,
+		unhealthyRatio:              cfg.UnhealthyRatio,
+		halfOpenSingleProbe:         cfg.HalfOpenSingleProbe,
+		halfOpenCloseOnFirstSuccess: cfg.HalfOpenCloseOnFirstSuccess,
+		sharedCounts:                cfg.Counts,
+		failMode:                    cfg.FailMode,
+		clock:                       cfg.Clock,
+		atomicEligible:              atomicEligible(cfg),
+		adaptive:                    atomicEligible(cfg) && cfg.HotPath == HotPathAdaptive,
+		fastEnabled:                 cfg.HotPath == HotPathAtomic,
+	}
+	if cb.shouldTrip == nil {
+		cb.shouldTrip = cb.defaultShouldTrip
+	}
+	if cb.halfOpenAdmission == nil {
+		cb.halfOpenAdmission = CapAdmission{Max: cb.halfOpenRequestLimit()}
+	}
+	if cb.rateLimiter == nil && cfg.RateLimit > 0 {
+		cb.rateLimiter = newTokenBucket(cfg.RateLimit, cfg.Burst, cfg.Clock)
+	}
+	if cfg.TimeoutJitter > 0 {
+		cb.jitterRand = rand.New(lockedSource{src: cfg.JitterSource})
+	}
+	if cfg.RatioBasis == RatioLastNRequests {
+		cb.sample = newOutcomeSample(cfg.RatioSampleSize)
+	}
+	if cfg.AsyncStateChange && cfg.OnStateChange != nil {
+		cb.startDispatcher(cfg.StateChangeQueueSize)
+	}
+	now := cb.now()
+	cb.lastStateChange = now
+	cb.lastOutcome = now
+	cb.toNewGeneration(now)
 	return cb
 }
 
-// State returns the current state of the CircuitBreaker
+// unlock releases the mutex then runs the callbacks queued while it was held,
+// so that they're free to call back into the CircuitBreaker
+func (cb *CircuitBreaker) unlock() {
+	pending := cb.pending
+	cb.pending = nil
+	cb.mu.Unlock()
+
+	for _, fn := range pending {
+		fn()
+	}
+}
+
+// defaultShouldTrip trips on either too many consecutive failures or too high
+// a failure rate. It's called with the mutex held
+func (cb *CircuitBreaker) defaultShouldTrip(counts Counts) bool {
+	if counts.ConsecutiveFailures > cb.consecutiveFailureThreshold {
+		return true
+	}
+	if cb.failureRateThreshold <= 0 {
+		return false
+	}
+	ratio, completed := cb.failureRatio(counts)
+	return completed >= cb.minimumRequests && ratio >= cb.failureRateThreshold
+}
+
+// Name returns the name of the CircuitBreaker
+func (cb *CircuitBreaker) Name() string {
+	return cb.name
+}
+
+// Config returns a copy of the configuration the CircuitBreaker runs with,
+// i.e. with defaults applied, such as a TimeoutOpenState of 60 seconds if none
+// was set. Notifiers include those added with AddNotifier. Function fields are
+// returned as they were set: a nil ShouldTrip stays nil even though the
+// default policy is used in its place. JitterSource is only returned if it was
+// set, since the default one isn't safe to share
+func (cb *CircuitBreaker) Config() Config {
+	cb.lock()
+	defer cb.unlock()
+
+	cfg := cb.effective
+	cfg.JitterSource = cb.config.JitterSource
+	cfg.Notifiers = append([]Notifier(nil), cb.notifiers...)
+	return cfg
+}
+
+// State returns the current state of the CircuitBreaker. It only takes the
+// mutex when the current generation is over, e.g. when an open-state timeout
+// is over and the CircuitBreaker has to move to half-open, so that frequent
+// calls don't contend with requests
 func (cb *CircuitBreaker) State() State {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	now := cb.now()
+	if state, ok := cb.loadState(now); ok {
+		return state
+	}
+
+	cb.lock()
+	defer cb.unlock()
 
-	now := time.Now()
 	state, _ := cb.currentState(now)
 	return state
-
 }
 
 // Counts returns the internal counters
 func (cb *CircuitBreaker) Counts() Counts {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	cb.lock()
+	defer cb.unlock()
 
 	return cb.counts
 }
 
-func (cb *CircuitBreaker) beforeRequest() (uint64, error) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
+// RejectedCount returns the number of requests short-circuited in the current
+// generation, i.e. rejected because the CircuitBreaker is open or over its
+// half-open limit: the load it saved the dependency from. Since the counts of
+// an open CircuitBreaker are cleared once it becomes half-open, it's the
+// number rejected so far in the current open period. Requests rejected by
+// MaxConcurrentRequests or the RateLimiter aren't counted, while Stats counts
+// every rejection over the lifetime of the CircuitBreaker
+func (cb *CircuitBreaker) RejectedCount() uint32 {
+	cb.lock()
+	defer cb.unlock()
 
-	now := time.Now()
-	state, generation := cb.currentState(now)
+	cb.currentState(cb.now())
+	return cb.rejected
+}
+
+// Generation returns the number of the current generation. It's incremented
+// on every state change and closed-state interval reset, and whenever the
+// CircuitBreaker is Reset, so that log lines and Events can be correlated
+// with the counts they were taken from. An interval that ran out counts as a
+// reset even if nothing noticed until later
+func (cb *CircuitBreaker) Generation() uint64 {
+	cb.lock()
+	defer cb.unlock()
+
+	_, generation := cb.currentState(cb.now())
+	return generation
+}
+
+// LastStateChange returns when the CircuitBreaker last changed state, or when
+// it was created if it never has. An open CircuitBreaker whose timeout ran out
+// moved to half-open when the timeout did, even if nothing noticed until later
+func (cb *CircuitBreaker) LastStateChange() time.Time {
+	cb.lock()
+	defer cb.unlock()
+
+	cb.currentState(cb.now())
+	return cb.lastStateChange
+}
+
+// TimeInState returns how long the CircuitBreaker has been in its current
+// state, see LastStateChange
+func (cb *CircuitBreaker) TimeInState() time.Duration {
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	cb.currentState(now)
+	return now.Sub(cb.lastStateChange)
+}
+
+// ClearConsecutive zeroes ConsecutiveFailures and ConsecutiveSuccesses, e.g.
+// so that failures during a planned brief blip don't add up to a trip,
+// leaving the other Counts, the sliding window and the ratio sample as they
+// are. It doesn't change the state: an open CircuitBreaker stays open, and a
+// half-open one starts its streak of successful probes over
+func (cb *CircuitBreaker) ClearConsecutive() {
+	cb.lock()
+	defer cb.unlock()
+
+	cb.currentState(cb.now())
+	cb.counts.ConsecutiveFailures = 0
+	cb.counts.ConsecutiveSuccesses = 0
+}
+
+// Reset returns the CircuitBreaker to the closed state with cleared counts and
+// a replenished probe budget, regardless of its current state, and clears any
+// ForceMode. It also starts the ResetGracePeriod if one is configured
+func (cb *CircuitBreaker) Reset() {
+	cb.lock()
+	defer cb.unlock()
+
+	cb.reset(cb.now())
+}
+
+// ResetIfState is Reset, but only if the CircuitBreaker is in the given state,
+// which is checked under the same lock so that it can't change in between. It
+// reports whether the CircuitBreaker was reset
+func (cb *CircuitBreaker) ResetIfState(state State) bool {
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	if current, _ := cb.currentState(now); current != state {
+		return false
+	}
+	cb.reset(now)
+	return true
+}
+
+// reset implements Reset. It must be called with the mutex held
+func (cb *CircuitBreaker) reset(now time.Time) {
+	if cb.resetGracePeriod > 0 {
+		cb.graceExpiry = now.Add(cb.resetGracePeriod)
+	}
 
+	cb.forceMode = ForceModeNone
+	cb.consecutiveTrips = 0
+	from, counts := cb.state, cb.counts
+	if cb.state == StateClosed {
+		cb.sample.reset()
+		cb.resetWindow()
+		cb.resetShared()
+		cb.toNewGeneration(now)
+	} else {
+		cb.setState(StateClosed, now)
+	}
+	cb.ramp.stop()
+	cb.queueEvent(EventReset, from, counts, nil, traceRef{}, now)
+}
+
+// WouldTripOn reports whether a request admitted right now and completing with
+// the given outcome would make the CircuitBreaker trip to the open state. It's a
+// dry run: the counts and state are left as they were. ShouldTrip is still
+// called to make the prediction, so a ShouldTrip with side effects sees the
+// dry run, but ShadowShouldTrip isn't. It always returns false while the
+// CircuitBreaker is open since no request would be admitted
+func (cb *CircuitBreaker) WouldTripOn(success bool) bool {
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	state, _ := cb.currentState(now)
 	if state == StateOpen {
-		return generation, ErrOpenState
-	} else if state == StateHalfOpen && cb.counts.CurrRequests >= cb.maxRequestsWhileHalfOpen {
-		return generation, ErrTooManyRequests
+		return false
 	}
 
+	counts, slowCalls, ignored := cb.counts, cb.slowCalls, cb.ignored
+	sample, severity := cb.sample.clone(), cb.lastSeverity
+	window, weighted := cb.cloneWindow(), cb.weighted
+	defer func() {
+		cb.counts, cb.slowCalls, cb.ignored = counts, slowCalls, ignored
+		cb.sample, cb.lastSeverity = sample, severity
+		cb.window, cb.weighted = window, weighted
+	}()
+
 	cb.counts.CurrRequests++
-	return generation, nil
+	return cb.evaluate(state, outcome{success: success, dryRun: true}, now) == StateOpen
+}
+
+// probeBudgetExhausted reports whether the CircuitBreaker has used up all its
+// half-open entries for the current open episode
+func (cb *CircuitBreaker) probeBudgetExhausted() bool {
+	return cb.maxProbeAttempts > 0 && cb.probeAttempts >= cb.maxProbeAttempts
+}
+
+// beforeRequest admits or rejects a request, returning the state and
+// generation the decision was made in
+func (cb *CircuitBreaker) beforeRequest() (State, uint64, error) {
+	if generation, ok := cb.admitFast(); ok {
+		return StateClosed, generation, nil
+	}
+
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	state, generation := cb.currentState(now)
+
+	err := cb.admissionError(state)
+	if err == nil && !cb.rampAdmits(state, now) {
+		err = ErrRecovering
+	}
+	if err == nil && cb.rateLimiter != nil && !cb.rateLimiter.Allow() {
+		err = ErrRateLimited
+	}
+	if err != nil {
+		rejected := cb.rejectError(err, state)
+		cb.stats.rejections.Add(1)
+		if err == ErrOpenState || err == ErrTooManyRequests {
+			cb.rejected++
+			if cb.onReject != nil {
+				cb.pending = append(cb.pending, func() {
+					cb.onReject(state, rejected)
+				})
+			}
+		}
+		cb.traceRequest(TraceEvent{
+			Err:        rejected,
+			State:      state,
+			Generation: generation,
+			To:         state,
+		})
+		return state, generation, rejected
+	}
+
+	cb.counts.CurrRequests++
+	cb.stats.requests.Add(1)
+	cb.activeRequests.Add(1)
+	if state == StateHalfOpen && cb.halfOpenSingleProbe {
+		cb.probeInFlight = true
+	}
+	return state, generation, nil
+}
+
+// admissionError returns why a request would be rejected in the given state,
+// short of the RateLimiter, or nil if it would be admitted. It must be called
+// with the mutex held
+func (cb *CircuitBreaker) admissionError(state State) error {
+	switch {
+	case cb.draining.Load():
+		return ErrDraining
+	case state == StateOpen:
+		return ErrOpenState
+	case state == StateHalfOpen && (cb.probeInFlight || !cb.halfOpenAdmission.ShouldAdmit(cb.counts)):
+		return ErrTooManyRequests
+	case state == StateClosed && cb.failMode == FailClosed && cb.sharedCounts != nil && cb.sharedErr() != nil:
+		return ErrSharedCountsUnavailable
+	case cb.maxConcurrentRequests > 0 && cb.activeRequests.Load() >= cb.maxConcurrentRequests:
+		return ErrTooManyConcurrent
+	}
+	return nil
+}
+
+// CanProceed reports whether a request would be admitted right now, e.g. to
+// skip a backend before committing to a call. Unlike admitting a request, it
+// doesn't count anything. It makes the open to half-open transition if it's
+// due, as State does. The answer can be out of date by the time a request is
+// made, and the RateLimiter isn't consulted since that would use up its
+// allowance. A HalfOpenAdmissionStrategy is, so a random one may answer
+// differently the next time
+func (cb *CircuitBreaker) CanProceed() bool {
+	cb.lock()
+	defer cb.unlock()
+
+	state, _ := cb.currentState(cb.now())
+	return cb.admissionError(state) == nil
 }
 
 // Do runs the given request if the CircuitBreaker accepts it. Do returns an
 // error instantly if the CircuitBreaker is opened. Otherwise, Do returns the
 // result of the request. If a panic occurs in the request callback, the
 // CircuitBreaker handles it as an error and causes the same panic again.
+// The result and error of an admitted request are returned as they are,
+// however they're classified, see Outcome. If Fallback is set, it may replace
+// the result of a rejected or failed request
 func (cb *CircuitBreaker) Do(req func() (interface{}, error)) (interface{}, error) {
-	generation, err := cb.beforeRequest()
+	result, _, _, err := cb.doWithFallback(nil, func(context.Context) (interface{}, error) {
+		return req()
+	})
+	return result, err
+}
+
+// DoContext is like Do but passes ctx through to the request. If ctx is
+// already done, DoContext returns ctx.Err() without running the request or
+// counting it. If ctx is cancelled while the request runs, the outcome is
+// still recorded, classified with IsSuccessfulContext if it's set
+func (cb *CircuitBreaker) DoContext(ctx context.Context, req func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	result, _, _, err := cb.doWithFallback(ctx, req)
+	return result, err
+}
+
+// doRequest implements Do and DoContext for GenericCircuitBreaker, whose
+// results are typed and so don't go through Fallback. A nil ctx means the
+// request came in through Do
+func doRequest[T any](cb *CircuitBreaker, ctx context.Context, req func(ctx context.Context) (T, error)) (result T, err error) {
+	if ctx != nil {
+		if err := ctx.Err(); err != nil {
+			var zero T
+			return zero, err
+		}
+	}
+
+	_, generation, err := cb.beforeRequest()
 	if err != nil {
-		return nil, err
+		return result, err
+	}
+	return runAdmitted(cb, ctx, generation, req)
+}
+
+// runAdmitted runs a request admitted in the given generation and records its
+// outcome. A panic in the request is recorded as a failure and raised again,
+// or returned as a PanicError with RecoverPanics
+func runAdmitted[T any](cb *CircuitBreaker, ctx context.Context, generation uint64, req func(ctx context.Context) (T, error)) (result T, err error) {
+	if cb.requestTimeout > 0 {
+		return doRequestWithTimeout(cb, ctx, generation, req)
 	}
 
+	start := cb.now()
 	defer func() {
 		e := recover()
 		if e != nil {
-			cb.afterRequest(generation, false)
-			panic(e)
+			cb.afterRequest(generation, outcome{
+				success:  false,
+				duration: cb.since(start),
+				panicked: true,
+				err:      ErrRequestPanicked,
+			})
+			if !cb.recoverPanics {
+				panic(e)
+			}
+			var zero T
+			result, err = zero, PanicError{Value: e, Stack: debug.Stack()}
 		}
 	}()
 
-	result, err := req()
-	cb.afterRequest(generation, cb.isSuccessful(err))
+	result, err = req(ctx)
+	cb.afterResponse(ctx, generation, boxResult(cb, result), err, start)
 	return result, err
 }
 
+// afterResponse classifies what a request returned and records the outcome
+func (cb *CircuitBreaker) afterResponse(ctx context.Context, generation uint64, result interface{}, err error, start time.Time) {
+	cb.afterRequest(generation, cb.responseOutcome(ctx, result, err, start))
+}
+
+// boxResult returns result for IsSuccessfulResult, if it's set. Otherwise the
+// result isn't needed and boxing it would only cost an allocation
+func boxResult[T any](cb *CircuitBreaker, result T) interface{} {
+	if cb.isSuccessfulResult == nil && cb.backoffHint == nil {
+		return nil
+	}
+	return result
+}
+
+// responseOutcome classifies what a request returned
+func (cb *CircuitBreaker) responseOutcome(ctx context.Context, result interface{}, err error, start time.Time) outcome {
+	o := newOutcome(cb.classify(ctx, result, err), cb.since(start))
+	o.label = labelFrom(ctx)
+	if cb.backoffHint != nil {
+		if d := cb.backoffHint(result, err); d > 0 {
+			o.success, o.ignore, o.backoff, o.backoffFor = false, false, true, d
+		}
+	}
+	if o.ignore || o.backoff {
+		return o
+	}
+	cb.evaluateShadowClassification(err, o.success)
+	if !o.success {
+		o.severity = cb.severityOf(err)
+		o.weight = cb.weightOf(err)
+		o.err = err
+		o.trace = cb.traceOf(ctx)
+	}
+	return o
+}
+
+// traceRef identifies the trace and span of a request, see TraceIDs
+type traceRef struct {
+	traceID, spanID string
+}
+
+// traceOf returns the trace ctx carries, if TraceIDs is set
+func (cb *CircuitBreaker) traceOf(ctx context.Context) traceRef {
+	if ctx == nil || cb.traceIDs == nil {
+		return traceRef{}
+	}
+	traceID, spanID := cb.traceIDs(ctx)
+	return traceRef{traceID, spanID}
+}
+
+// classify tells how a request's result and error count
+func (cb *CircuitBreaker) classify(ctx context.Context, result interface{}, err error) Outcome {
+	if _, ok := err.(IgnoredError); ok {
+		return OutcomeIgnore
+	}
+	if ctx != nil && cb.isSuccessfulContext != nil {
+		return outcomeOf(cb.isSuccessfulContext(ctx, err))
+	}
+	if cb.isSuccessfulResult != nil {
+		return outcomeOf(cb.isSuccessfulResult(result, err))
+	}
+	return cb.classifyError(err)
+}
+
 func (cb *CircuitBreaker) toNewGeneration(now time.Time) {
 	cb.generation++
 	// clear counts
 	cb.counts = Counts{}
+	cb.slowCalls = 0
+	cb.ignored = 0
+	cb.failureLabels = nil
+	cb.failureTrace = traceRef{}
+	cb.probeInFlight = false
+	cb.weighted = 0
+	cb.rejected = 0
 
+	cb.expiry = cb.generationExpiry(now)
+	cb.openFor = 0
+	cb.publish()
+	cb.signalGeneration()
+}
+
+// generationExpiry returns when the current generation of the current state
+// ends, or zero if it only ends on a request or a manual change
+func (cb *CircuitBreaker) generationExpiry(now time.Time) time.Time {
 	var zero time.Time
 	switch cb.state {
 	case StateClosed:
-		if cb.interval == 0 {
-			cb.expiry = zero
-		} else {
-			cb.expiry = now.Add(cb.interval)
+		lifetime := cb.interval
+		if cb.maxGenerationLifetime > 0 && (lifetime == 0 || cb.maxGenerationLifetime < lifetime) {
+			lifetime = cb.maxGenerationLifetime
 		}
+		if lifetime == 0 {
+			return zero
+		}
+		return now.Add(lifetime)
 	case StateOpen:
-		cb.expiry = now.Add(cb.timeoutOpenState)
-	case StateHalfOpen:
-		cb.expiry = zero
+		if cb.probeBudgetExhausted() || cb.forceMode == ForceModeOpenIndefinitely {
+			return zero // stay open until Reset
+		}
+		if cb.openFor > 0 {
+			return now.Add(cb.openFor)
+		}
+		return now.Add(cb.jitter(cb.lastSeverity.scale(cb.timeoutOpenState)))
+	default:
+		return zero
 	}
 }
 
@@ -253,11 +1414,21 @@ func (cb *CircuitBreaker) currentState(now time.Time) (State, uint64) {
 	switch cb.state {
 	case StateClosed:
 		if !cb.expiry.IsZero() && cb.expiry.Before(now) {
+			cb.consecutiveTrips = 0 // a whole generation without tripping
+			if cb.onReset != nil {
+				previous := cb.counts
+				cb.pending = append(cb.pending, func() {
+					cb.onReset(previous)
+				})
+			}
 			cb.toNewGeneration(now)
 		}
 	case StateOpen:
-		if cb.expiry.Before(now) {
+		if !cb.expiry.IsZero() && cb.expiry.Before(now) {
+			expiry := cb.expiry
+			cb.probeAttempts++
 			cb.setState(StateHalfOpen, now)
+			cb.lastStateChange = expiry // when it became half-open
 		}
 	}
 	return cb.state, cb.generation
@@ -268,44 +1439,280 @@ func (cb *CircuitBreaker) setState(newState State, now time.Time) {
 		return
 	}
 
-	prev := cb.state
+	prev, counts, labels, trace := cb.state, cb.counts, cb.failureLabels, cb.failureTrace
 	cb.state = newState
+	cb.prevState = prev
+	cb.lastStateChange = now
+	if prev == StateClosed && newState == StateOpen {
+		cb.consecutiveTrips++
+	}
+	if newState == StateOpen {
+		cb.stats.trips.Add(1)
+	}
+	if cb.forceMode == ForceModeOpen && newState != StateOpen {
+		cb.forceMode = ForceModeNone
+	}
+	cb.sample.reset()
+	cb.resetWindow()
+	if newState == StateClosed {
+		cb.probeAttempts = 0
+		cb.resetShared()
+	}
+	if prev == StateHalfOpen && newState == StateClosed && cb.recoveryRampDuration > 0 {
+		cb.ramp.start(now)
+	} else {
+		cb.ramp.stop()
+	}
 
 	cb.toNewGeneration(now)
+	cb.queueEvent(EventStateChange, prev, counts, nil, traceRef{}, now)
+
+	if newState == StateOpen {
+		switch prev {
+		case StateClosed:
+			cb.queueEvent(EventTrip, prev, counts, labels, trace, now)
+		case StateHalfOpen:
+			cb.queueEvent(EventSustainedOpen, prev, counts, labels, trace, now)
+		}
+	}
 
 	if cb.onStateChange != nil {
-		cb.onStateChange(prev, newState)
+		cb.notifyStateChange(prev, newState)
 	}
 }
 
-func (cb *CircuitBreaker) afterRequest(before uint64, success bool) {
-	// if state is Open, this function should not be called
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
+// inGracePeriod reports whether tripping is suppressed following a Reset
+func (cb *CircuitBreaker) inGracePeriod(now time.Time) bool {
+	return !cb.graceExpiry.IsZero() && now.Before(cb.graceExpiry)
+}
 
-	now := time.Now()
-	state, generation := cb.currentState(now)
-	if generation != before {
-		return
+// isSlowCallRateExceeded reports whether the slow calls in the current
+// generation have reached the configured share of completed requests
+func (cb *CircuitBreaker) isSlowCallRateExceeded() bool {
+	completed := cb.counts.TotalSuccesses + cb.counts.TotalFailures
+	if cb.slowCallRateThreshold <= 0 || completed == 0 || completed < cb.slowCallMinimumCalls {
+		return false
+	}
+	rate := float64(cb.slowCalls) / float64(completed)
+	return rate >= cb.slowCallRateThreshold
+}
+
+// evaluate records the outcome of a request in the counts of the current
+// generation and returns the state the CircuitBreaker should move to. It
+// doesn't change the state itself so that it can also back dry runs
+func (cb *CircuitBreaker) evaluate(state State, o outcome, now time.Time) State {
+	if o.ignore {
+		if state == StateHalfOpen {
+			cb.counts.CurrRequests-- // hand back the slot
+		}
+		return state
+	}
+	if o.backoff {
+		return cb.evaluateBackoff(state, o, now)
+	}
+	if !o.probe && cb.ignored < cb.ignoreFirstN {
+		cb.ignored++
+		if state == StateHalfOpen {
+			cb.counts.CurrRequests-- // hand back the slot
+		}
+		return state
 	}
 
-	if success { // on success
+	canary := cb.isCanary(state, o, now)
+	cb.lastSeverity = o.severity
+	if cb.slowCallThreshold > 0 && o.duration > cb.slowCallThreshold {
+		cb.slowCalls++
+	}
+
+	cb.sample.record(o.success)
+	cb.recordStats(o)
+	cb.recordShared(state, o)
+	if cb.onOutcome != nil && !o.dryRun {
+		cb.onOutcome(state, o.success)
+	}
+	if cb.window != nil {
+		cb.window.record(now, o.success)
+	}
+	if o.success { // on success
+		cb.counts.TotalSuccesses++
 		cb.counts.ConsecutiveSuccesses++
 		cb.counts.ConsecutiveFailures = 0
-		if cb.counts.ConsecutiveSuccesses >= cb.maxRequestsWhileHalfOpen {
-			cb.setState(StateClosed, now) // no-op if state is already Closed
+		cb.queueCallHook(o)
+		if state == StateHalfOpen {
+			return cb.evaluateHalfOpen(true)
 		}
 	} else { // on failure
+		cb.counts.TotalFailures++
+		cb.counts.ConsecutiveFailures++
+		cb.counts.ConsecutiveSuccesses = 0
+		if o.panicked {
+			cb.counts.Panics++
+		}
+		cb.queueCallHook(o)
+		if canary && cb.canaryFailureWeight > 0 {
+			cb.weighted += cb.canaryFailureWeight
+		} else {
+			cb.weighted += o.failureWeight()
+		}
 		switch state {
 		case StateClosed:
-			cb.counts.ConsecutiveFailures++
-			cb.counts.ConsecutiveSuccesses = 0
-			if cb.shouldTrip(cb.counts) {
-				cb.setState(StateOpen, now)
+			if cb.inGracePeriod(now) || cb.forceMode == ForceModeClosed {
+				return state
+			}
+			counts := cb.tripCounts(now)
+			if cb.sharedCounts != nil && cb.sharedErr() == nil {
+				counts = cb.sharedTripCounts(o.dryRun)
+			}
+			if counts.CurrRequests < cb.minimumRequests {
+				break // still warming up
+			}
+			trip, openFor := cb.callShouldTrip(counts)
+			if !o.dryRun {
+				cb.evaluateShadowTrip(counts, trip)
+			}
+			if trip {
+				if !o.dryRun {
+					cb.openFor = openFor
+				}
+				return StateOpen
 			}
 		case StateHalfOpen:
-			// if a faiilure
-			cb.setState(StateOpen, now)
+			return cb.evaluateHalfOpen(false)
+		}
+	}
+
+	if state == StateClosed && !cb.inGracePeriod(now) && cb.forceMode != ForceModeClosed && cb.isSlowCallRateExceeded() {
+		return StateOpen
+	}
+	return state
+}
+
+// queueCallHook queues OnSuccess or OnFailure for a request's outcome, with
+// the counts as they are once it's been counted. It must be called with the
+// mutex held
+func (cb *CircuitBreaker) queueCallHook(o outcome) {
+	if o.dryRun || o.probe {
+		return
+	}
+	counts := cb.counts
+	switch {
+	case o.success && cb.onSuccess != nil:
+		cb.pending = append(cb.pending, func() { cb.onSuccess(counts) })
+	case !o.success && cb.onFailure != nil:
+		cb.pending = append(cb.pending, func() { cb.onFailure(counts, o.err) })
+	}
+}
+
+// halfOpenRequestLimit is the number of requests admitted while half-open
+func (cb *CircuitBreaker) halfOpenRequestLimit() uint32 {
+	if cb.halfOpenSuccessRatio > 0 {
+		return cb.halfOpenMaxRequests
+	}
+	return cb.maxRequestsWhileHalfOpen
+}
+
+// evaluateHalfOpen returns the state a half-open CircuitBreaker moves to once
+// a probe's outcome has been counted
+func (cb *CircuitBreaker) evaluateHalfOpen(success bool) State {
+	if cb.halfOpenCloseOnFirstSuccess {
+		if success {
+			return StateClosed
+		}
+		return StateOpen
+	}
+	if cb.halfOpenSuccessRatio <= 0 {
+		if success && cb.counts.ConsecutiveSuccesses >= cb.successThreshold {
+			return StateClosed
+		} else if !success {
+			return StateOpen
+		}
+		return StateHalfOpen
+	}
+
+	if !success && !cb.halfOpenTolerateFailures {
+		return StateOpen
+	}
+	sampled := float64(cb.halfOpenMaxRequests)
+	if (sampled-float64(cb.counts.TotalFailures))/sampled < cb.halfOpenSuccessRatio {
+		return StateOpen // out of reach
+	}
+	completed := cb.counts.TotalSuccesses + cb.counts.TotalFailures
+	if completed < cb.halfOpenMaxRequests {
+		return StateHalfOpen
+	}
+	if float64(cb.counts.TotalSuccesses)/float64(completed) >= cb.halfOpenSuccessRatio {
+		return StateClosed
+	}
+	return StateOpen
+}
+
+func (cb *CircuitBreaker) afterRequest(before uint64, o outcome) {
+	defer cb.releaseActive()
+	if cb.recordFast(before, o) {
+		return
+	}
+	cb.recordOutcome(before, o)
+}
+
+// recordOutcome is afterRequest for a request that doesn't have a place of
+// its own among the requests in flight, see DoBatch
+func (cb *CircuitBreaker) recordOutcome(before uint64, o outcome) {
+	// if state is Open, this function should not be called
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	state, generation := cb.currentState(now)
+	defer cb.traceOutcome(state, before, o, generation != before)
+	if generation != before {
+		return
+	}
+
+	cb.probeInFlight = false
+	if !o.success && !o.ignore && !o.backoff {
+		cb.recordFailureLabel(o.label)
+		cb.failureTrace = o.trace
+	}
+	cb.setState(cb.evaluate(state, o, now), now)
+}
+
+// afterBulkRequest records the outcomes of a single admitted request that
+// fanned out into several operations. While half-open the request is a single
+// probe, successful only if none of its operations failed. Otherwise the
+// successes are recorded before the failures, one at a time, stopping at the
+// first one that causes a transition
+func (cb *CircuitBreaker) afterBulkRequest(before uint64, successes, failures uint32, duration time.Duration) {
+	if successes == 0 && failures == 0 {
+		successes = 1
+	}
+	defer cb.releaseActive()
+
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	state, generation := cb.currentState(now)
+	defer cb.traceOutcome(state, before, outcome{success: failures == 0}, generation != before)
+	if generation != before {
+		return
+	}
+
+	if state == StateHalfOpen {
+		cb.probeInFlight = false
+		o := outcome{success: failures == 0, duration: duration}
+		cb.setState(cb.evaluate(state, o, now), now)
+		return
+	}
+
+	o := outcome{success: true, duration: duration}
+	for i := uint32(0); i < successes+failures; i++ {
+		if i == successes {
+			o.success = false
+		}
+		if next := cb.evaluate(state, o, now); next != state {
+			cb.setState(next, now)
+			return
 		}
+		o.duration = 0 // only the request as a whole can be slow
 	}
 }
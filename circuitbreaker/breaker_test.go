@@ -24,6 +24,8 @@ THE SOFTWARE.
 package circuitbreaker
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"runtime"
 	"sync"
@@ -39,9 +41,19 @@ type stateChangeTracker struct {
 }
 
 func pseudoSleep(cb *CircuitBreaker, period time.Duration) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
 	if !cb.expiry.IsZero() {
 		cb.expiry = cb.expiry.Add(-period)
 	}
+	if !cb.graceExpiry.IsZero() {
+		cb.graceExpiry = cb.graceExpiry.Add(-period)
+	}
+	if w, ok := cb.window.(*timeWindow); ok && !w.headStart.IsZero() {
+		w.headStart = w.headStart.Add(-period)
+	}
+	cb.publish()
 }
 
 func succeed(cb *CircuitBreaker) error {
@@ -129,6 +141,43 @@ func TestStateConstants(t *testing.T) {
 	assert.Equal(t, State(100).String(), "unknown state: 100")
 }
 
+func TestStateJSON(t *testing.T) {
+	b, err := json.Marshal(map[string]State{"state": StateHalfOpen})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"state":"half-open"}`, string(b))
+	_, err = json.Marshal(State(100))
+	assert.NotNil(t, err)
+
+	for _, state := range []State{StateClosed, StateHalfOpen, StateOpen} {
+		b, err := json.Marshal(state)
+		assert.Nil(t, err)
+		var decoded State
+		assert.Nil(t, json.Unmarshal(b, &decoded))
+		assert.Equal(t, state, decoded)
+	}
+
+	// numeric values from before States had names
+	var decoded State
+	assert.Nil(t, json.Unmarshal([]byte("2"), &decoded))
+	assert.Equal(t, StateOpen, decoded)
+
+	err = json.Unmarshal([]byte(`"ajar"`), &decoded)
+	assert.EqualError(t, err, `circuitbreaker: unknown state "ajar", want closed, half-open or open`)
+	assert.NotNil(t, json.Unmarshal([]byte("7"), &decoded))
+	assert.NotNil(t, json.Unmarshal([]byte("true"), &decoded))
+
+	b, err = json.Marshal(Counts{1, 2, 3, 4, 5, 1})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"current_requests": 1,
+		"consecutive_successes": 2,
+		"consecutive_failures": 3,
+		"total_successes": 4,
+		"total_failures": 5,
+		"panics": 1
+	}`, string(b))
+}
+
 func TestNewCircuitBreaker(t *testing.T) {
 	defaultCB := NewCircuitBreaker(Config{})
 	assert.Equal(t, uint32(1), defaultCB.maxRequestsWhileHalfOpen)
@@ -137,7 +186,7 @@ func TestNewCircuitBreaker(t *testing.T) {
 	assert.NotNil(t, defaultCB.shouldTrip)
 	assert.Nil(t, defaultCB.onStateChange)
 	assert.Equal(t, StateClosed, defaultCB.state)
-	assert.Equal(t, Counts{0, 0, 0}, defaultCB.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, defaultCB.counts)
 	assert.True(t, defaultCB.expiry.IsZero())
 
 	customCB := newCustom(nil)
@@ -147,7 +196,7 @@ func TestNewCircuitBreaker(t *testing.T) {
 	assert.NotNil(t, customCB.shouldTrip)
 	assert.NotNil(t, customCB.onStateChange)
 	assert.Equal(t, StateClosed, customCB.state)
-	assert.Equal(t, Counts{0, 0, 0}, customCB.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, customCB.counts)
 	assert.False(t, customCB.expiry.IsZero())
 
 	negativeDurationCB := newNegativeDurationCB()
@@ -157,37 +206,90 @@ func TestNewCircuitBreaker(t *testing.T) {
 	assert.NotNil(t, negativeDurationCB.shouldTrip)
 	assert.Nil(t, negativeDurationCB.onStateChange)
 	assert.Equal(t, StateClosed, negativeDurationCB.state)
-	assert.Equal(t, Counts{0, 0, 0}, negativeDurationCB.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, negativeDurationCB.counts)
 	assert.True(t, negativeDurationCB.expiry.IsZero())
 }
 
+func TestConfig(t *testing.T) {
+	cfg := NewCircuitBreaker(Config{Name: "cb", MaxRequestsWhileHalfOpen: 3}).Config()
+	assert.Equal(t, "cb", cfg.Name)
+	assert.Equal(t, uint32(3), cfg.MaxRequestsWhileHalfOpen)
+	assert.Equal(t, uint32(3), cfg.SuccessThreshold)
+	assert.Equal(t, time.Duration(60)*time.Second, cfg.TimeoutOpenState)
+	assert.Nil(t, cfg.ShouldTrip)
+
+	cb := NewCircuitBreaker(Config{
+		TimeoutJitter: time.Second,
+		Notifiers:     []Notifier{FuncNotifier(func(Event) {})},
+	})
+	cb.AddNotifier(FuncNotifier(func(Event) {}))
+	cfg = cb.Config()
+	assert.Nil(t, cfg.JitterSource)
+	assert.Len(t, cfg.Notifiers, 2)
+
+	// a copy
+	cfg.Notifiers[0] = nil
+	cfg.MaxRequestsWhileHalfOpen = 10
+	assert.NotNil(t, cb.Config().Notifiers[0])
+	assert.Equal(t, uint32(1), cb.Config().MaxRequestsWhileHalfOpen)
+}
+
+func TestCanProceed(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Config{MaxConcurrentRequests: 2})
+	cb := tscb.cb
+	assert.True(t, cb.CanProceed())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+
+	done1, err := tscb.Allow()
+	assert.Nil(t, err)
+	done2, err := tscb.Allow()
+	assert.Nil(t, err)
+	assert.False(t, cb.CanProceed())
+	done1(true)
+	done2(true)
+	assert.True(t, cb.CanProceed())
+
+	cb.ForceOpen()
+	assert.False(t, cb.CanProceed())
+
+	// makes the transition to half-open
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.True(t, cb.CanProceed())
+	assert.Equal(t, StateHalfOpen, cb.state)
+	done, err := tscb.Allow()
+	assert.Nil(t, err)
+	assert.False(t, cb.CanProceed())
+	done(true)
+	assert.Equal(t, StateClosed, cb.State())
+}
+
 func TestDefaultCircuitBreaker(t *testing.T) {
 	defaultCB := NewCircuitBreaker(Config{})
 	for i := 0; i < 5; i++ {
 		assert.Nil(t, fail(defaultCB))
 	}
 	assert.Equal(t, StateClosed, defaultCB.State())
-	assert.Equal(t, Counts{5, 0, 5}, defaultCB.counts)
+	assert.Equal(t, Counts{5, 0, 5, 0, 5, 0}, defaultCB.counts)
 
 	assert.Nil(t, succeed(defaultCB))
 	assert.Equal(t, StateClosed, defaultCB.State())
-	assert.Equal(t, Counts{6, 1, 0}, defaultCB.counts)
+	assert.Equal(t, Counts{6, 1, 0, 1, 5, 0}, defaultCB.counts)
 
 	assert.Nil(t, fail(defaultCB))
 	assert.Equal(t, StateClosed, defaultCB.State())
-	assert.Equal(t, Counts{7, 0, 1}, defaultCB.counts)
+	assert.Equal(t, Counts{7, 0, 1, 1, 6, 0}, defaultCB.counts)
 
 	// StateClosed to StateOpen
 	for i := 0; i < 5; i++ {
 		assert.Nil(t, fail(defaultCB)) // 6 consecutive failures
 	}
 	assert.Equal(t, StateOpen, defaultCB.State())
-	assert.Equal(t, Counts{0, 0, 0}, defaultCB.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, defaultCB.counts)
 	assert.False(t, defaultCB.expiry.IsZero())
 
 	assert.Error(t, succeed(defaultCB))
 	assert.Error(t, fail(defaultCB))
-	assert.Equal(t, Counts{0, 0, 0}, defaultCB.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, defaultCB.counts)
 
 	pseudoSleep(defaultCB, time.Duration(59)*time.Second)
 	assert.Equal(t, StateOpen, defaultCB.State())
@@ -200,7 +302,7 @@ func TestDefaultCircuitBreaker(t *testing.T) {
 	// StateHalfOpen to StateOpen
 	assert.Nil(t, fail(defaultCB))
 	assert.Equal(t, StateOpen, defaultCB.State())
-	assert.Equal(t, Counts{0, 0, 0}, defaultCB.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, defaultCB.counts)
 	assert.False(t, defaultCB.expiry.IsZero())
 
 	// StateOpen to StateHalfOpen
@@ -211,7 +313,7 @@ func TestDefaultCircuitBreaker(t *testing.T) {
 	// StateHalfOpen to StateClosed
 	assert.Nil(t, succeed(defaultCB))
 	assert.Equal(t, StateClosed, defaultCB.State())
-	assert.Equal(t, Counts{0, 0, 0}, defaultCB.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, defaultCB.counts)
 	assert.True(t, defaultCB.expiry.IsZero())
 }
 
@@ -224,23 +326,23 @@ func TestCustomCircuitBreaker(t *testing.T) {
 		assert.Nil(t, fail(customCB))
 	}
 	assert.Equal(t, StateClosed, customCB.State())
-	assert.Equal(t, Counts{10, 0, 1}, customCB.counts)
+	assert.Equal(t, Counts{10, 0, 1, 5, 5, 0}, customCB.counts)
 
 	pseudoSleep(customCB, time.Duration(29)*time.Second)
 	assert.Nil(t, succeed(customCB))
 	assert.Equal(t, StateClosed, customCB.State())
-	assert.Equal(t, Counts{11, 1, 0}, customCB.counts)
+	assert.Equal(t, Counts{11, 1, 0, 6, 5, 0}, customCB.counts)
 
 	pseudoSleep(customCB, time.Duration(1)*time.Second) // over Interval
 	assert.Nil(t, fail(customCB))
 	assert.Equal(t, StateClosed, customCB.State())
-	assert.Equal(t, Counts{1, 0, 1}, customCB.counts)
+	assert.Equal(t, Counts{1, 0, 1, 0, 1, 0}, customCB.counts)
 
 	// StateClosed to StateOpen
 	assert.Nil(t, succeed(customCB))
 	assert.Nil(t, fail(customCB)) // failure ratio: 2/3 >= 0.6
 	assert.Equal(t, StateOpen, customCB.State())
-	assert.Equal(t, Counts{0, 0, 0}, customCB.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, customCB.counts)
 	assert.False(t, customCB.expiry.IsZero())
 	assert.Equal(t, stateChangeTracker{StateClosed, StateOpen}, stateChange)
 
@@ -253,16 +355,16 @@ func TestCustomCircuitBreaker(t *testing.T) {
 	assert.Nil(t, succeed(customCB))
 	assert.Nil(t, succeed(customCB))
 	assert.Equal(t, StateHalfOpen, customCB.State())
-	assert.Equal(t, Counts{2, 2, 0}, customCB.counts)
+	assert.Equal(t, Counts{2, 2, 0, 2, 0, 0}, customCB.counts)
 
 	// StateHalfOpen to StateClosed
 	ch := succeedLater(customCB, time.Duration(100)*time.Millisecond) // 3 consecutive successes
 	time.Sleep(time.Duration(50) * time.Millisecond)
-	assert.Equal(t, Counts{3, 2, 0}, customCB.counts)
+	assert.Equal(t, Counts{3, 2, 0, 2, 0, 0}, customCB.counts)
 	assert.Error(t, succeed(customCB)) // over MaxRequests
 	assert.Nil(t, <-ch)
 	assert.Equal(t, StateClosed, customCB.State())
-	assert.Equal(t, Counts{0, 0, 0}, customCB.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, customCB.counts)
 	assert.False(t, customCB.expiry.IsZero())
 	assert.Equal(t, stateChangeTracker{StateHalfOpen, StateClosed}, stateChange)
 }
@@ -272,11 +374,118 @@ func TestPanicInRequest(t *testing.T) {
 	assert.Panics(t, func() {
 		req := func() (interface{}, error) {
 			panic("oops")
-			return nil, nil
 		}
 		_, _ = defaultCB.Do(req)
 	})
-	assert.Equal(t, Counts{1, 0, 1}, defaultCB.counts)
+	assert.Equal(t, Counts{1, 0, 1, 0, 1, 1}, defaultCB.counts)
+}
+
+func TestTripOnPanics(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		ShouldTrip: func(counts Counts) bool {
+			return counts.Panics > 0
+		},
+	})
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, Counts{10, 0, 10, 0, 10, 0}, cb.Counts())
+
+	assert.Panics(t, func() {
+		_, _ = cb.Do(func() (interface{}, error) {
+			panic("oops")
+		})
+	})
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestPanicInHalfOpen(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	assert.Panics(t, func() {
+		_, _ = cb.Do(func() (interface{}, error) {
+			panic("oops")
+		})
+	})
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.counts)
+
+	// the slot isn't leaked, the next half-open episode admits a probe
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestRecoverPanics(t *testing.T) {
+	cb := NewCircuitBreaker(Config{RecoverPanics: true})
+	result, err := cb.Do(func() (interface{}, error) {
+		panic("oops")
+	})
+	assert.Nil(t, result)
+	var panicErr PanicError
+	assert.ErrorAs(t, err, &panicErr)
+	assert.Equal(t, "oops", panicErr.Value)
+	assert.Contains(t, string(panicErr.Stack), "TestRecoverPanics")
+	assert.ErrorIs(t, err, ErrRequestPanicked)
+	assert.Equal(t, Counts{1, 0, 1, 0, 1, 1}, cb.Counts())
+
+	// also with RequestTimeout, and for the generic variants
+	gcb := NewCircuitBreakerGeneric[int](Config{
+		RecoverPanics:  true,
+		RequestTimeout: time.Second,
+	})
+	errPanic := errors.New("panic")
+	got, err := gcb.Do(func() (int, error) {
+		panic(errPanic)
+	})
+	assert.Equal(t, 0, got)
+	assert.ErrorIs(t, err, ErrRequestPanicked)
+	assert.ErrorIs(t, err, errPanic)
+	assert.NotEmpty(t, err.(PanicError).Stack)
+	assert.Equal(t, Counts{1, 0, 1, 0, 1, 1}, gcb.Counts())
+
+	// a panicking probe reopens the CircuitBreaker
+	cb.ForceOpen()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	_, err = cb.Do(func() (interface{}, error) {
+		panic("oops")
+	})
+	assert.ErrorIs(t, err, ErrRequestPanicked)
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestRecoverPanicsHalfOpen(t *testing.T) {
+	for _, timeout := range []time.Duration{0, time.Second} {
+		cb := NewCircuitBreaker(Config{RecoverPanics: true, RequestTimeout: timeout})
+		for i := 0; i < 6; i++ {
+			assert.Nil(t, fail(cb), timeout)
+		}
+		pseudoSleep(cb, time.Duration(60)*time.Second)
+		assert.Equal(t, StateHalfOpen, cb.State(), timeout)
+
+		// the panicking probe is a failure that reopens the CircuitBreaker,
+		// without raising the panic again
+		var err error
+		assert.NotPanics(t, func() {
+			_, err = cb.Do(func() (interface{}, error) {
+				panic("oops")
+			})
+		}, timeout)
+		assert.ErrorIs(t, err, ErrRequestPanicked, timeout)
+		assert.Equal(t, StateOpen, cb.State(), timeout)
+		assert.Equal(t, uint64(2), cb.Stats().Trips, timeout)
+
+		// the slot isn't leaked, the next half-open episode admits a probe
+		pseudoSleep(cb, time.Duration(60)*time.Second)
+		assert.Equal(t, StateHalfOpen, cb.State(), timeout)
+		assert.Nil(t, succeed(cb), timeout)
+		assert.Equal(t, StateClosed, cb.State(), timeout)
+	}
 }
 
 func TestGeneration(t *testing.T) {
@@ -285,15 +494,149 @@ func TestGeneration(t *testing.T) {
 	assert.Nil(t, succeed(customCB))
 	ch := succeedLater(customCB, time.Duration(1500)*time.Millisecond)
 	time.Sleep(time.Duration(500) * time.Millisecond)
-	assert.Equal(t, Counts{2, 1, 0}, customCB.counts)
+	assert.Equal(t, Counts{2, 1, 0, 1, 0, 0}, customCB.counts)
 
 	time.Sleep(time.Duration(500) * time.Millisecond) // over Interval
 	assert.Equal(t, StateClosed, customCB.State())
-	assert.Equal(t, Counts{0, 0, 0}, customCB.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, customCB.counts)
 
 	// the request from the previous generation has no effect on customCB.counts
 	assert.Nil(t, <-ch)
-	assert.Equal(t, Counts{0, 0, 0}, customCB.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, customCB.counts)
+}
+
+func TestOnReset(t *testing.T) {
+	var resets []Counts
+	var cb *CircuitBreaker
+	cb = NewCircuitBreaker(Config{
+		Interval: time.Duration(30) * time.Second,
+		OnReset: func(previous Counts) {
+			resets = append(resets, previous)
+			cb.Counts() // free to call back in
+		},
+	})
+
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	pseudoSleep(cb, time.Duration(31)*time.Second)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, []Counts{{2, 0, 1, 1, 1, 0}}, resets)
+
+	// not on state changes nor Reset
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, succeed(cb))
+	cb.Reset()
+	assert.Len(t, resets, 1)
+
+	// an empty generation is reported too
+	pseudoSleep(cb, time.Duration(31)*time.Second)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, []Counts{{2, 0, 1, 1, 1, 0}, {}}, resets)
+}
+
+func TestOnSuccessOnFailure(t *testing.T) {
+	var successes, failures []Counts
+	var errs []error
+	var cb *CircuitBreaker
+	cb = NewCircuitBreaker(Config{
+		RecoverPanics: true,
+		OnSuccess: func(counts Counts) {
+			successes = append(successes, counts)
+			cb.Counts() // free to call back in
+		},
+		OnFailure: func(counts Counts, err error) {
+			failures = append(failures, counts)
+			errs = append(errs, err)
+		},
+	})
+
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	_, err := cb.Do(func() (interface{}, error) { panic("boom") })
+	assert.ErrorIs(t, err, ErrRequestPanicked)
+	assert.Equal(t, []Counts{{1, 1, 0, 1, 0, 0}}, successes)
+	assert.Equal(t, []Counts{{2, 0, 1, 1, 1, 0}, {3, 0, 2, 1, 2, 1}}, failures)
+	assert.Equal(t, "fail", errs[0].Error())
+	assert.Equal(t, ErrRequestPanicked, errs[1])
+
+	// the failure that trips is reported with the counts it tripped on
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, Counts{7, 0, 6, 1, 6, 1}, failures[len(failures)-1])
+
+	// rejections and dry runs aren't reported
+	assert.ErrorIs(t, succeed(cb), ErrOpenState)
+	cb.Reset()
+	cb.WouldTripOn(false)
+	cb.SubmitProbeResult(true)
+	assert.Len(t, successes, 1)
+	assert.Len(t, failures, 6)
+}
+
+func TestRejectedCount(t *testing.T) {
+	cb := NewCircuitBreaker(Config{MaxConcurrentRequests: 1})
+	assert.Equal(t, uint32(0), cb.RejectedCount())
+
+	cb.ForceOpen()
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, succeed(cb), ErrOpenState)
+	}
+	_, errs := cb.DoBatch([]func() (interface{}, error){func() (interface{}, error) { return nil, nil }})
+	assert.ErrorIs(t, errs[0], ErrOpenState)
+	assert.Equal(t, uint32(4), cb.RejectedCount())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+
+	// each open period starts over
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, uint32(0), cb.RejectedCount())
+	tscb := &TwoStepCircuitBreaker{cb: cb}
+	done, err := tscb.Allow()
+	assert.Nil(t, err)
+	assert.ErrorIs(t, succeed(cb), ErrTooManyRequests)
+	assert.Equal(t, uint32(1), cb.RejectedCount())
+
+	// the concurrency cap isn't a short circuit
+	done(true)
+	assert.Equal(t, StateClosed, cb.State())
+	done, err = tscb.Allow()
+	assert.Nil(t, err)
+	assert.ErrorIs(t, succeed(cb), ErrTooManyConcurrent)
+	assert.Equal(t, uint32(0), cb.RejectedCount())
+	done(true)
+}
+
+func TestGenerationNumber(t *testing.T) {
+	cb := NewCircuitBreaker(Config{Interval: time.Duration(30) * time.Second})
+	assert.Equal(t, uint64(1), cb.Generation())
+
+	// interval resets
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, uint64(1), cb.Generation())
+	pseudoSleep(cb, time.Duration(31)*time.Second)
+	assert.Equal(t, uint64(2), cb.Generation())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+
+	// state changes
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, uint64(3), cb.Generation())
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, uint64(4), cb.Generation())
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	cb.Reset()
+	assert.Equal(t, uint64(5), cb.Generation())
+	cb.Reset()
+	assert.Equal(t, uint64(6), cb.Generation())
 }
 
 func TestCustomIsSuccessful(t *testing.T) {
@@ -306,7 +649,7 @@ func TestCustomIsSuccessful(t *testing.T) {
 		assert.Nil(t, fail(cb))
 	}
 	assert.Equal(t, StateClosed, cb.State())
-	assert.Equal(t, Counts{5, 5, 0}, cb.counts)
+	assert.Equal(t, Counts{5, 5, 0, 5, 0, 0}, cb.counts)
 
 	// cb.counts.clear()
 
@@ -320,6 +663,49 @@ func TestCustomIsSuccessful(t *testing.T) {
 
 }
 
+func TestOnReject(t *testing.T) {
+	var states []State
+	var errs []error
+	var cb *CircuitBreaker
+	tscb := NewTwoStepCircuitBreaker(Config{
+		MaxConcurrentRequests: 1,
+		OnReject: func(state State, err error) {
+			// called outside the mutex
+			assert.Equal(t, state, cb.State())
+			states = append(states, state)
+			errs = append(errs, err)
+		},
+	})
+	cb = tscb.cb
+
+	// only the state rejects
+	done, err := tscb.Allow()
+	assert.Nil(t, err)
+	assert.ErrorIs(t, succeed(cb), ErrTooManyConcurrent)
+	done(false)
+	assert.Empty(t, errs)
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.ErrorIs(t, succeed(cb), ErrOpenState)
+	assert.ErrorIs(t, succeed(cb), ErrOpenState)
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	done, err = tscb.Allow()
+	assert.Nil(t, err)
+	_, err = tscb.Allow()
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+	done(true)
+
+	assert.Equal(t, []State{StateOpen, StateOpen, StateHalfOpen}, states)
+	assert.Equal(t, []error{
+		RejectedError{State: StateOpen, Err: ErrOpenState},
+		RejectedError{State: StateOpen, Err: ErrOpenState},
+		RejectedError{State: StateHalfOpen, Err: TooManyRequestsError{MaxRequests: 1, CurrRequests: 1}},
+	}, errs)
+}
+
 func TestCircuitBreakerInParallel(t *testing.T) {
 	customCB := newCustom(nil)
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -343,5 +729,522 @@ func TestCircuitBreakerInParallel(t *testing.T) {
 		err := <-ch
 		assert.Nil(t, err)
 	}
-	assert.Equal(t, Counts{total, total, 0}, customCB.counts)
+	assert.Equal(t, Counts{total, total, 0, total, 0, 0}, customCB.counts)
+}
+
+func succeedSlowly(cb *CircuitBreaker, delay time.Duration) error {
+	_, err := cb.Do(func() (interface{}, error) {
+		time.Sleep(delay)
+		return nil, nil
+	})
+	return err
+}
+
+func TestSlowCallRate(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		SlowCallThreshold:     time.Duration(10) * time.Millisecond,
+		SlowCallRateThreshold: 0.5,
+		SlowCallMinimumCalls:  3,
+	})
+
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, succeedSlowly(cb, time.Duration(20)*time.Millisecond))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, uint32(1), cb.slowCalls)
+
+	// StateClosed to StateOpen: 2 slow out of 4
+	assert.Nil(t, succeedSlowly(cb, time.Duration(20)*time.Millisecond))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, uint32(0), cb.slowCalls)
+
+	// a single slow call doesn't trip a fresh breaker
+	cb = NewCircuitBreaker(Config{
+		SlowCallThreshold:     time.Duration(10) * time.Millisecond,
+		SlowCallRateThreshold: 0.5,
+	})
+	assert.Nil(t, succeedSlowly(cb, time.Duration(20)*time.Millisecond))
+	assert.Equal(t, StateClosed, cb.State())
+
+	// nor is the rate diluted by requests still in flight
+	cb = NewCircuitBreaker(Config{
+		SlowCallThreshold:     time.Duration(10) * time.Millisecond,
+		SlowCallRateThreshold: 0.5,
+		SlowCallMinimumCalls:  2,
+	})
+	ch := succeedLater(cb, time.Duration(100)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, succeedSlowly(cb, time.Duration(20)*time.Millisecond))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Nil(t, <-ch)
+
+	// slow calls aren't tracked without a threshold
+	cb = NewCircuitBreaker(Config{SlowCallRateThreshold: 0.5})
+	assert.Nil(t, succeedSlowly(cb, time.Duration(20)*time.Millisecond))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, uint32(0), cb.slowCalls)
+}
+
+func BenchmarkSlowCallDetection(b *testing.B) {
+	req := func() (interface{}, error) { return nil, nil }
+
+	b.Run("disabled", func(b *testing.B) {
+		cb := NewCircuitBreaker(Config{})
+		for i := 0; i < b.N; i++ {
+			_, _ = cb.Do(req)
+		}
+	})
+
+	b.Run("enabled", func(b *testing.B) {
+		cb := NewCircuitBreaker(Config{
+			SlowCallThreshold:     time.Second,
+			SlowCallRateThreshold: 0.5,
+		})
+		for i := 0; i < b.N; i++ {
+			_, _ = cb.Do(req)
+		}
+	})
+}
+
+func BenchmarkState(b *testing.B) {
+	b.Run("lock-free", func(b *testing.B) {
+		cb := NewCircuitBreaker(Config{})
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_ = cb.State()
+			}
+		})
+	})
+
+	b.Run("mutex", func(b *testing.B) {
+		cb := NewCircuitBreaker(Config{})
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				cb.mu.Lock()
+				_, _ = cb.currentState(time.Now())
+				cb.unlock()
+			}
+		})
+	})
+}
+
+func TestStateFastPath(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	cb.ForceOpen()
+	assert.Equal(t, StateOpen, cb.State())
+
+	// the transition to half-open still happens on read
+	stateChange := stateChangeTracker{}
+	cb.onStateChange = func(from, to State) {
+		stateChange = stateChangeTracker{from, to}
+	}
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Equal(t, stateChangeTracker{StateOpen, StateHalfOpen}, stateChange)
+
+	// reads don't contend with a held mutex while closed
+	cb.Reset()
+	cb.mu.Lock()
+	assert.Equal(t, StateClosed, cb.State())
+	cb.mu.Unlock()
+}
+
+func TestMaxProbeAttempts(t *testing.T) {
+	cb := NewCircuitBreaker(Config{MaxProbeAttempts: 2})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+
+	// both half-open entries fail
+	for i := 1; i <= 2; i++ {
+		pseudoSleep(cb, time.Duration(60)*time.Second)
+		assert.Equal(t, StateHalfOpen, cb.State())
+		assert.Equal(t, i, cb.probeAttempts)
+		assert.Nil(t, fail(cb))
+		assert.Equal(t, StateOpen, cb.State())
+	}
+
+	// budget exhausted, no more probing
+	assert.True(t, cb.expiry.IsZero())
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Error(t, succeed(cb))
+
+	// Reset replenishes the budget
+	cb.Reset()
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, 0, cb.probeAttempts)
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	// closing replenishes the budget as well
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, 0, cb.probeAttempts)
+}
+
+func TestReset(t *testing.T) {
+	stateChange := stateChangeTracker{}
+	cb := newCustom(&stateChange)
+	assert.Nil(t, succeed(cb))
+	cb.Reset()
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.counts)
+	assert.Equal(t, stateChangeTracker{}, stateChange)
+
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	cb.Reset()
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.counts)
+	assert.Equal(t, stateChangeTracker{StateOpen, StateClosed}, stateChange)
+}
+
+func TestResetIfState(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	assert.Nil(t, fail(cb))
+	assert.False(t, cb.ResetIfState(StateOpen))
+	assert.Equal(t, Counts{1, 0, 1, 0, 1, 0}, cb.Counts())
+
+	cb.ForceOpen()
+	assert.False(t, cb.ResetIfState(StateHalfOpen))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.True(t, cb.ResetIfState(StateOpen))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, ForceModeNone, cb.ForceMode())
+
+	// an open CircuitBreaker that's due to become half-open is no longer open
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.False(t, cb.ResetIfState(StateOpen))
+	assert.True(t, cb.ResetIfState(StateHalfOpen))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestClearConsecutive(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	assert.Nil(t, succeed(cb))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	cb.ClearConsecutive()
+	assert.Equal(t, Counts{6, 0, 0, 1, 5, 0}, cb.Counts())
+
+	// the blip doesn't trip it
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{7, 0, 1, 1, 6, 0}, cb.Counts())
+
+	// nor does it close an open one
+	cb.ForceOpen()
+	cb.ClearConsecutive()
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestResetIgnoresInFlightRequests(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	assert.Nil(t, fail(cb))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	ch := make(chan error)
+	go func() {
+		_, err := cb.Do(func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, errors.New("fail")
+		})
+		ch <- err
+	}()
+	<-started
+
+	cb.Reset()
+	close(release)
+	assert.Error(t, <-ch)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+}
+
+func TestWouldTripOn(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	for i := 0; i < 5; i++ {
+		assert.False(t, cb.WouldTripOn(false))
+		assert.Nil(t, fail(cb))
+	}
+	assert.False(t, cb.WouldTripOn(true))
+	assert.True(t, cb.WouldTripOn(false))
+	assert.Equal(t, Counts{5, 0, 5, 0, 5, 0}, cb.counts)
+	assert.Equal(t, StateClosed, cb.State())
+
+	// the prediction holds
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.False(t, cb.WouldTripOn(false))
+
+	// any failure while half-open trips
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.True(t, cb.WouldTripOn(false))
+	assert.False(t, cb.WouldTripOn(true))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.counts)
+}
+
+func TestWouldTripOnShadow(t *testing.T) {
+	shadowCalls := 0
+	cb := NewCircuitBreaker(Config{
+		ShadowShouldTrip: func(counts Counts) bool {
+			shadowCalls++
+			return true
+		},
+	})
+	assert.False(t, cb.WouldTripOn(false))
+	assert.Equal(t, 0, shadowCalls)
+	assert.Equal(t, ShadowDivergence{}, cb.ShadowDivergence())
+
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, 1, shadowCalls)
+}
+
+func TestResetGracePeriod(t *testing.T) {
+	cb := NewCircuitBreaker(Config{ResetGracePeriod: time.Duration(10) * time.Second})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+
+	// failures within the grace period don't trip
+	cb.Reset()
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{6, 0, 6, 0, 6, 0}, cb.counts)
+
+	// the same failures after it do
+	pseudoSleep(cb, time.Duration(10)*time.Second)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestDefaultShouldTripThresholds(t *testing.T) {
+	// consecutive failures
+	cb := NewCircuitBreaker(Config{
+		ConsecutiveFailureThreshold: 2,
+		FailureRateThreshold:        0.9,
+		MinimumRequests:             3,
+	})
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	// failure rate, never more than 1 consecutive failure
+	cb = NewCircuitBreaker(Config{
+		FailureRateThreshold: 0.5,
+		MinimumRequests:      6,
+	})
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, fail(cb))
+		assert.Nil(t, succeed(cb))
+	}
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateClosed, cb.State()) // 3/5, under MinimumRequests
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State()) // 4/7
+
+	// custom ShouldTrip takes over
+	cb = NewCircuitBreaker(Config{
+		ShouldTrip:           func(counts Counts) bool { return false },
+		FailureRateThreshold: 0.1,
+	})
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestMinimumRequests(t *testing.T) {
+	var consulted []Counts
+	cb := NewCircuitBreaker(Config{
+		Interval:        time.Duration(30) * time.Second,
+		MinimumRequests: 4,
+		ShouldTrip: func(counts Counts) bool {
+			consulted = append(consulted, counts)
+			return counts.ConsecutiveFailures >= 2
+		},
+	})
+
+	// not consulted while warming up
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Empty(t, consulted)
+	assert.True(t, cb.WouldTripOn(false)) // the 4th would be
+
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, []Counts{{4, 0, 4, 0, 4, 0}, {4, 0, 4, 0, 4, 0}}, consulted)
+
+	// every generation warms up anew
+	cb = NewCircuitBreaker(Config{
+		Interval:        time.Duration(30) * time.Second,
+		MinimumRequests: 4,
+	})
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	pseudoSleep(cb, time.Duration(31)*time.Second)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateClosed, cb.State())
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestIgnoreFirstN(t *testing.T) {
+	cb := NewCircuitBreaker(Config{IgnoreFirstN: 3})
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, Counts{3, 0, 0, 0, 0, 0}, cb.counts)
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateClosed, cb.State())
+	assert.False(t, cb.WouldTripOn(true))
+	assert.True(t, cb.WouldTripOn(false))
+	assert.Nil(t, fail(cb)) // the 6th counted failure
+	assert.Equal(t, StateOpen, cb.State())
+
+	// ignored probes hand back their half-open slot
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.False(t, cb.WouldTripOn(false))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(cb))
+		assert.Equal(t, StateHalfOpen, cb.State())
+		assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.counts)
+	}
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestMaxGenerationLifetime(t *testing.T) {
+	cb := NewCircuitBreaker(Config{MaxGenerationLifetime: time.Duration(30) * time.Second})
+	assert.False(t, cb.expiry.IsZero())
+	assert.Nil(t, fail(cb))
+	pseudoSleep(cb, time.Duration(29)*time.Second)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, Counts{2, 0, 2, 0, 2, 0}, cb.Counts())
+
+	pseudoSleep(cb, time.Duration(1)*time.Second) // over MaxGenerationLifetime
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+
+	// the shorter of Interval and MaxGenerationLifetime wins
+	cb = NewCircuitBreaker(Config{
+		Interval:              time.Duration(10) * time.Second,
+		MaxGenerationLifetime: time.Duration(30) * time.Second,
+	})
+	assert.Nil(t, fail(cb))
+	pseudoSleep(cb, time.Duration(10)*time.Second)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+
+	cb = NewCircuitBreaker(Config{
+		Interval:              time.Duration(30) * time.Second,
+		MaxGenerationLifetime: time.Duration(10) * time.Second,
+	})
+	assert.Nil(t, fail(cb))
+	pseudoSleep(cb, time.Duration(10)*time.Second)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+}
+
+func TestDoContext(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		ConsecutiveFailureThreshold: 1,
+		IsSuccessfulContext: func(ctx context.Context, err error) bool {
+			return err == nil || ctx.Err() != nil
+		},
+	})
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "v")
+	result, err := cb.DoContext(ctx, func(ctx context.Context) (interface{}, error) {
+		return ctx.Value(key{}), nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "v", result)
+
+	// already cancelled, not run or counted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cb.DoContext(ctx, func(ctx context.Context) (interface{}, error) {
+		t.Fatal("request ran")
+		return nil, nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, cb.Counts())
+
+	// cancelled by the caller during the request
+	for i := 0; i < 3; i++ {
+		ctx, cancel = context.WithCancel(context.Background())
+		_, err = cb.DoContext(ctx, func(ctx context.Context) (interface{}, error) {
+			cancel()
+			return nil, ctx.Err()
+		})
+		assert.Equal(t, context.Canceled, err)
+	}
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{4, 4, 0, 4, 0, 0}, cb.Counts())
+
+	// IsSuccessfulContext is only used by DoContext
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestLastStateChange(t *testing.T) {
+	start := time.Now()
+	cb := NewCircuitBreaker(Config{})
+	created := cb.LastStateChange()
+	assert.False(t, created.Before(start))
+
+	// a closed-state reset isn't a state change
+	cb.Reset()
+	assert.Equal(t, created, cb.LastStateChange())
+
+	cb.ForceOpen()
+	opened := cb.LastStateChange()
+	assert.True(t, opened.After(created))
+	assert.True(t, cb.TimeInState() < time.Second)
+
+	// the lazy transition to half-open dates from the end of the timeout
+	pseudoSleep(cb, time.Duration(70)*time.Second)
+	assert.InDelta(t, float64(time.Duration(10)*time.Second), float64(cb.TimeInState()), float64(time.Second))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.True(t, cb.LastStateChange().Before(time.Now().Add(-time.Duration(9)*time.Second)))
+
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.True(t, cb.TimeInState() < time.Second)
 }
@@ -0,0 +1,26 @@
+package circuitbreaker
+
+// ConcurrentRequests returns the number of admitted requests currently in
+// flight, see MaxConcurrentRequests. It's tracked whether or not there's a cap
+func (cb *CircuitBreaker) ConcurrentRequests() uint32 {
+	return cb.activeRequests.Load()
+}
+
+// releaseActive marks an admitted request as no longer in flight. It doesn't
+// go below zero if a two-step callback is called more than once. It's called
+// once the outcome is recorded, so that the last one out while draining can
+// wake up Drain with the counts final
+func (cb *CircuitBreaker) releaseActive() {
+	for {
+		active := cb.activeRequests.Load()
+		if active == 0 {
+			return
+		}
+		if cb.activeRequests.CompareAndSwap(active, active-1) {
+			if active == 1 && cb.draining.Load() {
+				cb.signalDrained()
+			}
+			return
+		}
+	}
+}
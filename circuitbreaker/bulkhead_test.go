@@ -0,0 +1,60 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	cb := NewCircuitBreaker(Config{MaxConcurrentRequests: 2})
+
+	ch1 := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	ch2 := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.Equal(t, uint32(2), cb.ConcurrentRequests())
+
+	// fails fast, without counting as a failure
+	assert.ErrorIs(t, succeed(cb), ErrTooManyConcurrent)
+	assert.True(t, IsRejection(ErrTooManyConcurrent))
+	assert.Equal(t, Counts{2, 0, 0, 0, 0, 0}, cb.Counts())
+
+	assert.Nil(t, <-ch1)
+	assert.Nil(t, <-ch2)
+	assert.Equal(t, uint32(0), cb.ConcurrentRequests())
+	assert.Nil(t, succeed(cb))
+
+	// a panicking request is no longer in flight
+	assert.Panics(t, func() {
+		_, _ = cb.Do(func() (interface{}, error) { panic("oops") })
+	})
+	assert.Equal(t, uint32(0), cb.ConcurrentRequests())
+
+	// nor is one abandoned at the RequestTimeout
+	cb = NewCircuitBreaker(Config{
+		MaxConcurrentRequests: 1,
+		RequestTimeout:        time.Duration(10) * time.Millisecond,
+	})
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	assert.Equal(t, ErrRequestTimeout, <-ch)
+	assert.Equal(t, uint32(0), cb.ConcurrentRequests())
+	assert.Nil(t, succeed(cb))
+}
+
+func TestConcurrentRequestsTwoStep(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Config{MaxConcurrentRequests: 1})
+	done, err := tscb.Allow()
+	assert.Nil(t, err)
+	_, err = tscb.Allow()
+	assert.ErrorIs(t, err, ErrTooManyConcurrent)
+
+	// calling back twice doesn't free up an extra place
+	done(true)
+	done(true)
+	assert.Equal(t, uint32(0), tscb.cb.ConcurrentRequests())
+	_, err = tscb.Allow()
+	assert.Nil(t, err)
+	_, err = tscb.Allow()
+	assert.ErrorIs(t, err, ErrTooManyConcurrent)
+}
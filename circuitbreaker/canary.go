@@ -0,0 +1,26 @@
+package circuitbreaker
+
+import "time"
+
+// isCanary reports whether an outcome counted now is a canary, see
+// CanaryInterval, and queues the call to OnCanaryResult if it is. Dry runs
+// neither are canaries nor count as outcomes. It must be called with the
+// mutex held
+func (cb *CircuitBreaker) isCanary(state State, o outcome, now time.Time) bool {
+	if cb.canaryInterval <= 0 || o.dryRun {
+		return false
+	}
+	last := cb.lastOutcome
+	cb.lastOutcome = now
+	if state != StateClosed || now.Sub(last) < cb.canaryInterval {
+		return false
+	}
+
+	if cb.onCanaryResult != nil {
+		success := o.success
+		cb.pending = append(cb.pending, func() {
+			cb.onCanaryResult(success)
+		})
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanaryInterval(t *testing.T) {
+	var canaries []bool
+	var weighted float64
+	cb := NewCircuitBreaker(Config{
+		CanaryInterval:      time.Minute,
+		CanaryFailureWeight: 0.25,
+		OnCanaryResult: func(success bool) {
+			canaries = append(canaries, success)
+		},
+		ShouldTripContext: func(tc TripContext) bool {
+			weighted = tc.WeightedFailures
+			return tc.WeightedFailures >= 2
+		},
+	})
+	quiet := func(d time.Duration) {
+		cb.mu.Lock()
+		cb.lastOutcome = cb.lastOutcome.Add(-d)
+		cb.mu.Unlock()
+	}
+
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Empty(t, canaries)
+	assert.Equal(t, float64(1), weighted)
+
+	// the first request after a quiet spell is a canary, and counts as usual
+	quiet(time.Minute)
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, []bool{false}, canaries)
+	assert.Equal(t, 1.25, weighted)
+	assert.Equal(t, Counts{4, 1, 0, 2, 2, 0}, cb.Counts())
+
+	quiet(time.Duration(30) * time.Second)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, []bool{false}, canaries)
+	assert.Equal(t, 2.25, weighted)
+	assert.Equal(t, StateOpen, cb.State())
+
+	// only while closed
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	quiet(time.Hour)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	quiet(time.Hour)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, []bool{false, true}, canaries)
+
+	// a dry run isn't a canary
+	quiet(time.Hour)
+	cb.WouldTripOn(false)
+	assert.Equal(t, []bool{false, true}, canaries)
+}
@@ -0,0 +1,263 @@
+// Package cbdebug serves the status of circuit breakers for debugging
+package cbdebug
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+)
+
+// Breaker is the status of a single circuit breaker, as served by Handler
+type Breaker struct {
+	Name     string                `json:"name"`
+	State    circuitbreaker.State  `json:"state"`
+	Counts   circuitbreaker.Counts `json:"counts"`
+	Stats    circuitbreaker.Stats  `json:"stats"`
+	Recovery *Recovery             `json:"recovery,omitempty"`
+	Events   []Event               `json:"events"`
+}
+
+// Event is one of the recent events of a circuit breaker, see
+// circuitbreaker.CircuitBreaker.RecentEvents
+type Event struct {
+	Type          string               `json:"type"`
+	From          circuitbreaker.State `json:"from"`
+	To            circuitbreaker.State `json:"to"`
+	Time          time.Time            `json:"time"`
+	Generation    uint64               `json:"generation"`
+	FailureLabels []string             `json:"failure_labels,omitempty"`
+}
+
+// Recovery is the progress of an open or half-open circuit breaker back to
+// the closed state, see circuitbreaker.RecoveryStatus
+type Recovery struct {
+	// Successes is the number of consecutive successful probes so far while
+	// half-open
+	Successes uint32 `json:"successes"`
+
+	// SuccessesNeeded is the number of successful probes required to close
+	// while half-open
+	SuccessesNeeded uint32 `json:"successes_needed"`
+
+	// UntilHalfOpenSeconds is the time left before an open circuit breaker
+	// starts probing, 0 if it's out of probe attempts
+	UntilHalfOpenSeconds float64 `json:"until_half_open_seconds"`
+
+	// ProbeAttempts is the number of half-open entries used in the current
+	// open episode
+	ProbeAttempts int `json:"probe_attempts"`
+
+	// MaxProbeAttempts is the probe budget, 0 if unlimited
+	MaxProbeAttempts int `json:"max_probe_attempts"`
+}
+
+// Option configures a Handler
+type Option func(h *handler)
+
+// WithActions enables the POST actions of a Handler. They change the state
+// of the circuit breakers they're applied to, which is dangerous in
+// production, so they're off by default and should only be enabled behind
+// authentication
+func WithActions() Option {
+	return func(h *handler) {
+		h.actions = true
+	}
+}
+
+// actions are the POST actions a Handler serves with WithActions, by name
+var actions = map[string]func(cb *circuitbreaker.CircuitBreaker){
+	"reset":        (*circuitbreaker.CircuitBreaker).Reset,
+	"force-open":   (*circuitbreaker.CircuitBreaker).ForceOpen,
+	"force-closed": (*circuitbreaker.CircuitBreaker).ForceClose,
+	"clear-force":  (*circuitbreaker.CircuitBreaker).ClearForce,
+}
+
+type handler struct {
+	reg     *circuitbreaker.Registry
+	actions bool
+}
+
+// Handler returns an http.Handler serving the status of the circuit breakers
+// in reg as JSON, e.g. mounted under /debug/breakers:
+//
+//	{"breakers": [{"name": "payments", "state": "open", "counts": {...}, "stats": {...}, "recovery": {...}, "events": [...]}]}
+//
+// Breakers are sorted by name, and recovery is only set while open or
+// half-open. Events holds up to the last 16 events of each, oldest first. With
+// a name query parameter, e.g. /debug/breakers?name=payments, only that breaker
+// is served, on its own, or 404 Not Found if there's none by that name. With
+// format=html, the same is served as an HTML table instead. The status is read
+// when the request is served and mustn't be cached. Only GET and HEAD are
+// allowed, unless WithActions is set
+//
+// WithActions enables POST requests applying an action to the breaker given
+// by name, e.g. /debug/breakers?name=payments&action=reset, which are served
+// the breaker's status once it's applied. The actions are reset, force-open,
+// force-closed and clear-force, see the methods of the same names
+func Handler(reg *circuitbreaker.Registry, opts ...Option) http.Handler {
+	h := &handler{reg: reg}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		h.serveStatus(w, r)
+	case r.Method == http.MethodPost && h.actions:
+		h.serveAction(w, r)
+	default:
+		allow := "GET, HEAD"
+		if h.actions {
+			allow += ", POST"
+		}
+		w.Header().Set("Allow", allow)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *handler) serveStatus(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	html := r.URL.Query().Get("format") == "html"
+
+	var breakers []Breaker
+	if name != "" {
+		cb, ok := h.reg.Get(name)
+		if !ok {
+			http.Error(w, "no circuit breaker named "+name, http.StatusNotFound)
+			return
+		}
+		breakers = []Breaker{status(name, cb)}
+	} else {
+		breakers = []Breaker{}
+		h.reg.ForEach(func(name string, cb *circuitbreaker.CircuitBreaker) {
+			breakers = append(breakers, status(name, cb))
+		})
+		sort.Slice(breakers, func(i, j int) bool {
+			return breakers[i].Name < breakers[j].Name
+		})
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if html {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = page.Execute(w, struct {
+			Breakers []Breaker
+			Actions  bool
+		}{breakers, h.actions})
+		return
+	}
+
+	var body interface{} = struct {
+		Breakers []Breaker `json:"breakers"`
+	}{breakers}
+	if name != "" {
+		body = breakers[0]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// serveAction applies the action of a POST request. Both parameters may come
+// from the query or a form, so that the HTML table can post them, in which
+// case the table is served again
+func (h *handler) serveAction(w http.ResponseWriter, r *http.Request) {
+	name, action := r.FormValue("name"), r.FormValue("action")
+	apply, ok := actions[action]
+	if !ok {
+		http.Error(w, "unknown action "+action, http.StatusBadRequest)
+		return
+	}
+	cb, ok := h.reg.Get(name)
+	if !ok {
+		http.Error(w, "no circuit breaker named "+name, http.StatusNotFound)
+		return
+	}
+	apply(cb)
+
+	if r.FormValue("format") == "html" {
+		http.Redirect(w, r, r.URL.Path+"?format=html", http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status(name, cb))
+}
+
+// status reads the status of cb through its thread-safe accessors. The
+// recovery status, which holds the state, is read first so that a generation
+// that's over is rolled over before the counts are read
+func status(name string, cb *circuitbreaker.CircuitBreaker) Breaker {
+	rs := cb.RecoveryStatus()
+	b := Breaker{
+		Name:   name,
+		State:  rs.State,
+		Counts: cb.Counts(),
+		Stats:  cb.Stats(),
+		Events: []Event{},
+	}
+	for _, event := range cb.RecentEvents() {
+		b.Events = append(b.Events, Event{
+			Type:          event.Type.String(),
+			From:          event.From,
+			To:            event.To,
+			Time:          event.Time,
+			Generation:    event.Generation,
+			FailureLabels: event.FailureLabels,
+		})
+	}
+	if rs.State != circuitbreaker.StateClosed {
+		b.Recovery = &Recovery{
+			Successes:            rs.Successes,
+			SuccessesNeeded:      rs.SuccessesNeeded,
+			UntilHalfOpenSeconds: rs.UntilHalfOpen.Seconds(),
+			ProbeAttempts:        rs.ProbeAttempts,
+			MaxProbeAttempts:     rs.MaxProbeAttempts,
+		}
+	}
+	return b
+}
+
+// actionNames returns the names of the actions, sorted
+func actionNames() []string {
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var page = template.Must(template.New("page").Funcs(template.FuncMap{
+	"actions": actionNames,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>Circuit breakers</title></head>
+<body>
+<table>
+<tr><th>Name</th><th>State</th><th>Requests</th><th>Successes</th><th>Failures</th><th>Consecutive failures</th><th>Recovery</th>{{if .Actions}}<th>Actions</th>{{end}}</tr>
+{{- range .Breakers}}
+{{- $name := .Name}}
+<tr>
+<td>{{.Name}}</td>
+<td>{{.State}}</td>
+<td>{{.Counts.CurrRequests}}</td>
+<td>{{.Counts.TotalSuccesses}}</td>
+<td>{{.Counts.TotalFailures}}</td>
+<td>{{.Counts.ConsecutiveFailures}}</td>
+<td>{{with .Recovery}}{{.Successes}}/{{.SuccessesNeeded}} probes, half-open in {{printf "%.0f" .UntilHalfOpenSeconds}}s{{end}}</td>
+{{- if $.Actions}}
+<td>{{range $action := actions}}<form method="post" style="display:inline"><input type="hidden" name="name" value="{{$name}}"><input type="hidden" name="format" value="html"><button name="action" value="{{$action}}">{{$action}}</button></form>{{end}}</td>
+{{- end}}
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
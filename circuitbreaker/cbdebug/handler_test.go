@@ -0,0 +1,162 @@
+package cbdebug
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+	"github.com/bnm3k/kit/circuitbreaker/cbtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	reg := circuitbreaker.NewRegistry()
+	h := Handler(reg)
+
+	rec := serve(h, http.MethodGet, "/debug/breakers")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"breakers": []}`, rec.Body.String())
+
+	payments := reg.GetOrCreate("payments", circuitbreaker.Config{})
+	_, _ = payments.Do(func() (interface{}, error) { return nil, nil })
+	_, _ = payments.Do(func() (interface{}, error) { return nil, errors.New("fail") })
+	clock := cbtest.NewManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	reg.GetOrCreate("accounts", circuitbreaker.Config{Clock: clock}).ForceOpen()
+	clock.Advance(20 * time.Second)
+
+	rec = serve(h, http.MethodGet, "/debug/breakers")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	assert.JSONEq(t, `{"breakers": [
+		{
+			"name": "accounts",
+			"state": "open",
+			"counts": {"current_requests": 0, "consecutive_successes": 0, "consecutive_failures": 0, "total_successes": 0, "total_failures": 0, "panics": 0},
+			"stats": {"requests": 0, "successes": 0, "failures": 0, "trips": 1, "rejections": 0},
+			"recovery": {"successes": 0, "successes_needed": 0, "until_half_open_seconds": 40, "probe_attempts": 0, "max_probe_attempts": 0},
+			"events": [
+				{"type": "state-change", "from": "closed", "to": "open", "time": "2023-01-01T00:00:00Z", "generation": 2},
+				{"type": "trip", "from": "closed", "to": "open", "time": "2023-01-01T00:00:00Z", "generation": 2}
+			]
+		},
+		{
+			"name": "payments",
+			"state": "closed",
+			"counts": {"current_requests": 2, "consecutive_successes": 0, "consecutive_failures": 1, "total_successes": 1, "total_failures": 1, "panics": 0},
+			"stats": {"requests": 2, "successes": 1, "failures": 1, "trips": 0, "rejections": 0},
+			"events": []
+		}
+	]}`, rec.Body.String())
+
+	// a single breaker
+	rec = serve(h, http.MethodGet, "/debug/breakers?name=accounts")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{
+		"name": "accounts",
+		"state": "open",
+		"counts": {"current_requests": 0, "consecutive_successes": 0, "consecutive_failures": 0, "total_successes": 0, "total_failures": 0, "panics": 0},
+		"stats": {"requests": 0, "successes": 0, "failures": 0, "trips": 1, "rejections": 0},
+		"recovery": {"successes": 0, "successes_needed": 0, "until_half_open_seconds": 40, "probe_attempts": 0, "max_probe_attempts": 0},
+		"events": [
+				{"type": "state-change", "from": "closed", "to": "open", "time": "2023-01-01T00:00:00Z", "generation": 2},
+				{"type": "trip", "from": "closed", "to": "open", "time": "2023-01-01T00:00:00Z", "generation": 2}
+			]
+	}`, rec.Body.String())
+
+	rec = serve(h, http.MethodGet, "/debug/breakers?name=orders")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(h, http.MethodPost, "/debug/breakers")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, HEAD", rec.Header().Get("Allow"))
+}
+
+func TestHandlerHTML(t *testing.T) {
+	reg := circuitbreaker.NewRegistry()
+	reg.GetOrCreate("payments", circuitbreaker.Config{}).ForceOpen()
+	reg.GetOrCreate("<accounts>", circuitbreaker.Config{})
+
+	rec := serve(Handler(reg), http.MethodGet, "/debug/breakers?format=html")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.Contains(t, body, "<td>payments</td>\n<td>open</td>")
+	assert.Contains(t, body, "<td>&lt;accounts&gt;</td>")
+	assert.Contains(t, body, "0/0 probes, half-open in 60s")
+	assert.NotContains(t, body, "<form")
+
+	rec = serve(Handler(reg, WithActions()), http.MethodGet, "/debug/breakers?format=html&name=payments")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<button name="action" value="reset">reset</button>`)
+	assert.NotContains(t, rec.Body.String(), "accounts")
+}
+
+func TestHandlerActions(t *testing.T) {
+	reg := circuitbreaker.NewRegistry()
+	clock := cbtest.NewManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	payments := reg.GetOrCreate("payments", circuitbreaker.Config{Clock: clock})
+	payments.ForceOpen()
+
+	// off unless enabled
+	rec := serve(Handler(reg), http.MethodPost, "/debug/breakers?name=payments&action=reset")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, circuitbreaker.StateOpen, payments.State())
+
+	h := Handler(reg, WithActions())
+	rec = serve(h, http.MethodPost, "/debug/breakers?name=payments&action=reset")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"name": "payments",
+		"state": "closed",
+		"counts": {"current_requests": 0, "consecutive_successes": 0, "consecutive_failures": 0, "total_successes": 0, "total_failures": 0, "panics": 0},
+		"stats": {"requests": 0, "successes": 0, "failures": 0, "trips": 1, "rejections": 0},
+		"events": [
+			{"type": "state-change", "from": "closed", "to": "open", "time": "2023-01-01T00:00:00Z", "generation": 2},
+			{"type": "trip", "from": "closed", "to": "open", "time": "2023-01-01T00:00:00Z", "generation": 2},
+			{"type": "state-change", "from": "open", "to": "closed", "time": "2023-01-01T00:00:00Z", "generation": 3},
+			{"type": "reset", "from": "open", "to": "closed", "time": "2023-01-01T00:00:00Z", "generation": 3}
+		]
+	}`, rec.Body.String())
+	assert.Equal(t, circuitbreaker.StateClosed, payments.State())
+
+	rec = serve(h, http.MethodPost, "/debug/breakers?name=payments&action=force-open")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, circuitbreaker.ForceModeOpen, payments.ForceMode())
+
+	rec = serve(h, http.MethodPost, "/debug/breakers?name=payments&action=force-closed")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, circuitbreaker.ForceModeClosed, payments.ForceMode())
+
+	rec = serve(h, http.MethodPost, "/debug/breakers?name=payments&action=clear-force")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, circuitbreaker.ForceModeNone, payments.ForceMode())
+
+	// from the HTML table, which is served again
+	req := httptest.NewRequest(http.MethodPost, "/debug/breakers", strings.NewReader("name=payments&action=force-open&format=html"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/debug/breakers?format=html", rec.Header().Get("Location"))
+	assert.Equal(t, circuitbreaker.StateOpen, payments.State())
+
+	rec = serve(h, http.MethodPost, "/debug/breakers?name=payments&action=trip")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = serve(h, http.MethodPost, "/debug/breakers?name=orders&action=reset")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = serve(h, http.MethodDelete, "/debug/breakers")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, HEAD, POST", rec.Header().Get("Allow"))
+}
@@ -0,0 +1,21 @@
+package cbhttp
+
+import (
+	"net/http"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+)
+
+// NewHTTPClient returns a copy of base whose transport runs requests through
+// cb, see RoundTripper. The timeout, cookie jar and redirect policy of base are
+// kept, and redirects are followed by the client as usual, each hop going
+// through cb. If base is nil, or has no transport, http.DefaultTransport is
+// wrapped
+func NewHTTPClient(cb *circuitbreaker.CircuitBreaker, base *http.Client) *http.Client {
+	var client http.Client
+	if base != nil {
+		client = *base
+	}
+	client.Transport = NewRoundTripper(cb, client.Transport)
+	return &client
+}
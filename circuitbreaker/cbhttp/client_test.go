@@ -0,0 +1,58 @@
+package cbhttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	status := int32(http.StatusInternalServerError)
+	srv := newServer(&status)
+	defer srv.Close()
+
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	base := &http.Client{Timeout: time.Duration(5) * time.Second}
+	client := NewHTTPClient(cb, base)
+	assert.Equal(t, base.Timeout, client.Timeout)
+	assert.Nil(t, base.Transport)
+
+	for i := 0; i < 6; i++ {
+		resp, err := get(t, client, srv.URL)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	}
+	assert.Equal(t, circuitbreaker.StateOpen, cb.State())
+
+	// fails fast without reaching the server
+	atomic.StoreInt32(&status, http.StatusOK)
+	_, err := get(t, client, srv.URL)
+	assert.True(t, errors.Is(err, circuitbreaker.ErrOpenState))
+}
+
+func TestNewHTTPClientRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusFound)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	resp, err := get(t, NewHTTPClient(cb, nil), srv.URL+"/old")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/new", resp.Request.URL.Path)
+
+	// each hop went through the breaker
+	assert.Equal(t, uint32(2), cb.Counts().TotalSuccesses)
+}
@@ -0,0 +1,195 @@
+// Package cbhttp runs HTTP requests through a circuit breaker
+package cbhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+)
+
+// ErrNoResponse is returned by RoundTrip when the CircuitBreaker's Fallback
+// returns something other than an *http.Response without an error
+var ErrNoResponse = errors.New("cbhttp: fallback returned no *http.Response")
+
+// RoundTripper is an http.RoundTripper that runs each request through a
+// CircuitBreaker. Rejected requests are never sent. With Config.RequestTimeout,
+// the response to a request that's abandoned at the timeout is drained and
+// closed when it arrives
+type RoundTripper struct {
+	cb   *circuitbreaker.CircuitBreaker
+	next http.RoundTripper
+
+	// IsSuccessful classifies the outcome of a round trip. If it is nil, a
+	// round trip is a success if it returns no error and a status below 500
+	IsSuccessful func(resp *http.Response, err error) bool
+
+	// RejectWithResponse makes rejected requests return a synthesized
+	// 503 Service Unavailable response, with a Retry-After header, instead of
+	// the CircuitBreaker's error
+	RejectWithResponse bool
+}
+
+// NewRoundTripper returns a RoundTripper sending requests admitted by cb
+// through next. If next is nil, http.DefaultTransport is used
+func NewRoundTripper(cb *circuitbreaker.CircuitBreaker, next http.RoundTripper) *RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &RoundTripper{cb: cb, next: next}
+}
+
+// responseError carries an unsuccessful response through the CircuitBreaker
+// so that it's counted as a failure while still being returned to the caller
+type responseError struct {
+	resp *http.Response
+}
+
+func (e *responseError) Error() string {
+	return "cbhttp: unsuccessful response: " + e.resp.Status
+}
+
+// RoundTrip implements http.RoundTripper. The request's context flows through
+// to CircuitBreaker.DoContext. If the CircuitBreaker has a Fallback, it should
+// return an *http.Response
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	x := &exchange{}
+	result, err := rt.cb.DoContext(req.Context(), func(context.Context) (interface{}, error) {
+		if !x.send() {
+			return nil, circuitbreaker.ErrRequestTimeout
+		}
+		resp, err := rt.next.RoundTrip(req)
+		x.receive(resp)
+		if !rt.isSuccessful(resp, err) && err == nil {
+			return resp, &responseError{resp: resp}
+		}
+		return resp, err
+	})
+	if !x.end(errors.Is(err, circuitbreaker.ErrRequestTimeout)) && req.Body != nil {
+		// the body is ours to close since next never got the request
+		req.Body.Close()
+	}
+
+	var respErr *responseError
+	if errors.As(err, &respErr) {
+		return respErr.resp, nil
+	}
+	if err != nil {
+		if rt.RejectWithResponse && circuitbreaker.IsRejection(err) {
+			return rt.unavailable(req, err), nil
+		}
+		return nil, err
+	}
+	resp, ok := result.(*http.Response)
+	if received := x.response(); received != nil && received != resp {
+		// replaced by Config.Fallback with FallbackOnError
+		drainAndClose(received)
+	}
+	if !ok || resp == nil {
+		// Config.Fallback stood in for the round trip
+		return nil, ErrNoResponse
+	}
+	return resp, nil
+}
+
+func (rt *RoundTripper) isSuccessful(resp *http.Response, err error) bool {
+	if rt.IsSuccessful != nil {
+		return rt.IsSuccessful(resp, err)
+	}
+	return err == nil && resp.StatusCode < http.StatusInternalServerError
+}
+
+// unavailable synthesizes the response to a request rejected with err
+func (rt *RoundTripper) unavailable(req *http.Request, err error) *http.Response {
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	retryAfter := (rt.cb.RetryAfter() + time.Second - 1) / time.Second
+	header.Set("Retry-After", strconv.FormatInt(int64(retryAfter), 10))
+
+	body := err.Error()
+	return &http.Response{
+		Status:        "503 Service Unavailable",
+		StatusCode:    http.StatusServiceUnavailable,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// exchange tracks a round trip that may be abandoned by the CircuitBreaker at
+// its RequestTimeout while it's still running on its own goroutine
+type exchange struct {
+	mu        sync.Mutex
+	sent      bool
+	resp      *http.Response
+	abandoned bool
+	ended     bool
+}
+
+// send reports whether the request may still be sent, i.e. RoundTrip hasn't
+// returned yet
+func (x *exchange) send() bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.sent = !x.ended
+	return x.sent
+}
+
+// receive hands over the response, which is closed if RoundTrip has already
+// given up on it
+func (x *exchange) receive(resp *http.Response) {
+	x.mu.Lock()
+	abandoned := x.abandoned
+	if !abandoned {
+		x.resp = resp
+	}
+	x.mu.Unlock()
+
+	if abandoned {
+		drainAndClose(resp)
+	}
+}
+
+// end marks the return of RoundTrip, closing any response that was received
+// after all if it's abandoned. It reports whether the request was sent
+func (x *exchange) end(abandoned bool) bool {
+	x.mu.Lock()
+	x.ended, x.abandoned = true, abandoned
+	resp := x.resp
+	sent := x.sent
+	x.mu.Unlock()
+
+	if abandoned {
+		drainAndClose(resp)
+	}
+	return sent
+}
+
+// response returns the response received, if any
+func (x *exchange) response() *http.Response {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return x.resp
+}
+
+// drainAndClose reads what's left of a small body before closing it so that
+// the connection can be reused
+func drainAndClose(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+}
@@ -0,0 +1,211 @@
+package cbhttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newServer(status *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(status)))
+		_, _ = io.WriteString(w, "body")
+	}))
+}
+
+func get(t *testing.T, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.Nil(t, err)
+	resp, err := client.Do(req)
+	if err == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestRoundTripper(t *testing.T) {
+	status := int32(http.StatusOK)
+	srv := newServer(&status)
+	defer srv.Close()
+
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	client := &http.Client{Transport: NewRoundTripper(cb, nil)}
+
+	resp, err := get(t, client, srv.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, circuitbreaker.Counts{CurrRequests: 1, TotalSuccesses: 1, ConsecutiveSuccesses: 1}, cb.Counts())
+
+	// 5xx responses are returned, but count as failures
+	atomic.StoreInt32(&status, http.StatusBadGateway)
+	for i := 0; i < 6; i++ {
+		resp, err = get(t, client, srv.URL)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	}
+	assert.Equal(t, circuitbreaker.StateOpen, cb.State())
+
+	_, err = get(t, client, srv.URL)
+	assert.True(t, errors.Is(err, circuitbreaker.ErrOpenState))
+}
+
+func TestRoundTripperRejectWithResponse(t *testing.T) {
+	status := int32(http.StatusOK)
+	srv := newServer(&status)
+	defer srv.Close()
+
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	cb.ForceOpen()
+	rt := NewRoundTripper(cb, nil)
+	rt.RejectWithResponse = true
+
+	body := &closeTracker{Reader: strings.NewReader("payload")}
+	req, err := http.NewRequest(http.MethodPost, srv.URL, body)
+	assert.Nil(t, err)
+	resp, err := rt.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+	assert.Equal(t, req, resp.Request)
+	b, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, circuitbreaker.ErrOpenState.Error(), string(b))
+	assert.True(t, body.closed)
+}
+
+func TestRoundTripperIsSuccessful(t *testing.T) {
+	status := int32(http.StatusTooManyRequests)
+	srv := newServer(&status)
+	defer srv.Close()
+
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	rt := NewRoundTripper(cb, nil)
+	rt.IsSuccessful = func(resp *http.Response, err error) bool {
+		return err == nil && resp.StatusCode != http.StatusTooManyRequests
+	}
+	client := &http.Client{Transport: rt}
+
+	resp, err := get(t, client, srv.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, circuitbreaker.Counts{CurrRequests: 1, TotalFailures: 1, ConsecutiveFailures: 1}, cb.Counts())
+}
+
+func TestRoundTripperContext(t *testing.T) {
+	status := int32(http.StatusOK)
+	srv := newServer(&status)
+	defer srv.Close()
+
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	rt := NewRoundTripper(cb, nil)
+
+	// a request whose context is already done isn't sent nor counted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body := &closeTracker{Reader: strings.NewReader("payload")}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, body)
+	assert.Nil(t, err)
+	_, err = rt.RoundTrip(req)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, body.closed)
+	assert.Equal(t, circuitbreaker.Counts{}, cb.Counts())
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type notifyingBody struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (b *notifyingBody) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestRoundTripperAbandonedResponse(t *testing.T) {
+	body := &notifyingBody{Reader: bytes.NewReader([]byte("late")), closed: make(chan struct{})}
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		time.Sleep(time.Duration(50) * time.Millisecond)
+		return &http.Response{StatusCode: http.StatusOK, Body: body}, nil
+	})
+
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{
+		RequestTimeout: time.Duration(10) * time.Millisecond,
+	})
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	assert.Nil(t, err)
+	_, err = NewRoundTripper(cb, next).RoundTrip(req)
+	assert.Equal(t, circuitbreaker.ErrRequestTimeout, err)
+
+	// the late response is drained and closed
+	select {
+	case <-body.closed:
+	case <-time.After(time.Second):
+		t.Fatal("abandoned response wasn't closed")
+	}
+	assert.Equal(t, 0, body.Reader.(*bytes.Reader).Len())
+}
+
+func TestRoundTripperFallback(t *testing.T) {
+	status := int32(http.StatusBadGateway)
+	srv := newServer(&status)
+	defer srv.Close()
+
+	cached := &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}
+	var fallbackResult interface{}
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{
+		Fallback: func(err error) (interface{}, error) {
+			return fallbackResult, nil
+		},
+		FallbackOnError: true,
+	})
+	rt := NewRoundTripper(cb, nil)
+
+	// the failed response is replaced, and closed
+	fallbackResult = cached
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	assert.Nil(t, err)
+	resp, err := rt.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Same(t, cached, resp)
+
+	// a fallback without a response is an error rather than a panic
+	fallbackResult = nil
+	_, err = rt.RoundTrip(req)
+	assert.Equal(t, ErrNoResponse, err)
+
+	fallbackResult = "cached"
+	cb.ForceOpen()
+	body := &closeTracker{Reader: strings.NewReader("payload")}
+	req, err = http.NewRequest(http.MethodPost, srv.URL, body)
+	assert.Nil(t, err)
+	_, err = rt.RoundTrip(req)
+	assert.Equal(t, ErrNoResponse, err)
+	assert.True(t, body.closed)
+}
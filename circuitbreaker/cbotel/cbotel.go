@@ -0,0 +1,75 @@
+// Package cbotel traces requests made through a circuit breaker with
+// OpenTelemetry. It lives apart from the circuitbreaker package so that users
+// who don't trace don't pay for it, nor import OpenTelemetry
+package cbotel
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultSpanName is the name of the spans of an unnamed CircuitBreaker
+const defaultSpanName = "circuitbreaker"
+
+// Attribute keys set on the spans
+const (
+	StateKey          = attribute.Key("cb.state")
+	GenerationKey     = attribute.Key("cb.generation")
+	ShortCircuitedKey = attribute.Key("cb.shortcircuited")
+)
+
+// Breaker wraps a CircuitBreaker so that each request made through it runs in
+// a span
+type Breaker struct {
+	cb     *circuitbreaker.CircuitBreaker
+	tracer trace.Tracer
+}
+
+// New returns a Breaker making requests through cb, with spans started from
+// tracer
+func New(cb *circuitbreaker.CircuitBreaker, tracer trace.Tracer) *Breaker {
+	return &Breaker{cb: cb, tracer: tracer}
+}
+
+// TraceIDs returns the IDs of the span ctx carries, or empty strings if it
+// doesn't carry a valid one. It's meant for circuitbreaker.Config.TraceIDs
+func TraceIDs(ctx context.Context) (traceID, spanID string) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", ""
+	}
+	return sc.TraceID().String(), sc.SpanID().String()
+}
+
+// DoContext runs req through CircuitBreaker.DoContext in a child span of ctx,
+// named after the CircuitBreaker. The span records the state and generation at
+// entry and whether the request was short-circuited because the CircuitBreaker
+// was open. The context passed to req carries the span
+func (b *Breaker) DoContext(ctx context.Context, req func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	name := b.cb.Name()
+	if name == "" {
+		name = defaultSpanName
+	}
+	ctx, span := b.tracer.Start(ctx, name)
+	defer span.End()
+
+	snap := b.cb.Snapshot()
+	span.SetAttributes(
+		StateKey.String(snap.State.String()),
+		GenerationKey.Int64(int64(snap.Generation)),
+	)
+	result, err := b.cb.DoContext(ctx, req)
+	if errors.Is(err, circuitbreaker.ErrOpenState) {
+		span.SetAttributes(ShortCircuitedKey.Bool(true))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return result, err
+}
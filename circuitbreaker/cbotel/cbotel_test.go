@@ -0,0 +1,93 @@
+package cbotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func attrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestDoContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{Name: "payments-api"})
+	b := New(cb, tracer)
+
+	_, err := b.DoContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		// the request runs within the span
+		assert.True(t, trace.SpanContextFromContext(ctx).IsValid())
+		return nil, nil
+	})
+	assert.Nil(t, err)
+
+	cb.ForceOpen()
+	_, err = b.DoContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("unreachable")
+	})
+	assert.True(t, errors.Is(err, circuitbreaker.ErrOpenState))
+
+	spans := recorder.Ended()
+	assert.Equal(t, 2, len(spans))
+
+	assert.Equal(t, "payments-api", spans[0].Name())
+	assert.Equal(t, "closed", attrs(spans[0])[StateKey].AsString())
+	assert.Equal(t, int64(1), attrs(spans[0])[GenerationKey].AsInt64())
+	_, ok := attrs(spans[0])[ShortCircuitedKey]
+	assert.False(t, ok)
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.Equal(t, "open", attrs(spans[1])[StateKey].AsString())
+	assert.Equal(t, int64(2), attrs(spans[1])[GenerationKey].AsInt64())
+	assert.True(t, attrs(spans[1])[ShortCircuitedKey].AsBool())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
+
+func TestDoContextUnnamed(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	b := New(circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{}), tracer)
+
+	_, err := b.DoContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, defaultSpanName, recorder.Ended()[0].Name())
+}
+
+func TestTraceIDs(t *testing.T) {
+	traceID, spanID := TraceIDs(context.Background())
+	assert.Equal(t, "", traceID)
+	assert.Equal(t, "", spanID)
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{TraceIDs: TraceIDs})
+	b := New(cb, tracer)
+	for i := 0; i < 6; i++ {
+		_, _ = b.DoContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+			return nil, errors.New("fail")
+		})
+	}
+
+	// the trip carries the span of the request that caused it
+	events := cb.RecentEvents()
+	assert.Equal(t, circuitbreaker.EventTrip, events[1].Type)
+	sc := recorder.Ended()[5].SpanContext()
+	assert.Equal(t, sc.TraceID().String(), events[1].TraceID)
+	assert.Equal(t, sc.SpanID().String(), events[1].SpanID)
+}
@@ -0,0 +1,155 @@
+// Package cbsql runs database/sql queries through a circuit breaker by
+// wrapping the driver.Connector a *sql.DB is opened with:
+//
+//	db := sql.OpenDB(cbsql.WrapConnector(connector, cb))
+//
+// Connecting, beginning transactions, pinging, preparing statements, and
+// executing queries and statements each go through the CircuitBreaker. Errors
+// reading rows, committing or rolling back aren't seen by it.
+//
+// A Fallback must return what the call it replaces would have, e.g. a
+// driver.Conn for a connection, or an error. Anything else fails with
+// ErrUnexpectedResult. With RequestTimeout, a connection, statement,
+// transaction or rows that arrive after the call timed out are closed, or
+// rolled back, since nobody is left to
+package cbsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+)
+
+// ErrUnexpectedResult is returned for a call whose result isn't of the type
+// it should be, which can only come from the CircuitBreaker's Fallback
+var ErrUnexpectedResult = errors.New("cbsql: unexpected result from the circuit breaker")
+
+// IsSuccessful is meant to be used as the CircuitBreaker's
+// Config.IsSuccessful. driver.ErrBadConn and context deadlines are failures,
+// while sql.ErrNoRows and errors such as constraint violations or syntax
+// errors are successes, since the database answered. cbsql hands every error
+// to the CircuitBreaker unchanged, so a different IsSuccessful can classify
+// them otherwise
+func IsSuccessful(err error) bool {
+	switch {
+	case err == nil, errors.Is(err, sql.ErrNoRows):
+		return true
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+	return true
+}
+
+// WrapConnector returns a driver.Connector whose connections run their work
+// through cb. A rejected call fails with the CircuitBreaker's error, which
+// database/sql returns as is
+func WrapConnector(c driver.Connector, cb *circuitbreaker.CircuitBreaker) driver.Connector {
+	return &connector{next: c, cb: cb}
+}
+
+type connector struct {
+	next driver.Connector
+	cb   *circuitbreaker.CircuitBreaker
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	next, err := as[driver.Conn](do(ctx, c.cb, func(ctx context.Context) (interface{}, error) {
+		return c.next.Connect(ctx)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return &conn{next: next, cb: c.cb}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.next.Driver()
+}
+
+// Close closes the wrapped connector if it's an io.Closer, which
+// database/sql does when the DB is closed
+func (c *connector) Close() error {
+	if closer, ok := c.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// do runs req through cb. driver.ErrSkip, with which a driver asks
+// database/sql to fall back to another way of running a query, says nothing
+// about the database, so it's ignored by cb and returned as is. Whatever req
+// returns after cb gave up on it, see RequestTimeout, is discarded
+func do(ctx context.Context, cb *circuitbreaker.CircuitBreaker, req func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	var late lateResult
+	result, err := cb.DoContext(ctx, func(ctx context.Context) (interface{}, error) {
+		result, err := req(ctx)
+		if errors.Is(err, driver.ErrSkip) {
+			return nil, circuitbreaker.Ignore(driver.ErrSkip)
+		}
+		late.arrive(result)
+		return result, err
+	})
+	switch {
+	case errors.Is(err, driver.ErrSkip):
+		return nil, driver.ErrSkip
+	case errors.Is(err, circuitbreaker.ErrRequestTimeout):
+		late.abandon()
+	}
+	return result, err
+}
+
+// lateResult discards the result of a request that's abandoned, whether it
+// arrives before or after the request is
+type lateResult struct {
+	mu        sync.Mutex
+	arrived   bool
+	abandoned bool
+	result    interface{}
+}
+
+func (l *lateResult) arrive(result interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.arrived, l.result = true, result
+	if l.abandoned {
+		discard(result)
+	}
+}
+
+func (l *lateResult) abandon() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.abandoned = true
+	if l.arrived {
+		discard(l.result)
+	}
+}
+
+// discard releases a connection, statement, transaction or rows nobody will
+func discard(result interface{}) {
+	switch r := result.(type) {
+	case driver.Tx:
+		_ = r.Rollback()
+	case io.Closer:
+		_ = r.Close()
+	}
+}
+
+// as converts the result of a call made through do, failing with
+// ErrUnexpectedResult if a Fallback replaced it with something else
+func as[T any](result interface{}, err error) (T, error) {
+	var zero T
+	if err != nil {
+		return zero, err
+	}
+	v, ok := result.(T)
+	if !ok {
+		return zero, ErrUnexpectedResult
+	}
+	return v, nil
+}
@@ -0,0 +1,253 @@
+package cbsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConnector hands out fakeConns, or queryerConns with queryer set, which
+// fail with err
+type fakeConnector struct {
+	err      error
+	queryer  bool
+	connects int
+	queries  int
+	closed   bool
+}
+
+func (c *fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.connects++
+	if errors.Is(c.err, driver.ErrBadConn) {
+		return nil, c.err
+	}
+	if c.queryer {
+		return &queryerConn{fakeConn{c}}, nil
+	}
+	return &fakeConn{c}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	return nil
+}
+
+func (c *fakeConnector) Close() error {
+	c.closed = true
+	return nil
+}
+
+type fakeConn struct {
+	c *fakeConnector
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c.c}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+type queryerConn struct {
+	fakeConn
+}
+
+func (c *queryerConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.c.queries++
+	if c.c.err != nil {
+		return nil, c.c.err
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeStmt struct {
+	c *fakeConnector
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.c.queries++
+	if s.c.err != nil {
+		return nil, s.c.err
+	}
+	return driver.RowsAffected(len(args)), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.c.queries++
+	if s.c.err != nil {
+		return nil, s.c.err
+	}
+	return &fakeRows{}, nil
+}
+
+// fakeRows has a single row with a single column
+type fakeRows struct {
+	read bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"n"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	r.read = true
+	dest[0] = int64(1)
+	return nil
+}
+
+func newBreaker() *circuitbreaker.CircuitBreaker {
+	return circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{
+		ConsecutiveFailureThreshold: 2,
+		IsSuccessful:                IsSuccessful,
+	})
+}
+
+func TestIsSuccessful(t *testing.T) {
+	assert.True(t, IsSuccessful(nil))
+	assert.True(t, IsSuccessful(sql.ErrNoRows))
+	assert.True(t, IsSuccessful(errors.New("syntax error")))
+	assert.False(t, IsSuccessful(driver.ErrBadConn))
+	assert.False(t, IsSuccessful(fmt.Errorf("query: %w", context.DeadlineExceeded)))
+}
+
+func TestWrapConnector(t *testing.T) {
+	fake := &fakeConnector{queryer: true}
+	cb := newBreaker()
+	db := sql.OpenDB(WrapConnector(fake, cb))
+
+	var n int
+	assert.Nil(t, db.QueryRow("SELECT 1").Scan(&n))
+	assert.Equal(t, 1, n)
+	assert.Equal(t, uint32(2), cb.Counts().TotalSuccesses) // connect and query
+
+	// no rows is a success
+	fake.err = sql.ErrNoRows
+	assert.Equal(t, sql.ErrNoRows, db.QueryRow("SELECT 1").Scan(&n))
+	assert.Equal(t, uint32(0), cb.Counts().TotalFailures)
+
+	// database/sql retries bad connections, each attempt counts
+	fake.err = driver.ErrBadConn
+	assert.ErrorIs(t, db.QueryRow("SELECT 1").Scan(&n), driver.ErrBadConn)
+	assert.Equal(t, circuitbreaker.StateOpen, cb.State())
+
+	// fails fast without reaching the database
+	fake.err = nil
+	connects, queries := fake.connects, fake.queries
+	assert.ErrorIs(t, db.QueryRow("SELECT 1").Scan(&n), circuitbreaker.ErrOpenState)
+	assert.Equal(t, connects, fake.connects)
+	assert.Equal(t, queries, fake.queries)
+
+	assert.Nil(t, db.Close())
+	assert.True(t, fake.closed)
+}
+
+// skipConn asks database/sql to run queries another way
+type skipConn struct {
+	fakeConn
+}
+
+func (c *skipConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+func TestErrSkipIgnored(t *testing.T) {
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	c := &conn{next: &skipConn{}, cb: cb}
+	_, err := c.QueryContext(context.Background(), "SELECT 1", nil)
+	assert.Equal(t, driver.ErrSkip, err)
+	assert.Equal(t, circuitbreaker.Counts{CurrRequests: 1}, cb.Counts())
+}
+
+func TestFallbackResult(t *testing.T) {
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{
+		Fallback: func(err error) (interface{}, error) {
+			return nil, nil
+		},
+	})
+	cb.ForceOpen()
+
+	_, err := WrapConnector(&fakeConnector{}, cb).Connect(context.Background())
+	assert.Equal(t, ErrUnexpectedResult, err)
+
+	ctx := context.Background()
+	c := &conn{next: &queryerConn{fakeConn{&fakeConnector{}}}, cb: cb}
+	_, err = c.PrepareContext(ctx, "SELECT 1")
+	assert.Equal(t, ErrUnexpectedResult, err)
+	_, err = c.BeginTx(ctx, driver.TxOptions{})
+	assert.Equal(t, ErrUnexpectedResult, err)
+	_, err = c.QueryContext(ctx, "SELECT 1", nil)
+	assert.Equal(t, ErrUnexpectedResult, err)
+
+	s := &stmt{next: &fakeStmt{&fakeConnector{}}, cb: cb}
+	_, err = s.ExecContext(ctx, nil)
+	assert.Equal(t, ErrUnexpectedResult, err)
+	_, err = s.QueryContext(ctx, nil)
+	assert.Equal(t, ErrUnexpectedResult, err)
+}
+
+// slowConnector connects once release is closed, with a connection that
+// records being closed
+type slowConnector struct {
+	fakeConnector
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (c *slowConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	<-c.release
+	return &closingConn{fakeConn{&c.fakeConnector}, c.closed}, nil
+}
+
+type closingConn struct {
+	fakeConn
+	closed chan struct{}
+}
+
+func (c *closingConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestRequestTimeoutClosesLateResults(t *testing.T) {
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{
+		RequestTimeout: 10 * time.Millisecond,
+	})
+	slow := &slowConnector{release: make(chan struct{}), closed: make(chan struct{})}
+	_, err := WrapConnector(slow, cb).Connect(context.Background())
+	assert.Equal(t, circuitbreaker.ErrRequestTimeout, err)
+
+	// the connection that arrives late is closed rather than leaked
+	close(slow.release)
+	select {
+	case <-slow.closed:
+	case <-time.After(time.Second):
+		t.Error("late connection not closed")
+	}
+}
@@ -0,0 +1,122 @@
+package cbsql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+)
+
+// errNoBeginTx is returned for a transaction with options on a connection
+// whose driver doesn't support them
+var errNoBeginTx = errors.New("cbsql: driver does not support transaction options")
+
+// conn wraps a driver.Conn. It implements the optional interfaces
+// database/sql looks for, falling back to what it would do itself when the
+// wrapped connection doesn't
+type conn struct {
+	next driver.Conn
+	cb   *circuitbreaker.CircuitBreaker
+}
+
+var (
+	_ driver.Conn               = (*conn)(nil)
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+	_ driver.Pinger             = (*conn)(nil)
+	_ driver.SessionResetter    = (*conn)(nil)
+	_ driver.Validator          = (*conn)(nil)
+	_ driver.NamedValueChecker  = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	next, err := as[driver.Stmt](do(ctx, c.cb, func(ctx context.Context) (interface{}, error) {
+		if p, ok := c.next.(driver.ConnPrepareContext); ok {
+			return p.PrepareContext(ctx, query)
+		}
+		return c.next.Prepare(query)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{next: next, cb: c.cb}, nil
+}
+
+func (c *conn) Close() error {
+	return c.next.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return as[driver.Tx](do(ctx, c.cb, func(ctx context.Context) (interface{}, error) {
+		if b, ok := c.next.(driver.ConnBeginTx); ok {
+			return b.BeginTx(ctx, opts)
+		}
+		if opts != (driver.TxOptions{}) {
+			return nil, errNoBeginTx
+		}
+		return c.next.Begin()
+	}))
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.next.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return as[driver.Result](do(ctx, c.cb, func(ctx context.Context) (interface{}, error) {
+		return e.ExecContext(ctx, query, args)
+	}))
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.next.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return as[driver.Rows](do(ctx, c.cb, func(ctx context.Context) (interface{}, error) {
+		return q.QueryContext(ctx, query, args)
+	}))
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	p, ok := c.next.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	_, err := do(ctx, c.cb, func(ctx context.Context) (interface{}, error) {
+		return nil, p.Ping(ctx)
+	})
+	return err
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.next.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.next.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.next.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
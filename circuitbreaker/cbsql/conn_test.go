@@ -0,0 +1,31 @@
+package cbsql
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnFallsBackToPrepare(t *testing.T) {
+	fake := &fakeConnector{}
+	cb := newBreaker()
+	db := sql.OpenDB(WrapConnector(fake, cb))
+	defer db.Close()
+
+	// driver.ErrSkip for the missing QueryerContext isn't counted
+	var n int
+	assert.Nil(t, db.QueryRow("SELECT ?", 1).Scan(&n))
+	assert.Equal(t, 1, n)
+	assert.Equal(t, uint32(3), cb.Counts().TotalSuccesses) // connect, prepare and query
+	assert.Equal(t, uint32(0), cb.Counts().TotalFailures)
+
+	// an error the database answered with is a success
+	fake.err = errors.New("syntax error")
+	_, err := db.Exec("SELEC 1")
+	assert.Equal(t, fake.err, err)
+	assert.Equal(t, uint32(0), cb.Counts().TotalFailures)
+	assert.Equal(t, circuitbreaker.StateClosed, cb.State())
+}
@@ -0,0 +1,98 @@
+package cbsql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+)
+
+// errNamedArgs is returned for named arguments to a statement whose driver
+// doesn't support them
+var errNamedArgs = errors.New("cbsql: driver does not support the use of Named Parameters")
+
+// stmt wraps a driver.Stmt so that executing it goes through the
+// CircuitBreaker
+type stmt struct {
+	next driver.Stmt
+	cb   *circuitbreaker.CircuitBreaker
+}
+
+var (
+	_ driver.Stmt              = (*stmt)(nil)
+	_ driver.StmtExecContext   = (*stmt)(nil)
+	_ driver.StmtQueryContext  = (*stmt)(nil)
+	_ driver.NamedValueChecker = (*stmt)(nil)
+)
+
+func (s *stmt) Close() error {
+	return s.next.Close()
+}
+
+func (s *stmt) NumInput() int {
+	return s.next.NumInput()
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return as[driver.Result](do(ctx, s.cb, func(ctx context.Context) (interface{}, error) {
+		if e, ok := s.next.(driver.StmtExecContext); ok {
+			return e.ExecContext(ctx, args)
+		}
+		values, err := values(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.next.Exec(values)
+	}))
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return as[driver.Rows](do(ctx, s.cb, func(ctx context.Context) (interface{}, error) {
+		if q, ok := s.next.(driver.StmtQueryContext); ok {
+			return q.QueryContext(ctx, args)
+		}
+		values, err := values(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.next.Query(values)
+	}))
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.next.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// namedValues converts positional arguments to what the Context methods take
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+// values converts arguments back to positional ones for a driver that
+// doesn't implement the Context methods, as database/sql does
+func values(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errNamedArgs
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
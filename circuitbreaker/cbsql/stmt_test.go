@@ -0,0 +1,39 @@
+package cbsql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStmt(t *testing.T) {
+	fake := &fakeConnector{}
+	cb := newBreaker()
+	db := sql.OpenDB(WrapConnector(fake, cb))
+	defer db.Close()
+
+	s, err := db.Prepare("INSERT INTO t VALUES (?, ?)")
+	assert.Nil(t, err)
+	defer s.Close()
+
+	result, err := s.Exec(1, 2)
+	assert.Nil(t, err)
+	affected, err := result.RowsAffected()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), affected)
+
+	// named arguments need a driver that supports them
+	_, err = s.Exec(sql.Named("a", 1), sql.Named("b", 2))
+	assert.Equal(t, errNamedArgs, err)
+
+	// executions time out through the breaker
+	fake.err = context.DeadlineExceeded
+	for i := 0; i < 3; i++ {
+		_, err = s.Exec(1, 2)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	}
+	assert.Equal(t, circuitbreaker.StateOpen, cb.State())
+}
@@ -0,0 +1,51 @@
+// Package cbtest helps test code that uses a circuit breaker
+package cbtest
+
+import (
+	"sync"
+	"time"
+)
+
+// ManualClock is a circuitbreaker.Clock that only moves when told to, so that
+// tests can go past a CircuitBreaker's Interval or TimeoutOpenState without
+// waiting:
+//
+//	clock := cbtest.NewManualClock(time.Now())
+//	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{Clock: clock})
+//	// trip cb
+//	clock.Advance(time.Minute) // cb is now half-open
+//
+// It's safe for concurrent use
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock returns a ManualClock set to now
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the time the clock is set to
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Set sets the clock to now, which may be in its past
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
@@ -0,0 +1,55 @@
+package cbtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	assert.Equal(t, start, clock.Now())
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), clock.Now())
+
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now())
+}
+
+func TestManualClockWithCircuitBreaker(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{
+		Interval:         time.Duration(30) * time.Second,
+		TimeoutOpenState: time.Duration(60) * time.Second,
+		Clock:            clock,
+	})
+	errFail := errors.New("fail")
+	fail := func() (interface{}, error) { return nil, errFail }
+
+	_, _ = cb.Do(fail)
+	clock.Advance(time.Duration(31) * time.Second)
+	assert.Equal(t, uint64(2), cb.Generation())
+	assert.Equal(t, circuitbreaker.Counts{}, cb.Counts())
+
+	for i := 0; i < 6; i++ {
+		_, _ = cb.Do(fail)
+	}
+	assert.Equal(t, circuitbreaker.StateOpen, cb.State())
+
+	clock.Advance(time.Duration(59) * time.Second)
+	assert.Equal(t, circuitbreaker.StateOpen, cb.State())
+	clock.Advance(time.Duration(2) * time.Second)
+	assert.Equal(t, circuitbreaker.StateHalfOpen, cb.State())
+	assert.Equal(t, time.Second, cb.TimeInState())
+
+	clock.Advance(time.Duration(5) * time.Second)
+	_, err := cb.Do(func() (interface{}, error) { return nil, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, circuitbreaker.StateClosed, cb.State())
+	assert.Equal(t, clock.Now(), cb.LastStateChange())
+}
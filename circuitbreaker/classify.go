@@ -0,0 +1,99 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"time"
+)
+
+// Outcome is how a request's result counts towards the CircuitBreaker's
+// decisions, see Config.Classify. It only decides how the request counts: Do
+// returns what the request returned unchanged, whatever the outcome, so that
+// e.g. a partial result with a non-fatal error can count as a success without
+// tripping the CircuitBreaker and still reach the caller along with its error.
+// Only Fallback with FallbackOnError replaces the error of an admitted
+// request, and it does so for any outcome
+type Outcome int
+
+const (
+	// OutcomeSuccess counts the request as a success
+	OutcomeSuccess Outcome = iota
+
+	// OutcomeFailure counts the request as a failure
+	OutcomeFailure
+
+	// OutcomeIgnore counts the request as neither, e.g. for a not found or a
+	// validation error that says nothing about the dependency's health. It
+	// doesn't add to the successes or failures nor affect the consecutive
+	// counts, and a half-open slot it took up is handed back
+	OutcomeIgnore
+
+	// OutcomeBackoff is for a request whose dependency answered but asked to
+	// be backed off from, e.g. with a response flagged as degraded. Unlike a
+	// failure, it isn't counted at all, so that it doesn't break a streak of
+	// consecutive successes. Instead, it trips the CircuitBreaker gently: it
+	// opens for as long as the dependency asked, which RetryAfter and the
+	// rejections report, without a failure to its name. The back-off comes
+	// from BackoffHint; otherwise it's TimeoutOpenState. A CircuitBreaker kept
+	// closed by ForceClose or the ResetGracePeriod doesn't back off
+	OutcomeBackoff
+)
+
+// String implements stringer interface
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeSuccess:
+		return "success"
+	case OutcomeFailure:
+		return "failure"
+	case OutcomeIgnore:
+		return "ignore"
+	case OutcomeBackoff:
+		return "backoff"
+	default:
+		return fmt.Sprintf("unknown outcome: %d", o)
+	}
+}
+
+// outcomeOf converts the result of IsSuccessful to an Outcome
+func outcomeOf(success bool) Outcome {
+	if success {
+		return OutcomeSuccess
+	}
+	return OutcomeFailure
+}
+
+// classifyError tells how a request's error counts, with Classify if it's set
+// and IsSuccessful otherwise. An IgnoredError is always ignored
+func (cb *CircuitBreaker) classifyError(err error) Outcome {
+	if _, ok := err.(IgnoredError); ok {
+		return OutcomeIgnore
+	}
+	if cb.classifyErr != nil {
+		return cb.classifyErr(err)
+	}
+	return outcomeOf(cb.isSuccessful(err))
+}
+
+// newOutcome returns the outcome of a request that completed with the given
+// classification
+func newOutcome(o Outcome, duration time.Duration) outcome {
+	return outcome{
+		success:  o == OutcomeSuccess,
+		ignore:   o == OutcomeIgnore,
+		backoff:  o == OutcomeBackoff,
+		duration: duration,
+	}
+}
+
+// evaluateBackoff returns the state a request whose dependency asked to be
+// backed off from moves the CircuitBreaker to, see OutcomeBackoff
+func (cb *CircuitBreaker) evaluateBackoff(state State, o outcome, now time.Time) State {
+	if state == StateClosed && (cb.inGracePeriod(now) || cb.forceMode == ForceModeClosed) {
+		return state
+	}
+	cb.openFor = o.backoffFor
+	if cb.openFor <= 0 {
+		cb.openFor = cb.timeoutOpenState
+	}
+	return StateOpen
+}
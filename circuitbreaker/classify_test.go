@@ -0,0 +1,240 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	errNotFound = errors.New("not found")
+	errBackoff  = errors.New("back off")
+)
+
+func classifyNotFound(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, errNotFound):
+		return OutcomeIgnore
+	default:
+		return OutcomeFailure
+	}
+}
+
+func TestOutcomeString(t *testing.T) {
+	assert.Equal(t, "success", OutcomeSuccess.String())
+	assert.Equal(t, "failure", OutcomeFailure.String())
+	assert.Equal(t, "ignore", OutcomeIgnore.String())
+	assert.Equal(t, "backoff", OutcomeBackoff.String())
+	assert.Equal(t, "unknown outcome: 4", Outcome(4).String())
+}
+
+func TestClassify(t *testing.T) {
+	var traced []TraceEvent
+	cb := NewCircuitBreaker(Config{
+		Classify: classifyNotFound,
+		// takes precedence over IsSuccessful
+		IsSuccessful: func(error) bool { return true },
+		Trace: func(event TraceEvent) {
+			traced = append(traced, event)
+		},
+	})
+	notFound := func() error {
+		_, err := cb.Do(func() (interface{}, error) { return nil, errNotFound })
+		return err
+	}
+
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, errNotFound, notFound())
+	assert.Equal(t, Counts{2, 1, 0, 1, 0, 0}, cb.Counts())
+	assert.True(t, traced[1].Ignored)
+	assert.False(t, traced[1].Success)
+
+	// doesn't break a streak of failures
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, errNotFound, notFound())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, Counts{5, 0, 2, 1, 2, 0}, cb.Counts())
+
+	// hands back the half-open slot
+	cb.ForceOpen()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, errNotFound, notFound())
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestClassifyTwoStepAndOperation(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Config{Classify: classifyNotFound})
+	done, err := tscb.AllowOutcome()
+	assert.Nil(t, err)
+	done(OutcomeIgnore)
+	done, err = tscb.AllowOutcome()
+	assert.Nil(t, err)
+	done(OutcomeFailure)
+	assert.Equal(t, Counts{2, 0, 1, 0, 1, 0}, tscb.Counts())
+
+	cb := NewCircuitBreaker(Config{Classify: classifyNotFound})
+	op, err := cb.BeginOperation()
+	assert.Nil(t, err)
+	op.Record(errNotFound)
+	op.Record(errNotFound)
+	op.End()
+	assert.Equal(t, Counts{1, 0, 0, 0, 0, 0}, cb.Counts())
+
+	// an ignored attempt doesn't hide a failed one
+	op, err = cb.BeginOperation()
+	assert.Nil(t, err)
+	op.Record(errNotFound)
+	op.Record(errors.New("fail"))
+	op.End()
+	assert.Equal(t, Counts{2, 0, 1, 0, 1, 0}, cb.Counts())
+}
+
+func TestIsSuccessfulResult(t *testing.T) {
+	type response struct{ status int }
+	cb := NewCircuitBreaker(Config{
+		IsSuccessful: func(err error) bool { return true },
+		IsSuccessfulResult: func(result interface{}, err error) bool {
+			resp, ok := result.(response)
+			return err == nil && (!ok || resp.status < 500)
+		},
+	})
+
+	// preferred over IsSuccessful
+	_, err := cb.Do(func() (interface{}, error) { return response{status: 503}, nil })
+	assert.Nil(t, err)
+	_, err = cb.Do(func() (interface{}, error) { return response{status: 200}, nil })
+	assert.Nil(t, err)
+	_, _ = cb.Do(func() (interface{}, error) { return nil, errNotFound })
+	assert.Equal(t, Counts{3, 0, 1, 1, 2, 0}, cb.Counts())
+
+	// also for the generic variants and batches
+	gcb := &GenericCircuitBreaker[response]{CircuitBreaker: cb}
+	_, err = gcb.Do(func() (response, error) { return response{status: 500}, nil })
+	assert.Nil(t, err)
+	_, errs := cb.DoBatch([]func() (interface{}, error){
+		func() (interface{}, error) { return response{status: 502}, nil },
+	})
+	assert.Equal(t, []error{nil}, errs)
+	assert.Equal(t, Counts{5, 0, 3, 1, 4, 0}, cb.Counts())
+
+	// operations have no result, IsSuccessful is used
+	op, err := cb.BeginOperation()
+	assert.Nil(t, err)
+	op.Record(errNotFound)
+	op.End()
+	assert.Equal(t, Counts{6, 1, 0, 2, 4, 0}, cb.Counts())
+}
+
+func TestClassificationDoesNotChangeWhatDoReturns(t *testing.T) {
+	errPartial := errors.New("partial")
+	errIgnored := errors.New("ignored")
+	errFail := errors.New("fail")
+	partial := []int{1, 2}
+	newCB := func(cfg Config) *CircuitBreaker {
+		cfg.Classify = func(err error) Outcome {
+			switch err {
+			case nil, errPartial:
+				return OutcomeSuccess
+			case errIgnored:
+				return OutcomeIgnore
+			}
+			return OutcomeFailure
+		}
+		return NewCircuitBreaker(cfg)
+	}
+	cb := newCB(Config{ConsecutiveFailureThreshold: 1})
+
+	for _, err := range []error{errPartial, errIgnored, errFail} {
+		err := err
+		result, got := cb.Do(func() (interface{}, error) { return partial, err })
+		assert.Equal(t, err, got)
+		assert.Equal(t, partial, result)
+	}
+	assert.Equal(t, Counts{3, 0, 1, 1, 1, 0}, cb.Counts())
+
+	// a partial result counted as a success doesn't trip it
+	for i := 0; i < 10; i++ {
+		_, err := cb.Do(func() (interface{}, error) { return partial, errPartial })
+		assert.Equal(t, errPartial, err)
+	}
+	assert.Equal(t, StateClosed, cb.State())
+
+	// FallbackOnError replaces the error whatever the outcome
+	cb = newCB(Config{
+		Fallback:        func(err error) (interface{}, error) { return "fallback", nil },
+		FallbackOnError: true,
+	})
+	result, err := cb.Do(func() (interface{}, error) { return partial, errPartial })
+	assert.Nil(t, err)
+	assert.Equal(t, "fallback", result)
+	assert.Equal(t, uint32(1), cb.Counts().TotalSuccesses)
+}
+
+func TestBackoff(t *testing.T) {
+	clock := &steppingClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var trips []Counts
+	cb := NewCircuitBreaker(Config{
+		Clock: clock,
+		BackoffHint: func(result interface{}, err error) time.Duration {
+			if result == "degraded" {
+				return 30 * time.Second
+			}
+			return 0
+		},
+		Classify: func(err error) Outcome {
+			if err == errBackoff {
+				return OutcomeBackoff
+			}
+			return outcomeOf(err == nil)
+		},
+		Notifiers: []Notifier{FuncNotifier(func(event Event) {
+			if event.Type == EventTrip {
+				trips = append(trips, event.Counts)
+			}
+		})},
+	})
+	degraded := func() (interface{}, error) { return "degraded", nil }
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	result, err := cb.Do(degraded)
+	assert.Equal(t, "degraded", result)
+	assert.Nil(t, err)
+
+	// open for the back-off asked for, without a failure to its name nor a
+	// broken success streak
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, []Counts{{4, 3, 0, 3, 0, 0}}, trips)
+	assert.Equal(t, Stats{Requests: 4, Successes: 3, Trips: 1}, cb.Stats())
+	assert.Equal(t, 30*time.Second, cb.RetryAfter())
+	assert.ErrorIs(t, succeed(cb), ErrOpenState)
+
+	// a probe asked to back off backs off again
+	clock.now = clock.now.Add(31 * time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	_, _ = cb.Do(degraded)
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, 30*time.Second, cb.RetryAfter())
+
+	clock.now = clock.now.Add(31 * time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+
+	// without a hint, the back-off is TimeoutOpenState
+	_, err = cb.Do(func() (interface{}, error) { return nil, errBackoff })
+	assert.Equal(t, errBackoff, err)
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, 60*time.Second, cb.RetryAfter())
+
+	// ForceClose keeps it closed
+	cb.ForceClose()
+	_, _ = cb.Do(degraded)
+	assert.Equal(t, StateClosed, cb.State())
+}
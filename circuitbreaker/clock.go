@@ -0,0 +1,23 @@
+package circuitbreaker
+
+import "time"
+
+// Clock tells the CircuitBreaker the time, see Config.Clock
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock used unless one is configured
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (cb *CircuitBreaker) now() time.Time {
+	return cb.clock.Now()
+}
+
+func (cb *CircuitBreaker) since(t time.Time) time.Duration {
+	return cb.now().Sub(t)
+}
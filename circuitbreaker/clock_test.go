@@ -0,0 +1,33 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestClock(t *testing.T) {
+	assert.WithinDuration(t, time.Now(), NewCircuitBreaker(Config{}).now(), time.Second)
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var got []Event
+	cb := NewCircuitBreaker(Config{
+		Clock: fixedClock(now),
+		Notifiers: []Notifier{FuncNotifier(func(event Event) {
+			got = append(got, event)
+		})},
+	})
+	assert.Equal(t, now, cb.LastStateChange())
+
+	cb.ForceOpen()
+	assert.Len(t, got, 2)
+	assert.Equal(t, now, got[0].Time)
+	assert.Equal(t, time.Duration(60)*time.Second, cb.RetryAfter())
+}
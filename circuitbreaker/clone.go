@@ -0,0 +1,16 @@
+package circuitbreaker
+
+// Clone returns a new CircuitBreaker configured like this one, with modify,
+// if it's not nil, applied to a copy of the Config it was created with, e.g.
+// to try out a different TimeoutOpenState with the same trip policy. The
+// clone starts afresh in the closed state: no state, counts or ForceMode are
+// carried over, nor are Notifiers added with AddNotifier. Defaults are
+// applied after modify, as they are for NewCircuitBreaker
+func (cb *CircuitBreaker) Clone(modify func(cfg *Config)) *CircuitBreaker {
+	cfg := cb.config
+	cfg.Notifiers = append([]Notifier(nil), cfg.Notifiers...)
+	if modify != nil {
+		modify(&cfg)
+	}
+	return NewCircuitBreaker(cfg)
+}
@@ -0,0 +1,50 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClone(t *testing.T) {
+	trips := 0
+	cb := NewCircuitBreaker(Config{
+		Name:                     "payments-api",
+		MaxRequestsWhileHalfOpen: 2,
+		ShouldTrip: func(counts Counts) bool {
+			trips++
+			return counts.ConsecutiveFailures >= 2
+		},
+	})
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	clone := cb.Clone(func(cfg *Config) {
+		cfg.TimeoutOpenState = time.Duration(10) * time.Second
+	})
+	assert.NotSame(t, cb, clone)
+	assert.Equal(t, "payments-api", clone.Name())
+	assert.Equal(t, time.Duration(10)*time.Second, clone.timeoutOpenState)
+	assert.Equal(t, uint32(2), clone.maxRequestsWhileHalfOpen)
+
+	// starts afresh, with the same trip policy
+	assert.Equal(t, StateClosed, clone.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, clone.Counts())
+	assert.Nil(t, fail(clone))
+	assert.Nil(t, fail(clone))
+	assert.Equal(t, StateOpen, clone.State())
+	assert.Equal(t, 4, trips)
+
+	// the original is unaffected
+	assert.Equal(t, time.Duration(60)*time.Second, cb.timeoutOpenState)
+
+	// defaults are resolved again
+	clone = cb.Clone(func(cfg *Config) {
+		cfg.MaxRequestsWhileHalfOpen = 5
+		cfg.HalfOpenSuccessRatio = 0.5
+	})
+	assert.Equal(t, uint32(5), clone.halfOpenMaxRequests)
+	assert.Equal(t, time.Duration(60)*time.Second, cb.Clone(nil).timeoutOpenState)
+}
@@ -0,0 +1,41 @@
+package circuitbreaker
+
+import (
+	"context"
+	"time"
+)
+
+// CallInfo describes how a request made with DoDetailed went through the
+// CircuitBreaker
+type CallInfo struct {
+	// ShortCircuited is set if the CircuitBreaker rejected the request
+	// without running it, even if Fallback replaced the rejection
+	ShortCircuited bool
+
+	// Duration is how long the call took, including Fallback
+	Duration time.Duration
+
+	// StateBefore is the state the CircuitBreaker admitted or rejected the
+	// request in
+	StateBefore State
+
+	// StateAfter is the state of the CircuitBreaker once the call returned
+	StateAfter State
+}
+
+// DoDetailed is like Do but also returns a CallInfo describing the call, which
+// saves instrumentation from timing every call and reading the state around
+// it. Do doesn't pay for any of it
+func (cb *CircuitBreaker) DoDetailed(req func() (interface{}, error)) (interface{}, CallInfo, error) {
+	start := cb.now()
+	result, state, shortCircuited, err := cb.doWithFallback(nil, func(context.Context) (interface{}, error) {
+		return req()
+	})
+	info := CallInfo{
+		ShortCircuited: shortCircuited,
+		Duration:       cb.since(start),
+		StateBefore:    state,
+		StateAfter:     cb.State(),
+	}
+	return result, info, err
+}
@@ -0,0 +1,60 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoDetailed(t *testing.T) {
+	errFail := errors.New("fail")
+	cb := NewCircuitBreaker(Config{ConsecutiveFailureThreshold: 1})
+
+	result, info, err := cb.DoDetailed(func() (interface{}, error) {
+		time.Sleep(time.Duration(10) * time.Millisecond)
+		return 1, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result)
+	assert.False(t, info.ShortCircuited)
+	assert.GreaterOrEqual(t, info.Duration, time.Duration(10)*time.Millisecond)
+	assert.Equal(t, StateClosed, info.StateBefore)
+	assert.Equal(t, StateClosed, info.StateAfter)
+
+	assert.Nil(t, fail(cb))
+	_, info, err = cb.DoDetailed(func() (interface{}, error) { return nil, errFail })
+	assert.Equal(t, errFail, err)
+	assert.False(t, info.ShortCircuited)
+	assert.Equal(t, StateClosed, info.StateBefore)
+	assert.Equal(t, StateOpen, info.StateAfter)
+
+	_, info, err = cb.DoDetailed(func() (interface{}, error) { return 2, nil })
+	assert.ErrorIs(t, err, ErrOpenState)
+	assert.True(t, info.ShortCircuited)
+	assert.Equal(t, StateOpen, info.StateBefore)
+	assert.Equal(t, StateOpen, info.StateAfter)
+
+	// a rejection from another CircuitBreaker isn't a short circuit
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	_, info, err = cb.DoDetailed(func() (interface{}, error) { return nil, ErrOpenState })
+	assert.Equal(t, ErrOpenState, err)
+	assert.False(t, info.ShortCircuited)
+	assert.Equal(t, StateHalfOpen, info.StateBefore)
+	assert.Equal(t, StateOpen, info.StateAfter)
+}
+
+func TestDoDetailedFallback(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		Fallback: func(err error) (interface{}, error) {
+			return "fallback", nil
+		},
+	})
+	cb.ForceOpen()
+
+	result, info, err := cb.DoDetailed(func() (interface{}, error) { return 1, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "fallback", result)
+	assert.True(t, info.ShortCircuited)
+}
@@ -0,0 +1,63 @@
+package circuitbreaker
+
+import "context"
+
+// Drain stops the CircuitBreaker from admitting requests, which are rejected
+// with ErrDraining from then on, and waits for the requests in flight to
+// complete, e.g. before the instance shuts down. It returns nil once none are
+// left, or ctx.Err() if ctx is done first, in which case the CircuitBreaker
+// keeps draining. Draining can't be undone and Ready reports false from the
+// moment it starts. The state and counts are left alone, so Healthy keeps
+// reporting on the dependency. Drain is safe to call more than once
+func (cb *CircuitBreaker) Drain(ctx context.Context) error {
+	cb.lock()
+	if cb.drained == nil {
+		cb.drained = make(chan struct{})
+		cb.draining.Store(true)
+	}
+	drained := cb.drained
+	cb.mu.Unlock()
+
+	if cb.activeRequests.Load() == 0 {
+		cb.signalDrained()
+	}
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// signalDrained wakes up the callers of Drain once the last request in flight
+// is over. Both Drain and the last request can see the other one, so it's
+// safe to call more than once
+func (cb *CircuitBreaker) signalDrained() {
+	cb.lock()
+	defer cb.mu.Unlock()
+
+	select {
+	case <-cb.drained:
+	default:
+		close(cb.drained)
+	}
+}
+
+// Ready reports whether the CircuitBreaker should be sent traffic, e.g. for
+// an orchestrator's readiness probe. It's false once Drain or Close has been
+// called, and true otherwise, whatever the state: a tripped dependency
+// shouldn't take the instance out of rotation, it's for Healthy to report.
+// The two answer different questions:
+//
+//	lifecycle    state                Ready  Healthy
+//	running      closed, healthy      true   true
+//	running      closed, unhealthy    true   false
+//	running      half-open or open    true   false
+//	draining     any                  false  as when running
+//	after Close  any                  false  as when running
+func (cb *CircuitBreaker) Ready() bool {
+	cb.lock()
+	defer cb.mu.Unlock()
+
+	return !cb.draining.Load() && !cb.closed
+}
@@ -0,0 +1,65 @@
+package circuitbreaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrain(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	assert.True(t, cb.Ready())
+	assert.True(t, cb.Healthy())
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		_, _ = cb.Do(func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+	}()
+	<-started
+
+	// out of rotation while draining, but the dependency is still healthy
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, cb.Drain(ctx))
+	assert.False(t, cb.Ready())
+	assert.True(t, cb.Healthy())
+
+	err := succeed(cb)
+	assert.Equal(t, RejectedError{State: StateClosed, Err: ErrDraining}, err)
+	assert.True(t, IsRejection(err))
+	assert.False(t, cb.CanProceed())
+
+	drained := make(chan error)
+	go func() { drained <- cb.Drain(context.Background()) }()
+	close(release)
+	assert.Nil(t, <-drained)
+	assert.Nil(t, cb.Drain(context.Background()))
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, cb.Counts())
+	assert.False(t, cb.Ready())
+}
+
+func TestReady(t *testing.T) {
+	// a tripped dependency doesn't take the instance out of rotation
+	cb := NewCircuitBreaker(Config{})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	assert.True(t, cb.Ready())
+	assert.False(t, cb.Healthy())
+
+	// nor does draining an idle one wait
+	assert.Nil(t, cb.Drain(context.Background()))
+	assert.False(t, cb.Ready())
+
+	cb = NewCircuitBreaker(Config{})
+	cb.Close()
+	assert.False(t, cb.Ready())
+	assert.True(t, cb.Healthy())
+}
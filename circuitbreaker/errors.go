@@ -0,0 +1,148 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RejectedError is returned for a request the CircuitBreaker rejects. It wraps
+// the reason, one of ErrOpenState, a TooManyRequestsError,
+// ErrTooManyConcurrent, ErrRateLimited, ErrRecovering,
+// ErrSharedCountsUnavailable or ErrDraining, so that errors.Is keeps working
+// with them, and tells which state the CircuitBreaker was in when it made the
+// decision, which a later call to State might no longer see:
+//
+//	var rejected circuitbreaker.RejectedError
+//	if errors.As(err, &rejected) && rejected.State == circuitbreaker.StateHalfOpen {
+//		// probing, try again shortly
+//	}
+type RejectedError struct {
+	// Name is the name of the CircuitBreaker, if it has one
+	Name string
+
+	// State is the state the request was rejected in
+	State State
+
+	// Err is the reason the request was rejected
+	Err error
+}
+
+func (e RejectedError) Error() string {
+	if e.Name == "" {
+		return e.Err.Error()
+	}
+	if e.Err == ErrOpenState {
+		return fmt.Sprintf("circuit breaker '%s' is open", e.Name)
+	}
+	return fmt.Sprintf("circuit breaker '%s': %v", e.Name, e.Err)
+}
+
+func (e RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// TooManyRequestsError is the reason a request is rejected for when the
+// CircuitBreaker is half-open and won't admit another probe, wrapped in a
+// RejectedError. It wraps ErrTooManyRequests, and tells how many probes were
+// in the generation when the request was rejected, e.g. to decide whether to
+// wait for a free slot:
+//
+//	var tooMany circuitbreaker.TooManyRequestsError
+//	if errors.As(err, &tooMany) && tooMany.CurrRequests < tooMany.MaxRequests {
+//		// a slot may free up shortly
+//	}
+//
+// CurrRequests can be below MaxRequests if the probe in flight must finish
+// first, see HalfOpenSingleProbe, or a HalfOpenAdmissionStrategy declined the
+// request
+type TooManyRequestsError struct {
+	// MaxRequests is the number of probes admitted while half-open
+	MaxRequests uint32
+
+	// CurrRequests is the number of probes admitted in the current
+	// generation so far, less those that were ignored
+	CurrRequests uint32
+}
+
+func (e TooManyRequestsError) Error() string {
+	return ErrTooManyRequests.Error()
+}
+
+func (e TooManyRequestsError) Unwrap() error {
+	return ErrTooManyRequests
+}
+
+// PanicError is returned for a request that panicked when RecoverPanics is
+// set. It wraps ErrRequestPanicked, as well as the panic value if that's an
+// error
+type PanicError struct {
+	// Value is the value the request panicked with
+	Value interface{}
+
+	// Stack is the stack trace of the panicking goroutine, as formatted by
+	// runtime/debug.Stack
+	Stack []byte
+}
+
+func (e PanicError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrRequestPanicked, e.Value)
+}
+
+func (e PanicError) Unwrap() []error {
+	if err, ok := e.Value.(error); ok {
+		return []error{ErrRequestPanicked, err}
+	}
+	return []error{ErrRequestPanicked}
+}
+
+// IgnoredError wraps the error of a request that counts as OutcomeIgnore,
+// whatever Classify, IsSuccessful and the like make of it, see Ignore
+type IgnoredError struct {
+	Err error
+}
+
+func (e IgnoredError) Error() string {
+	return e.Err.Error()
+}
+
+func (e IgnoredError) Unwrap() error {
+	return e.Err
+}
+
+// Ignore wraps err in an IgnoredError, so that a request returning it is
+// ignored by the CircuitBreaker, e.g. for an error that says nothing about the
+// dependency in a wrapper that doesn't control the classification. The
+// request's caller gets the IgnoredError, which errors.Is and errors.As see
+// through. Ignore returns nil for a nil err
+func Ignore(err error) error {
+	if err == nil {
+		return nil
+	}
+	return IgnoredError{Err: err}
+}
+
+// rejectError returns the error a request rejected for err in the given state
+// fails with. It must be called with the mutex held
+func (cb *CircuitBreaker) rejectError(err error, state State) error {
+	if err == ErrTooManyRequests {
+		err = TooManyRequestsError{
+			MaxRequests:  cb.halfOpenRequestLimit(),
+			CurrRequests: cb.counts.CurrRequests,
+		}
+	}
+	return RejectedError{Name: cb.name, State: state, Err: err}
+}
+
+// IsRejection reports whether err is, or wraps, one of the errors a
+// CircuitBreaker rejects requests with: ErrOpenState, ErrTooManyRequests,
+// ErrTooManyConcurrent, ErrRateLimited, ErrRecovering,
+// ErrSharedCountsUnavailable or ErrDraining
+func IsRejection(err error) bool {
+	return errors.Is(err, ErrOpenState) ||
+		errors.Is(err, ErrTooManyRequests) ||
+		errors.Is(err, ErrTooManyConcurrent) ||
+		errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrRecovering) ||
+		errors.Is(err, ErrSharedCountsUnavailable) ||
+		errors.Is(err, ErrDraining)
+}
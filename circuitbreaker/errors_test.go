@@ -0,0 +1,133 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamedErrors(t *testing.T) {
+	cb := NewCircuitBreaker(Config{Name: "payments-api"})
+	assert.Equal(t, "payments-api", cb.Name())
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+
+	err := succeed(cb)
+	assert.True(t, errors.Is(err, ErrOpenState))
+	assert.Equal(t, "circuit breaker 'payments-api' is open", err.Error())
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	err = succeed(cb)
+	assert.True(t, errors.Is(err, ErrTooManyRequests))
+	assert.Equal(t, "circuit breaker 'payments-api': too many requests", err.Error())
+	assert.Nil(t, <-ch)
+
+	// unnamed breakers keep the sentinels' messages
+	tscb := NewTwoStepCircuitBreaker(Config{})
+	assert.Equal(t, "", tscb.Name())
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail2Step(tscb))
+	}
+	err = succeed2Step(tscb)
+	assert.ErrorIs(t, err, ErrOpenState)
+	assert.Equal(t, ErrOpenState.Error(), err.Error())
+}
+
+func TestRejectedError(t *testing.T) {
+	cb := NewCircuitBreaker(Config{Name: "payments-api"})
+	cb.ForceOpen()
+	err := succeed(cb)
+	var rejected RejectedError
+	assert.True(t, errors.As(err, &rejected))
+	assert.Equal(t, RejectedError{Name: "payments-api", State: StateOpen, Err: ErrOpenState}, rejected)
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	err = succeed(cb)
+	assert.True(t, errors.As(err, &rejected))
+	assert.Equal(t, StateHalfOpen, rejected.State)
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+	assert.Nil(t, <-ch)
+
+	// the two-step breaker returns the same error
+	tscb := NewTwoStepCircuitBreaker(Config{})
+	tscb.cb.ForceOpen()
+	_, err = tscb.Allow()
+	assert.Equal(t, RejectedError{State: StateOpen, Err: ErrOpenState}, err)
+
+	// errors of admitted requests aren't rejections
+	_, err = NewCircuitBreaker(Config{}).Do(func() (interface{}, error) { return nil, errors.New("fail") })
+	assert.False(t, errors.As(err, &rejected))
+}
+
+func TestPanicError(t *testing.T) {
+	err := PanicError{Value: "oops"}
+	assert.Equal(t, "request panicked: oops", err.Error())
+	assert.ErrorIs(t, err, ErrRequestPanicked)
+	assert.False(t, IsRejection(err))
+
+	errFail := errors.New("fail")
+	err = PanicError{Value: errFail}
+	assert.Equal(t, "request panicked: fail", err.Error())
+	assert.ErrorIs(t, err, ErrRequestPanicked)
+	assert.ErrorIs(t, err, errFail)
+}
+
+func TestTooManyRequestsError(t *testing.T) {
+	cb := NewCircuitBreaker(Config{Name: "x", MaxRequestsWhileHalfOpen: 3})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	pseudoSleep(cb, time.Duration(61)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	// every probe slot is taken
+	for i := 0; i < 3; i++ {
+		_, _, err := cb.beforeRequest()
+		assert.Nil(t, err)
+	}
+
+	err := succeed(cb)
+	var tooMany TooManyRequestsError
+	assert.True(t, errors.As(err, &tooMany))
+	assert.Equal(t, TooManyRequestsError{MaxRequests: 3, CurrRequests: 3}, tooMany)
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+	assert.True(t, IsRejection(err))
+	assert.Equal(t, "circuit breaker 'x': too many requests", err.Error())
+}
+
+func TestIgnore(t *testing.T) {
+	assert.Nil(t, Ignore(nil))
+
+	errSkip := errors.New("skip")
+	cb := NewCircuitBreaker(Config{IsSuccessful: func(err error) bool { return err == nil }})
+	assert.Nil(t, succeed(cb))
+	_, err := cb.Do(func() (interface{}, error) { return nil, Ignore(errSkip) })
+	assert.Equal(t, IgnoredError{Err: errSkip}, err)
+	assert.ErrorIs(t, err, errSkip)
+	assert.Equal(t, "skip", err.Error())
+	assert.Equal(t, Counts{2, 1, 0, 1, 0, 0}, cb.Counts())
+
+	op, err := cb.BeginOperation()
+	assert.Nil(t, err)
+	op.Record(Ignore(errSkip))
+	op.End()
+	assert.Equal(t, Counts{3, 1, 0, 1, 0, 0}, cb.Counts())
+}
+
+func TestIsRejection(t *testing.T) {
+	assert.True(t, IsRejection(ErrOpenState))
+	assert.True(t, IsRejection(ErrTooManyRequests))
+	assert.True(t, IsRejection(TooManyRequestsError{MaxRequests: 1, CurrRequests: 1}))
+	assert.True(t, IsRejection(ErrRateLimited))
+	assert.True(t, IsRejection(RejectedError{Name: "x", State: StateOpen, Err: ErrOpenState}))
+	assert.False(t, IsRejection(ErrRequestTimeout))
+	assert.False(t, IsRejection(errors.New("fail")))
+	assert.False(t, IsRejection(nil))
+}
@@ -0,0 +1,41 @@
+package circuitbreaker
+
+// Events returns a channel on which the CircuitBreaker sends its events,
+// including an EventStateChange for every transition. It's created on the
+// first call, so only events from then on are sent. Events are dropped rather
+// than blocking the CircuitBreaker when the channel is full, see
+// DroppedEvents. The channel is closed by Close
+func (cb *CircuitBreaker) Events() <-chan Event {
+	cb.lock()
+	defer cb.unlock()
+
+	if cb.events == nil {
+		cb.events = make(chan Event, cb.eventBufferSize)
+		if cb.closed {
+			close(cb.events)
+		}
+	}
+	return cb.events
+}
+
+// DroppedEvents returns the number of events dropped because the Events
+// channel was full
+func (cb *CircuitBreaker) DroppedEvents() uint64 {
+	cb.lock()
+	defer cb.unlock()
+
+	return cb.droppedEvents
+}
+
+// sendEvent sends an event on the Events channel without blocking. It must
+// be called with the mutex held, which also makes it the only sender
+func (cb *CircuitBreaker) sendEvent(event Event) {
+	if cb.events == nil || cb.closed {
+		return
+	}
+	select {
+	case cb.events <- event:
+	default:
+		cb.droppedEvents++
+	}
+}
@@ -0,0 +1,81 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvents(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	events := cb.Events()
+	assert.Equal(t, events, cb.Events())
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+
+	var got []Event
+	for len(events) > 0 {
+		got = append(got, <-events)
+	}
+	assert.Len(t, got, 4)
+
+	assert.Equal(t, EventStateChange, got[0].Type)
+	assert.Equal(t, StateClosed, got[0].From)
+	assert.Equal(t, StateOpen, got[0].To)
+	assert.Equal(t, Counts{6, 0, 6, 0, 6, 0}, got[0].Counts)
+	assert.Equal(t, uint64(2), got[0].Generation)
+	assert.False(t, got[0].Time.IsZero())
+
+	assert.Equal(t, EventTrip, got[1].Type)
+	assert.Equal(t, got[0].Generation, got[1].Generation)
+
+	assert.Equal(t, EventStateChange, got[2].Type)
+	assert.Equal(t, StateOpen, got[2].From)
+	assert.Equal(t, StateHalfOpen, got[2].To)
+	assert.Equal(t, uint64(3), got[2].Generation)
+
+	assert.Equal(t, EventStateChange, got[3].Type)
+	assert.Equal(t, StateHalfOpen, got[3].From)
+	assert.Equal(t, StateClosed, got[3].To)
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, got[3].Counts)
+}
+
+func TestEventsDropped(t *testing.T) {
+	cb := NewCircuitBreaker(Config{EventBufferSize: 1})
+	events := cb.Events()
+
+	cb.ForceOpen()
+	cb.ForceClose()
+	assert.Len(t, events, 1)
+	assert.Equal(t, uint64(2), cb.DroppedEvents())
+
+	// the slow consumer doesn't block the breaker and sees the oldest event
+	event := <-events
+	assert.Equal(t, EventStateChange, event.Type)
+	assert.Equal(t, StateOpen, event.To)
+}
+
+func TestEventsClose(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	events := cb.Events()
+	cb.ForceOpen()
+	cb.Close()
+	cb.ForceClose() // not sent after Close
+
+	var got []Event
+	for event := range events {
+		got = append(got, event)
+	}
+	assert.Len(t, got, 2)
+
+	// subscribing after Close yields a closed channel
+	cb = NewCircuitBreaker(Config{})
+	cb.Close()
+	_, ok := <-cb.Events()
+	assert.False(t, ok)
+}
@@ -0,0 +1,42 @@
+package circuitbreaker
+
+// EWMAShouldTrip returns a ShouldTrip that trips once the exponentially
+// weighted moving average of the failure rate is above threshold, along with
+// the OnOutcome that feeds it, e.g.
+//
+//	shouldTrip, onOutcome := EWMAShouldTrip(0.1, 0.5)
+//	cb := NewCircuitBreaker(Config{
+//		ShouldTrip: shouldTrip,
+//		OnOutcome:  onOutcome,
+//	})
+//
+// Every closed-state outcome moves the average by alpha towards 1 for a
+// failure or 0 for a success, so the higher alpha is, the more weight recent
+// outcomes have. If alpha isn't in (0, 1], it defaults to 0.1. The average
+// starts at 0 and is cleared by any outcome outside the closed state, so that
+// a recovered CircuitBreaker doesn't trip on its past failures. Unlike the
+// Counts, it isn't cleared by closed-state interval resets. Both functions
+// share state and must be used with a single CircuitBreaker, which calls
+// them with its mutex held
+func EWMAShouldTrip(alpha, threshold float64) (shouldTrip func(counts Counts) bool, onOutcome func(state State, success bool)) {
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.1
+	}
+
+	var average float64
+	shouldTrip = func(Counts) bool {
+		return average > threshold
+	}
+	onOutcome = func(state State, success bool) {
+		if state != StateClosed {
+			average = 0
+			return
+		}
+		failure := 1.0
+		if success {
+			failure = 0
+		}
+		average += alpha * (failure - average)
+	}
+	return shouldTrip, onOutcome
+}
@@ -0,0 +1,56 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEWMAShouldTrip(t *testing.T) {
+	shouldTrip, onOutcome := EWMAShouldTrip(0.5, 0.6)
+	onOutcome(StateClosed, false)
+	assert.False(t, shouldTrip(Counts{})) // 0.5
+	onOutcome(StateClosed, false)
+	assert.True(t, shouldTrip(Counts{})) // 0.75
+	onOutcome(StateClosed, true)
+	assert.False(t, shouldTrip(Counts{})) // 0.375
+
+	// cleared outside the closed state
+	onOutcome(StateClosed, false)
+	onOutcome(StateClosed, false)
+	assert.True(t, shouldTrip(Counts{}))
+	onOutcome(StateHalfOpen, true)
+	assert.False(t, shouldTrip(Counts{}))
+}
+
+func TestEWMAShouldTripWiring(t *testing.T) {
+	shouldTrip, onOutcome := EWMAShouldTrip(0.2, 0.6)
+	cb := NewCircuitBreaker(Config{
+		Interval:   time.Duration(30) * time.Second,
+		ShouldTrip: shouldTrip,
+		OnOutcome:  onOutcome,
+	})
+
+	// alternating outcomes hover below the threshold
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, fail(cb))
+		assert.Nil(t, succeed(cb))
+	}
+	assert.Equal(t, StateClosed, cb.State())
+
+	// interval resets don't clear the average
+	pseudoSleep(cb, time.Duration(31)*time.Second)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.False(t, cb.WouldTripOn(false))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	// a recovered CircuitBreaker starts afresh
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
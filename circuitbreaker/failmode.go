@@ -0,0 +1,54 @@
+package circuitbreaker
+
+import "fmt"
+
+// FailMode is how a CircuitBreaker behaves when its own machinery fails, as
+// opposed to the requests it guards. The only such machinery that can fail
+// is a SharedCounts backed by an external store, which reports errors by
+// implementing FallibleSharedCounts
+type FailMode int
+
+const (
+	// FailOpen falls back to the CircuitBreaker's own counts while the
+	// SharedCounts store is failing, so that requests keep being admitted and
+	// tripped on locally. It's the default, since rejecting traffic because
+	// the bookkeeping is unreachable defeats the point of the CircuitBreaker
+	FailOpen FailMode = iota
+
+	// FailClosed rejects requests made while closed with
+	// ErrSharedCountsUnavailable while the SharedCounts store is failing
+	FailClosed
+)
+
+// String implements stringer interface
+func (m FailMode) String() string {
+	switch m {
+	case FailOpen:
+		return "fail-open"
+	case FailClosed:
+		return "fail-closed"
+	default:
+		return fmt.Sprintf("unknown fail mode: %d", m)
+	}
+}
+
+// FallibleSharedCounts is a SharedCounts backed by a store that can be
+// unreachable, e.g. over the network. Since Record, Snapshot and Reset have no
+// way of returning an error, it reports failures through Err
+type FallibleSharedCounts interface {
+	SharedCounts
+
+	// Err returns the error of the last call to the store, or nil if it
+	// succeeded. While it returns an error the aggregate counts are deemed
+	// unavailable, and the CircuitBreaker acts according to its FailMode
+	Err() error
+}
+
+// sharedErr returns the error the SharedCounts store is failing with, if it
+// reports any
+func (cb *CircuitBreaker) sharedErr() error {
+	if fallible, ok := cb.sharedCounts.(FallibleSharedCounts); ok {
+		return fallible.Err()
+	}
+	return nil
+}
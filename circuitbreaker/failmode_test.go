@@ -0,0 +1,56 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingCounts is a SharedCounts whose store can be made unreachable
+type failingCounts struct {
+	SharedCounts
+	err error
+}
+
+func (c *failingCounts) Err() error {
+	return c.err
+}
+
+func TestFailMode(t *testing.T) {
+	assert.Equal(t, "fail-open", FailOpen.String())
+	assert.Equal(t, "fail-closed", FailClosed.String())
+	assert.Equal(t, "unknown fail mode: 2", FailMode(2).String())
+
+	errUnreachable := errors.New("store unreachable")
+	for _, mode := range []FailMode{FailOpen, FailClosed} {
+		shared := &failingCounts{SharedCounts: NewSharedCounts()}
+		for i := 0; i < 10; i++ {
+			shared.Record(false)
+		}
+		cb := NewCircuitBreaker(Config{Counts: shared, FailMode: mode})
+		shared.err = errUnreachable
+
+		if mode == FailOpen {
+			// the aggregate would trip on any failure, the local counts don't
+			assert.Nil(t, fail(cb), mode)
+			assert.Equal(t, StateClosed, cb.State(), mode)
+			for i := 0; i < 5; i++ {
+				assert.Nil(t, fail(cb), mode)
+			}
+			assert.Equal(t, StateOpen, cb.State(), mode)
+			continue
+		}
+
+		err := succeed(cb)
+		assert.Equal(t, RejectedError{State: StateClosed, Err: ErrSharedCountsUnavailable}, err, mode)
+		assert.True(t, IsRejection(err), mode)
+		assert.False(t, cb.CanProceed(), mode)
+
+		// once the store is back, so are the requests and the aggregate
+		shared.err = nil
+		assert.True(t, cb.CanProceed(), mode)
+		assert.Nil(t, fail(cb), mode)
+		assert.Equal(t, StateOpen, cb.State(), mode)
+	}
+}
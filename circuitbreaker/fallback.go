@@ -0,0 +1,32 @@
+package circuitbreaker
+
+import "context"
+
+// doWithFallback implements Do and DoContext, handing the error of a request
+// to Fallback if it's a rejection or, with FallbackOnError, any error of an
+// admitted request. It also returns the state the request was admitted or
+// rejected in, and whether it was short-circuited, i.e. rejected without
+// running, even if Fallback replaced the rejection. A nil ctx means the
+// request came in through Do
+func (cb *CircuitBreaker) doWithFallback(ctx context.Context, req func(ctx context.Context) (interface{}, error)) (interface{}, State, bool, error) {
+	if ctx != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, cb.State(), false, err
+		}
+	}
+
+	state, generation, err := cb.beforeRequest()
+	if err != nil {
+		if cb.fallback == nil {
+			return nil, state, true, err
+		}
+		result, err := cb.fallback(err)
+		return result, state, true, err
+	}
+
+	result, err := runAdmitted(cb, ctx, generation, req)
+	if err != nil && cb.fallback != nil && cb.fallbackOnError {
+		result, err = cb.fallback(err)
+	}
+	return result, state, false, err
+}
@@ -0,0 +1,85 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallback(t *testing.T) {
+	var fallbackErr error
+	cb := NewCircuitBreaker(Config{
+		Fallback: func(err error) (interface{}, error) {
+			fallbackErr = err
+			return "cached", nil
+		},
+	})
+
+	// request errors are returned as they are
+	errFail := errors.New("fail")
+	_, err := cb.Do(func() (interface{}, error) { return nil, errFail })
+	assert.Equal(t, errFail, err)
+	assert.Nil(t, fallbackErr)
+
+	cb.ForceOpen()
+	result, err := cb.Do(func() (interface{}, error) { return "fresh", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", result)
+	assert.ErrorIs(t, fallbackErr, ErrOpenState)
+
+	// fallback results aren't counted
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	result, err = cb.DoContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		return "fresh", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", result)
+	assert.ErrorIs(t, fallbackErr, ErrTooManyRequests)
+	assert.Equal(t, Counts{1, 0, 0, 0, 0, 0}, cb.Counts())
+	assert.Nil(t, <-ch)
+
+	// nor are cancelled requests handed to it
+	fallbackErr = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cb.DoContext(ctx, func(ctx context.Context) (interface{}, error) { return nil, nil })
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, fallbackErr)
+}
+
+func TestFallbackOnError(t *testing.T) {
+	var fallbackErr error
+	cb := NewCircuitBreaker(Config{
+		Fallback: func(err error) (interface{}, error) {
+			fallbackErr = err
+			return "cached", nil
+		},
+		FallbackOnError: true,
+		RequestTimeout:  time.Duration(10) * time.Millisecond,
+	})
+
+	// the failure is counted, but the fallback result is returned
+	errFail := errors.New("fail")
+	result, err := cb.Do(func() (interface{}, error) { return nil, errFail })
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", result)
+	assert.Equal(t, errFail, fallbackErr)
+	assert.Equal(t, Counts{1, 0, 1, 0, 1, 0}, cb.Counts())
+
+	result, err = cb.Do(func() (interface{}, error) {
+		time.Sleep(time.Duration(50) * time.Millisecond)
+		return "fresh", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", result)
+	assert.Equal(t, ErrRequestTimeout, fallbackErr)
+
+	result, err = cb.Do(func() (interface{}, error) { return "fresh", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "fresh", result)
+}
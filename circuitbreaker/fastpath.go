@@ -0,0 +1,241 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// HotPath selects how a CircuitBreaker synchronizes the requests it admits and
+// the successes it counts while closed, see Config.HotPath
+type HotPath int
+
+const (
+	// HotPathMutex takes the mutex for every request, which is cheapest when
+	// requests seldom contend for it
+	HotPathMutex HotPath = iota
+
+	// HotPathAtomic admits requests and counts their successes with atomic
+	// operations while closed, so that a busy CircuitBreaker's requests
+	// don't queue up on the mutex. Failures, transitions and every other
+	// state still take it. It only applies to a CircuitBreaker without
+	// per-outcome features, which need the mutex for every outcome: a
+	// WindowType but WindowInterval, a RatioBasis of RatioLastNRequests,
+	// SlowCallThreshold, Counts, OnOutcome, OnSuccess, Trace,
+	// CanaryInterval, IgnoreFirstN, a RateLimiter or RateLimit and
+	// MaxConcurrentRequests. While a RecoveryRampDuration ramp is in
+	// progress, requests go through the mutex as well. Otherwise it's
+	// HotPathMutex
+	HotPathAtomic
+
+	// HotPathAdaptive starts out as HotPathMutex and switches to
+	// HotPathAtomic while the mutex is contended, then back once the atomic
+	// hot path isn't, see contentionWindow. It applies to the same
+	// CircuitBreakers as HotPathAtomic
+	HotPathAdaptive
+)
+
+// String implements stringer interface
+func (h HotPath) String() string {
+	switch h {
+	case HotPathMutex:
+		return "mutex"
+	case HotPathAtomic:
+		return "atomic"
+	case HotPathAdaptive:
+		return "adaptive"
+	default:
+		return fmt.Sprintf("unknown hot path: %d", h)
+	}
+}
+
+// stateView is an immutable copy of the fields State and the atomic hot path
+// need, published whenever they change so that they can be read without the
+// mutex
+type stateView struct {
+	state      State
+	expiry     time.Time
+	generation uint64
+
+	// fast is set when requests can be admitted and their successes counted
+	// without the mutex, see HotPathAtomic
+	fast bool
+}
+
+// publish makes the current state, expiry and generation visible to the
+// lock-free readers. It must be called with the mutex held, after every change
+// to any of them. The hot path counters are moved on to the current
+// generation first, so that a request admitted on a view of the earlier one
+// can't count in the new one
+func (cb *CircuitBreaker) publish() {
+	if cb.atomicEligible {
+		cb.fastAdmitted.reset(cb.generation)
+		cb.fastSucceeded.reset(cb.generation)
+	}
+	cb.view.Store(&stateView{
+		state:      cb.state,
+		expiry:     cb.expiry,
+		generation: cb.generation,
+		fast:       cb.atomicEligible && cb.fastEnabled && cb.state == StateClosed && cb.ramp.started.IsZero(),
+	})
+}
+
+// loadState returns the current state without taking the mutex, unless the
+// current generation's expiry is over, in which case the closed-state reset or
+// the transition to half-open has to be made under it
+func (cb *CircuitBreaker) loadState(now time.Time) (State, bool) {
+	v := cb.view.Load()
+	return v.state, !v.expired(now)
+}
+
+// expired reports whether the generation of the view is over at now
+func (v *stateView) expired(now time.Time) bool {
+	return !v.expiry.IsZero() && v.expiry.Before(now)
+}
+
+// atomicEligible reports whether a CircuitBreaker with cfg can use the
+// atomic hot path, see HotPathAtomic
+func atomicEligible(cfg Config) bool {
+	return (cfg.HotPath == HotPathAtomic || cfg.HotPath == HotPathAdaptive) &&
+		cfg.WindowType == WindowInterval &&
+		cfg.RatioBasis != RatioLastNRequests &&
+		cfg.SlowCallThreshold <= 0 &&
+		cfg.Counts == nil &&
+		cfg.OnOutcome == nil &&
+		cfg.OnSuccess == nil &&
+		cfg.Trace == nil &&
+		cfg.CanaryInterval <= 0 &&
+		cfg.IgnoreFirstN == 0 &&
+		cfg.RateLimiter == nil &&
+		cfg.RateLimit <= 0 &&
+		cfg.MaxConcurrentRequests == 0
+}
+
+// admitFast admits a request without the mutex, returning the generation it
+// was admitted in, if the CircuitBreaker is on the atomic hot path and its
+// generation isn't over. Otherwise the request has to go through the mutex
+func (cb *CircuitBreaker) admitFast() (uint64, bool) {
+	v := cb.view.Load()
+	if !v.fast || v.expired(cb.now()) {
+		return 0, false
+	}
+
+	// in flight before checking for Drain, which checks the other way round
+	cb.activeRequests.Add(1)
+	if cb.draining.Load() {
+		cb.releaseActive()
+		return 0, false
+	}
+	ok, retries := cb.fastAdmitted.add(v.generation)
+	if !ok {
+		cb.releaseActive()
+		return 0, false
+	}
+	n := cb.stats.requests.Add(1)
+	if cb.adaptive {
+		cb.sampleFast(n, retries)
+	}
+	return v.generation, true
+}
+
+// recordFast counts the success of a request admitted in the given generation
+// without the mutex, if the CircuitBreaker is still closed on the atomic hot
+// path in that generation. Anything else has to be recorded under the mutex
+func (cb *CircuitBreaker) recordFast(before uint64, o outcome) bool {
+	if !o.success || o.probe || o.dryRun {
+		return false
+	}
+	v := cb.view.Load()
+	if !v.fast || v.generation != before || v.expired(cb.now()) {
+		return false
+	}
+	if ok, _ := cb.fastSucceeded.add(before); !ok {
+		return false
+	}
+	cb.stats.successes.Add(1)
+	return true
+}
+
+// fold adds what the atomic hot path counted since the last fold to the
+// Counts. It's called whenever the mutex is taken, so that whatever runs under
+// it sees Counts consistent with every request counted so far. Successes are
+// taken first: a request whose success is taken was admitted before, so its
+// admission is taken too
+func (cb *CircuitBreaker) fold() {
+	if !cb.atomicEligible {
+		return
+	}
+	succeeded := cb.fastSucceeded.take(cb.generation)
+	cb.counts.CurrRequests += cb.fastAdmitted.take(cb.generation)
+	if succeeded > 0 {
+		cb.counts.TotalSuccesses += succeeded
+		cb.counts.ConsecutiveSuccesses += succeeded
+		cb.counts.ConsecutiveFailures = 0
+	}
+}
+
+// lock takes the mutex and folds in the counts of the atomic hot path. With
+// HotPathAdaptive, it also samples whether the mutex was contended
+func (cb *CircuitBreaker) lock() {
+	switch {
+	case !cb.adaptive:
+		cb.mu.Lock()
+	case cb.mu.TryLock():
+		cb.sampleLock(false)
+	default:
+		cb.mu.Lock()
+		cb.sampleLock(true)
+	}
+	cb.fold()
+}
+
+// genCounter is a count tagged with the generation it's for, packed into a
+// single word so that it's checked and incremented with one compare-and-swap:
+// the low 32 bits of the generation in the high half, the count in the low
+// half. Generations 2^32 apart share a tag, which a request would have to
+// span to be miscounted
+type genCounter struct {
+	v atomic.Uint64
+}
+
+// tag returns the high half of a genCounter for generation
+func tag(generation uint64) uint64 {
+	return uint64(uint32(generation)) << 32
+}
+
+// add increments the count if it's for generation, and reports whether it
+// did along with the number of compare-and-swaps lost to other requests on the
+// way. A count about to overflow isn't incremented, so that the request goes
+// through the mutex until the next fold
+func (c *genCounter) add(generation uint64) (bool, uint32) {
+	t := tag(generation)
+	for retries := uint32(0); ; retries++ {
+		old := c.v.Load()
+		if old&^math.MaxUint32 != t || uint32(old) == math.MaxUint32 {
+			return false, retries
+		}
+		if c.v.CompareAndSwap(old, old+1) {
+			return true, retries
+		}
+	}
+}
+
+// take zeroes the count and returns it if it was for generation
+func (c *genCounter) take(generation uint64) uint32 {
+	t := tag(generation)
+	old := c.v.Swap(t)
+	if old&^math.MaxUint32 != t {
+		return 0
+	}
+	return uint32(old)
+}
+
+// reset moves the counter on to generation, dropping the count of an earlier
+// one. It must be called with the mutex held
+func (c *genCounter) reset(generation uint64) {
+	t := tag(generation)
+	if c.v.Load()&^math.MaxUint32 != t {
+		c.v.Store(t)
+	}
+}
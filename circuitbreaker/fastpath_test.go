@@ -0,0 +1,189 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotPathString(t *testing.T) {
+	assert.Equal(t, "mutex", HotPathMutex.String())
+	assert.Equal(t, "atomic", HotPathAtomic.String())
+	assert.Equal(t, "adaptive", HotPathAdaptive.String())
+	assert.Equal(t, "unknown hot path: 3", HotPath(3).String())
+}
+
+func TestHotPathAtomic(t *testing.T) {
+	mutex := NewCircuitBreaker(Config{})
+	lockFree := NewCircuitBreaker(Config{HotPath: HotPathAtomic})
+	assert.True(t, lockFree.view.Load().fast)
+
+	// both count and trip the same way
+	for _, success := range []bool{true, true, false, true, false, false, true, true, true} {
+		for _, cb := range []*CircuitBreaker{mutex, lockFree} {
+			if success {
+				assert.Nil(t, succeed(cb))
+			} else {
+				assert.Nil(t, fail(cb))
+			}
+		}
+		assert.Equal(t, mutex.Counts(), lockFree.Counts())
+	}
+	assert.Equal(t, Counts{9, 3, 0, 6, 3, 0}, lockFree.Counts())
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(mutex))
+		assert.Nil(t, fail(lockFree))
+	}
+	assert.Equal(t, StateOpen, lockFree.State())
+	assert.Equal(t, mutex.Counts(), lockFree.Counts())
+	assert.Equal(t, mutex.Stats(), lockFree.Stats())
+	assert.False(t, lockFree.view.Load().fast)
+
+	// and recover the same way
+	pseudoSleep(lockFree, 61*time.Second)
+	assert.Nil(t, succeed(lockFree))
+	assert.Equal(t, StateClosed, lockFree.State())
+	assert.True(t, lockFree.view.Load().fast)
+
+	// successes don't wait for the mutex while closed
+	lockFree.mu.Lock()
+	assert.Nil(t, succeed(lockFree))
+	lockFree.mu.Unlock()
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, lockFree.Counts())
+}
+
+func TestHotPathAtomicConcurrent(t *testing.T) {
+	cb := NewCircuitBreaker(Config{HotPath: HotPathAtomic})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				assert.Nil(t, succeed(cb))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, Counts{8000, 8000, 0, 8000, 0, 0}, cb.Counts())
+
+	// a failure still comes after every success counted before it
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, Counts{8001, 0, 1, 8000, 1, 0}, cb.Counts())
+}
+
+func TestHotPathAtomicStale(t *testing.T) {
+	cb := NewCircuitBreaker(Config{HotPath: HotPathAtomic})
+
+	// a success admitted before the trip isn't counted once it's open
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := cb.Do(func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+		done <- err
+	}()
+	<-started
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	close(release)
+	assert.Nil(t, <-done)
+	assert.Equal(t, Counts{}, cb.Counts())
+
+	// nor is one admitted before the closed-state interval rolls over
+	cb = NewCircuitBreaker(Config{HotPath: HotPathAtomic, Interval: time.Minute})
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, succeed(cb))
+	pseudoSleep(cb, 2*time.Minute)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, cb.Counts())
+}
+
+func TestHotPathAtomicFallback(t *testing.T) {
+	// features that need every outcome under the mutex rule it out
+	cb := NewCircuitBreaker(Config{
+		HotPath:   HotPathAtomic,
+		OnSuccess: func(counts Counts) {},
+	})
+	assert.False(t, cb.view.Load().fast)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, cb.Counts())
+
+	// as does a recovery ramp until it's over
+	clock := &steppingClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cb = NewCircuitBreaker(Config{
+		HotPath:              HotPathAtomic,
+		RecoveryRampDuration: 10 * time.Second,
+		Clock:                clock,
+	})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	clock.now = clock.now.Add(61 * time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.False(t, cb.view.Load().fast)
+	clock.now = clock.now.Add(11 * time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.True(t, cb.view.Load().fast)
+}
+
+func TestGenCounter(t *testing.T) {
+	var c genCounter
+	add := func(generation uint64) bool {
+		ok, retries := c.add(generation)
+		assert.Equal(t, uint32(0), retries)
+		return ok
+	}
+	assert.True(t, add(0))
+	assert.True(t, add(0))
+	assert.False(t, add(1))
+	assert.Equal(t, uint32(0), c.take(1))
+	assert.False(t, add(0))
+
+	c.reset(2)
+	assert.True(t, add(2))
+	assert.Equal(t, uint32(1), c.take(2))
+	assert.Equal(t, uint32(0), c.take(2))
+
+	// a full count goes through the mutex until it's taken
+	c.v.Store(tag(2) | (1<<32 - 1))
+	assert.False(t, add(2))
+	assert.Equal(t, uint32(1<<32-1), c.take(2))
+	assert.True(t, add(2))
+}
+
+func BenchmarkHotPath(b *testing.B) {
+	req := func() (interface{}, error) { return nil, nil }
+
+	for _, hotPath := range []HotPath{HotPathMutex, HotPathAtomic, HotPathAdaptive} {
+		b.Run(hotPath.String()+"/serial", func(b *testing.B) {
+			cb := NewCircuitBreaker(Config{HotPath: hotPath})
+			for i := 0; i < b.N; i++ {
+				_, _ = cb.Do(req)
+			}
+		})
+
+		// a goroutine per CPU, then sixteen
+		for _, parallelism := range []int{1, 16} {
+			b.Run(fmt.Sprintf("%s/parallel-%d", hotPath, parallelism), func(b *testing.B) {
+				cb := NewCircuitBreaker(Config{HotPath: hotPath})
+				b.SetParallelism(parallelism)
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						_, _ = cb.Do(req)
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package circuitbreaker
+
+import "fmt"
+
+// ForceMode is the manual override a CircuitBreaker is under, if any
+type ForceMode int
+
+const (
+	// ForceModeNone is automatic behavior, without manual override
+	ForceModeNone ForceMode = iota
+
+	// ForceModeOpen is set by ForceOpen. It lasts until the open-state
+	// timeout moves the CircuitBreaker to half-open
+	ForceModeOpen
+
+	// ForceModeOpenIndefinitely is set by ForceOpenIndefinitely. The
+	// CircuitBreaker stays open until ClearForce or Reset
+	ForceModeOpenIndefinitely
+
+	// ForceModeClosed is set by ForceClose. The CircuitBreaker stays closed
+	// until ClearForce or Reset
+	ForceModeClosed
+)
+
+// String implements stringer interface
+func (m ForceMode) String() string {
+	switch m {
+	case ForceModeNone:
+		return "none"
+	case ForceModeOpen:
+		return "open"
+	case ForceModeOpenIndefinitely:
+		return "open-indefinitely"
+	case ForceModeClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("unknown force mode: %d", m)
+	}
+}
+
+// ForceMode returns the manual override the CircuitBreaker is under
+func (cb *CircuitBreaker) ForceMode() ForceMode {
+	cb.lock()
+	defer cb.unlock()
+
+	cb.currentState(cb.now())
+	return cb.forceMode
+}
+
+// ForceOpen opens the CircuitBreaker, e.g. during a known downstream outage.
+// It moves to half-open after TimeoutOpenState as if it had tripped
+func (cb *CircuitBreaker) ForceOpen() {
+	cb.force(ForceModeOpen, StateOpen)
+}
+
+// ForceOpenIndefinitely opens the CircuitBreaker and keeps it open, without
+// probing, until ClearForce or Reset
+func (cb *CircuitBreaker) ForceOpenIndefinitely() {
+	cb.force(ForceModeOpenIndefinitely, StateOpen)
+}
+
+// ForceClose closes the CircuitBreaker and keeps it closed until ClearForce
+// or Reset: requests are still counted, but ShouldTrip isn't consulted
+func (cb *CircuitBreaker) ForceClose() {
+	cb.force(ForceModeClosed, StateClosed)
+}
+
+// ClearForce returns the CircuitBreaker to automatic behavior from its
+// current state. One that was open indefinitely starts its open-state timeout
+func (cb *CircuitBreaker) ClearForce() {
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	cb.currentState(now)
+	mode := cb.forceMode
+	cb.forceMode = ForceModeNone
+	if mode == ForceModeOpenIndefinitely {
+		cb.toNewGeneration(now)
+	}
+}
+
+func (cb *CircuitBreaker) force(mode ForceMode, state State) {
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	cb.currentState(now)
+	cb.forceMode = mode
+	// a manual open lasts TimeoutOpenState, whatever the last failure was
+	cb.lastSeverity = SeverityNormal
+	if cb.state == state {
+		cb.toNewGeneration(now) // restart the open-state timeout
+	} else {
+		cb.setState(state, now)
+	}
+	cb.ramp.stop()
+}
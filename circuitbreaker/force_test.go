@@ -0,0 +1,92 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForceModeString(t *testing.T) {
+	assert.Equal(t, "none", ForceModeNone.String())
+	assert.Equal(t, "open", ForceModeOpen.String())
+	assert.Equal(t, "open-indefinitely", ForceModeOpenIndefinitely.String())
+	assert.Equal(t, "closed", ForceModeClosed.String())
+	assert.Equal(t, "unknown force mode: 4", ForceMode(4).String())
+}
+
+func TestForceOpen(t *testing.T) {
+	stateChange := stateChangeTracker{}
+	cb := newCustom(&stateChange)
+	cb.ForceOpen()
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, ForceModeOpen, cb.ForceMode())
+	assert.Equal(t, stateChangeTracker{StateClosed, StateOpen}, stateChange)
+	assert.ErrorIs(t, succeed(cb), ErrOpenState)
+
+	// self-heals to half-open after the timeout
+	pseudoSleep(cb, time.Duration(90)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Equal(t, ForceModeNone, cb.ForceMode())
+}
+
+func TestForceOpenIgnoresSeverity(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		FailureSeverity: func(err error) Severity {
+			return SeveritySevere
+		},
+	})
+	assert.Nil(t, fail(cb))
+
+	cb.ForceOpen()
+	timeout := time.Until(cb.expiry)
+	assert.True(t, timeout > time.Duration(59)*time.Second)
+	assert.True(t, timeout <= time.Duration(60)*time.Second)
+}
+
+func TestForceOpenIndefinitely(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	cb.ForceOpenIndefinitely()
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, ForceModeOpenIndefinitely, cb.ForceMode())
+	assert.True(t, cb.expiry.IsZero())
+
+	pseudoSleep(cb, time.Duration(600)*time.Second)
+	assert.Equal(t, StateOpen, cb.State())
+
+	// back to automatic behavior, starting the open-state timeout
+	cb.ClearForce()
+	assert.Equal(t, ForceModeNone, cb.ForceMode())
+	assert.Equal(t, StateOpen, cb.State())
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	// Reset clears it as well
+	cb.ForceOpenIndefinitely()
+	cb.Reset()
+	assert.Equal(t, ForceModeNone, cb.ForceMode())
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestForceClose(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+
+	cb.ForceClose()
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, ForceModeClosed, cb.ForceMode())
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{10, 0, 10, 0, 10, 0}, cb.Counts())
+
+	// trips on the next failure once cleared
+	cb.ClearForce()
+	assert.Equal(t, ForceModeNone, cb.ForceMode())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+}
@@ -0,0 +1,67 @@
+package circuitbreaker
+
+import "context"
+
+// GenericCircuitBreaker is a CircuitBreaker whose requests return a T, which
+// spares callers the type assertions and the boxing that come with Do's
+// interface{} result. All other methods are those of the embedded
+// CircuitBreaker
+type GenericCircuitBreaker[T any] struct {
+	*CircuitBreaker
+}
+
+// NewCircuitBreakerGeneric returns a new instance of GenericCircuitBreaker
+// with the given configuration
+func NewCircuitBreakerGeneric[T any](cfg Config) *GenericCircuitBreaker[T] {
+	return &GenericCircuitBreaker[T]{
+		CircuitBreaker: NewCircuitBreaker(cfg),
+	}
+}
+
+// Do runs the given request if the CircuitBreaker accepts it, just like
+// CircuitBreaker.Do. When the request is rejected, the zero value of T is
+// returned along with the error
+func (gcb *GenericCircuitBreaker[T]) Do(req func() (T, error)) (T, error) {
+	return doRequest(gcb.CircuitBreaker, nil, func(context.Context) (T, error) {
+		return req()
+	})
+}
+
+// DoContext is like Do but passes ctx through to the request, just like
+// CircuitBreaker.DoContext
+func (gcb *GenericCircuitBreaker[T]) DoContext(ctx context.Context, req func(ctx context.Context) (T, error)) (T, error) {
+	return doRequest(gcb.CircuitBreaker, ctx, req)
+}
+
+// WrapContext returns fn guarded by cb, e.g. to wrap an RPC client method once
+// and reuse it. The returned function follows CircuitBreaker.DoContext's rules:
+// a ctx that's already done is returned without taking up a request, and
+// whether the request's error counts as a failure is decided by
+// IsSuccessfulContext, if set. When the request is rejected, the zero value of
+// T is returned along with the error
+func WrapContext[T any](cb *CircuitBreaker, fn func(ctx context.Context) (T, error)) func(ctx context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		return doRequest(cb, ctx, fn)
+	}
+}
+
+// DoOrDefault runs req with gcb.Do and returns its result, or def if the
+// request was rejected or failed, e.g. for read paths where a stale value will
+// do. The error is dropped only on the way out: the request's outcome is
+// counted as usual, so the CircuitBreaker still learns from it
+func DoOrDefault[T any](gcb *GenericCircuitBreaker[T], req func() (T, error), def T) T {
+	return DoOrDefaultLog(gcb, req, def, nil)
+}
+
+// DoOrDefaultLog is like DoOrDefault but calls log, if it's not nil, with
+// the error that's dropped whenever def is returned
+func DoOrDefaultLog[T any](gcb *GenericCircuitBreaker[T], req func() (T, error), def T, log func(err error)) T {
+	result, err := gcb.Do(req)
+	if err != nil {
+		if log != nil {
+			log(err)
+		}
+		return def
+	}
+	return result
+}
@@ -0,0 +1,122 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type account struct {
+	ID      int
+	Balance int
+}
+
+func TestGenericCircuitBreaker(t *testing.T) {
+	cb := NewCircuitBreakerGeneric[account](Config{})
+	want := account{ID: 1, Balance: 100}
+
+	got, err := cb.Do(func() (account, error) { return want, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+
+	errFail := errors.New("fail")
+	for i := 0; i < 6; i++ {
+		got, err = cb.Do(func() (account, error) { return want, errFail })
+		assert.Equal(t, errFail, err)
+		assert.Equal(t, want, got)
+	}
+	assert.Equal(t, StateOpen, cb.State())
+
+	// zero value on rejection
+	got, err = cb.Do(func() (account, error) { return want, nil })
+	assert.ErrorIs(t, err, ErrOpenState)
+	assert.Equal(t, account{}, got)
+
+	pseudoSleep(cb.CircuitBreaker, time.Duration(60)*time.Second)
+	assert.Panics(t, func() {
+		_, _ = cb.Do(func() (account, error) {
+			panic("oops")
+		})
+	})
+	assert.Equal(t, StateOpen, cb.State())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got, err = cb.DoContext(ctx, func(context.Context) (account, error) { return want, nil })
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, account{}, got)
+
+	pointers := NewCircuitBreakerGeneric[*account](Config{})
+	p, err := pointers.Do(func() (*account, error) { return &want, nil })
+	assert.Nil(t, err)
+	assert.Same(t, &want, p)
+}
+
+func TestWrapContext(t *testing.T) {
+	type ctxKey struct{}
+	cb := NewCircuitBreaker(Config{
+		IsSuccessfulContext: func(ctx context.Context, err error) bool {
+			return err == nil || ctx.Value(ctxKey{}) != nil
+		},
+	})
+	want := account{ID: 1, Balance: 100}
+	errFail := errors.New("fail")
+	getAccount := WrapContext(cb, func(ctx context.Context) (account, error) {
+		if ctx.Value(ctxKey{}) == "fail" {
+			return want, errFail
+		}
+		return want, nil
+	})
+
+	got, err := getAccount(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+
+	// classified with the request's ctx
+	got, err = getAccount(context.WithValue(context.Background(), ctxKey{}, "fail"))
+	assert.Equal(t, errFail, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, Counts{2, 2, 0, 2, 0, 0}, cb.Counts())
+
+	// a done ctx doesn't take up a request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got, err = getAccount(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, account{}, got)
+	assert.Equal(t, Counts{2, 2, 0, 2, 0, 0}, cb.Counts())
+
+	// zero value on rejection
+	cb.ForceOpen()
+	got, err = getAccount(context.Background())
+	assert.ErrorIs(t, err, ErrOpenState)
+	assert.Equal(t, account{}, got)
+}
+
+func TestDoOrDefault(t *testing.T) {
+	cb := NewCircuitBreakerGeneric[int](Config{})
+	errFail := errors.New("fail")
+	var logged []error
+	log := func(err error) {
+		logged = append(logged, err)
+	}
+
+	assert.Equal(t, 1, DoOrDefault(cb, func() (int, error) { return 1, nil }, -1))
+	assert.Equal(t, 2, DoOrDefaultLog(cb, func() (int, error) { return 2, nil }, -1, log))
+	assert.Empty(t, logged)
+
+	// failures are still counted
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, -1, DoOrDefault(cb, func() (int, error) { return 3, errFail }, -1))
+	}
+	assert.Equal(t, -1, DoOrDefaultLog(cb, func() (int, error) { return 3, errFail }, -1, log))
+	assert.Equal(t, StateOpen, cb.State())
+
+	assert.Equal(t, -1, DoOrDefaultLog(cb, func() (int, error) { return 4, nil }, -1, log))
+	assert.Len(t, logged, 2)
+	assert.Equal(t, errFail, logged[0])
+	assert.ErrorIs(t, logged[1], ErrOpenState)
+}
@@ -0,0 +1,21 @@
+package circuitbreaker
+
+// Healthy reports whether the CircuitBreaker's dependency looks healthy, e.g.
+// for a load balancer to deprioritize an instance. It's false while open or
+// half-open. While closed, it's false once FailureRatio is above
+// UnhealthyRatio, provided at least MinimumRequests requests have completed
+// over the RatioBasis:
+//
+//	state == closed && (completed < MinimumRequests || FailureRatio <= UnhealthyRatio)
+func (cb *CircuitBreaker) Healthy() bool {
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	state, _ := cb.currentState(now)
+	if state != StateClosed {
+		return false
+	}
+	ratio, completed := cb.failureRatio(cb.tripCounts(now))
+	return completed < cb.minimumRequests || ratio <= cb.unhealthyRatio
+}
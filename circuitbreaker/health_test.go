@@ -0,0 +1,38 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthy(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	assert.Equal(t, 0.5, cb.unhealthyRatio)
+	assert.True(t, cb.Healthy())
+
+	// at the ratio is still healthy
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.True(t, cb.Healthy())
+	assert.Nil(t, fail(cb))
+	assert.False(t, cb.Healthy())
+	assert.Equal(t, StateClosed, cb.State())
+
+	cb.ForceOpen()
+	assert.False(t, cb.Healthy())
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.False(t, cb.Healthy())
+
+	// configurable, and gated on MinimumRequests
+	cb = NewCircuitBreaker(Config{UnhealthyRatio: 0.1, MinimumRequests: 5})
+	assert.Nil(t, fail(cb))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	assert.True(t, cb.Healthy())
+	assert.Nil(t, succeed(cb))
+	assert.False(t, cb.Healthy())
+}
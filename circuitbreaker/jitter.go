@@ -0,0 +1,45 @@
+package circuitbreaker
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// jitter offsets the open-state timeout by a random duration within
+// TimeoutJitter, without letting it go negative
+func (cb *CircuitBreaker) jitter(timeout time.Duration) time.Duration {
+	if cb.timeoutJitter <= 0 {
+		return timeout
+	}
+
+	offset := time.Duration(cb.jitterRand.Int63n(2*int64(cb.timeoutJitter)+1)) - cb.timeoutJitter
+	if timeout += offset; timeout < 0 {
+		return 0
+	}
+	return timeout
+}
+
+// jitterSourceMu serializes the draws from every JitterSource. A rand.Source
+// isn't safe for concurrent use and one may be shared by several
+// CircuitBreakers, e.g. through a Config passed to Registry.GetOrCreate, so
+// the lock can't be per breaker. Draws only happen when a breaker opens, so
+// a single lock is cheap enough
+var jitterSourceMu sync.Mutex
+
+// lockedSource is a rand.Source whose draws hold jitterSourceMu
+type lockedSource struct {
+	src rand.Source
+}
+
+func (s lockedSource) Int63() int64 {
+	jitterSourceMu.Lock()
+	defer jitterSourceMu.Unlock()
+	return s.src.Int63()
+}
+
+func (s lockedSource) Seed(seed int64) {
+	jitterSourceMu.Lock()
+	defer jitterSourceMu.Unlock()
+	s.src.Seed(seed)
+}
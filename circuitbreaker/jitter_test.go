@@ -0,0 +1,78 @@
+package circuitbreaker
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutJitter(t *testing.T) {
+	newJittered := func(seed int64) *CircuitBreaker {
+		return NewCircuitBreaker(Config{
+			TimeoutJitter: time.Duration(10) * time.Second,
+			JitterSource:  rand.NewSource(seed),
+		})
+	}
+
+	// the same seed yields the same timeouts
+	a, b := newJittered(42), newJittered(42)
+	for i := 0; i < 20; i++ {
+		timeout := a.jitter(a.timeoutOpenState)
+		assert.Equal(t, timeout, b.jitter(b.timeoutOpenState))
+		assert.True(t, timeout >= time.Duration(50)*time.Second)
+		assert.True(t, timeout <= time.Duration(70)*time.Second)
+	}
+
+	// applied to the open-state expiry
+	a.ForceOpen()
+	timeout := time.Until(a.expiry)
+	assert.True(t, timeout > time.Duration(49)*time.Second)
+	assert.True(t, timeout <= time.Duration(70)*time.Second)
+
+	// the timeouts are spread out
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		seen[a.jitter(time.Duration(60)*time.Second)] = true
+	}
+	assert.True(t, len(seen) > 1)
+}
+
+func TestTimeoutJitterNeverNegative(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		TimeoutOpenState: time.Millisecond,
+		TimeoutJitter:    time.Duration(10) * time.Second,
+		JitterSource:     rand.NewSource(1),
+	})
+	for i := 0; i < 100; i++ {
+		assert.True(t, cb.jitter(cb.timeoutOpenState) >= 0)
+	}
+
+	// no jitter by default
+	cb = NewCircuitBreaker(Config{})
+	assert.Equal(t, time.Duration(60)*time.Second, cb.jitter(cb.timeoutOpenState))
+}
+
+func TestTimeoutJitterSharedSource(t *testing.T) {
+	cfg := Config{
+		TimeoutJitter: time.Duration(10) * time.Second,
+		JitterSource:  rand.NewSource(1),
+	}
+	breakers := []*CircuitBreaker{NewCircuitBreaker(cfg), NewCircuitBreaker(cfg)}
+
+	// run with -race: the breakers draw from the same source concurrently
+	var wg sync.WaitGroup
+	for _, cb := range breakers {
+		wg.Add(1)
+		go func(cb *CircuitBreaker) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				cb.ForceOpen()
+				cb.Reset()
+			}
+		}(cb)
+	}
+	wg.Wait()
+}
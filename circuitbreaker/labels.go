@@ -0,0 +1,47 @@
+package circuitbreaker
+
+import "context"
+
+// failureLabelBufferSize is how many labels of failed requests a
+// CircuitBreaker keeps for its trip events
+const failureLabelBufferSize = 16
+
+type labelKey struct{}
+
+// WithLabel returns a copy of ctx carrying a label that describes the request,
+// e.g. the tenant or key it's for. When requests made with DoContext under a
+// labeled ctx fail, their labels are attached to the EventTrip or
+// EventSustainedOpen that follows, which helps tell whether the failures had
+// something in common. Labels end up wherever events are shipped, so they
+// mustn't carry personal data or secrets
+func WithLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, labelKey{}, label)
+}
+
+// labelFrom returns the label set on ctx by WithLabel, if any
+func labelFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	label, _ := ctx.Value(labelKey{}).(string)
+	return label
+}
+
+// DoWithLabel is like Do but labels the request, see WithLabel
+func (cb *CircuitBreaker) DoWithLabel(label string, req func() (interface{}, error)) (interface{}, error) {
+	return cb.DoContext(WithLabel(context.Background(), label), func(context.Context) (interface{}, error) {
+		return req()
+	})
+}
+
+// recordFailureLabel keeps the label of a failed request, evicting the oldest
+// one once the buffer is full. It must be called with the mutex held
+func (cb *CircuitBreaker) recordFailureLabel(label string) {
+	if label == "" {
+		return
+	}
+	if len(cb.failureLabels) == failureLabelBufferSize {
+		cb.failureLabels = append(cb.failureLabels[:0], cb.failureLabels[1:]...)
+	}
+	cb.failureLabels = append(cb.failureLabels, label)
+}
@@ -0,0 +1,82 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureLabels(t *testing.T) {
+	var events []Event
+	cb := NewCircuitBreaker(Config{
+		Notifiers: []Notifier{FuncNotifier(func(event Event) {
+			if event.Type != EventStateChange {
+				events = append(events, event)
+			}
+		})},
+	})
+
+	errFail := errors.New("fail")
+	failWithLabel := func(label string) {
+		_, err := cb.DoWithLabel(label, func() (interface{}, error) { return nil, errFail })
+		assert.Equal(t, errFail, err)
+	}
+
+	// successes and unlabeled failures aren't kept
+	_, err := cb.DoWithLabel("ok", func() (interface{}, error) { return nil, nil })
+	assert.Nil(t, err)
+	assert.Nil(t, fail(cb))
+	for i := 0; i < 5; i++ {
+		failWithLabel(fmt.Sprintf("tenant-%d", i%2))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Len(t, events, 1)
+	assert.Equal(t, EventTrip, events[0].Type)
+	assert.Equal(t, []string{"tenant-0", "tenant-1", "tenant-0", "tenant-1", "tenant-0"}, events[0].FailureLabels)
+
+	// the labels of the half-open probe that failed, through DoContext
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	_, err = cb.DoContext(WithLabel(context.Background(), "probe"), func(context.Context) (interface{}, error) {
+		return nil, errFail
+	})
+	assert.Equal(t, errFail, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, EventSustainedOpen, events[1].Type)
+	assert.Equal(t, []string{"probe"}, events[1].FailureLabels)
+
+	cb.Reset()
+	assert.Nil(t, events[2].FailureLabels)
+}
+
+func TestFailureLabelBuffer(t *testing.T) {
+	var trip Event
+	cb := NewCircuitBreaker(Config{
+		ShouldTrip: func(counts Counts) bool {
+			return counts.ConsecutiveFailures > 20
+		},
+		Notifiers: []Notifier{FuncNotifier(func(event Event) {
+			if event.Type == EventTrip {
+				trip = event
+			}
+		})},
+	})
+
+	for i := 0; i < 21; i++ {
+		_, _ = cb.DoWithLabel(fmt.Sprint(i), func() (interface{}, error) { return nil, errors.New("fail") })
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Len(t, trip.FailureLabels, failureLabelBufferSize)
+	assert.Equal(t, "5", trip.FailureLabels[0])
+	assert.Equal(t, "20", trip.FailureLabels[failureLabelBufferSize-1])
+
+	// cleared with the generation
+	cb = NewCircuitBreaker(Config{Interval: time.Duration(30) * time.Second})
+	_, _ = cb.DoWithLabel("stale", func() (interface{}, error) { return nil, errors.New("fail") })
+	pseudoSleep(cb, time.Duration(31)*time.Second)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, cb.failureLabels)
+}
@@ -0,0 +1,88 @@
+package circuitbreaker
+
+import "context"
+
+// Breaker is the method set of a CircuitBreaker that code running requests
+// through it needs, so that it can depend on Breaker and be handed a
+// NoopBreaker where breaking is undesirable, e.g. in tests or for trusted
+// internal calls, without branching on whether resilience is enabled
+type Breaker interface {
+	Do(req func() (interface{}, error)) (interface{}, error)
+	DoContext(ctx context.Context, req func(ctx context.Context) (interface{}, error)) (interface{}, error)
+	State() State
+	Counts() Counts
+}
+
+// TwoStepBreaker is to TwoStepCircuitBreaker what Breaker is to
+// CircuitBreaker
+type TwoStepBreaker interface {
+	Allow() (done func(success bool), err error)
+	AllowContext(ctx context.Context) (done func(success bool), err error)
+	State() State
+	Counts() Counts
+}
+
+var (
+	_ Breaker        = (*CircuitBreaker)(nil)
+	_ Breaker        = NoopBreaker{}
+	_ TwoStepBreaker = (*TwoStepCircuitBreaker)(nil)
+	_ TwoStepBreaker = NoopTwoStepBreaker{}
+)
+
+// NoopBreaker is a Breaker that runs every request and never trips. It's
+// always closed and doesn't count anything. Its zero value is ready to use
+type NoopBreaker struct{}
+
+// Do runs req and returns what it returns. A panic in req isn't recovered
+func (NoopBreaker) Do(req func() (interface{}, error)) (interface{}, error) {
+	return req()
+}
+
+// DoContext runs req with ctx and returns what it returns. Like
+// CircuitBreaker.DoContext, it returns ctx.Err() without running req if ctx is
+// already done
+func (NoopBreaker) DoContext(ctx context.Context, req func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return req(ctx)
+}
+
+// State always returns StateClosed
+func (NoopBreaker) State() State {
+	return StateClosed
+}
+
+// Counts always returns zero Counts
+func (NoopBreaker) Counts() Counts {
+	return Counts{}
+}
+
+// NoopTwoStepBreaker is a TwoStepBreaker that allows every request and never
+// trips. It's always closed and doesn't count anything. Its zero value is
+// ready to use
+type NoopTwoStepBreaker struct{}
+
+// Allow always allows the request. Calling done is optional and does nothing
+func (NoopTwoStepBreaker) Allow() (done func(success bool), err error) {
+	return func(bool) {}, nil
+}
+
+// AllowContext is like Allow but, like TwoStepCircuitBreaker.AllowContext,
+// returns ctx.Err() if ctx is already done
+func (b NoopTwoStepBreaker) AllowContext(ctx context.Context) (done func(success bool), err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return b.Allow()
+}
+
+// State always returns StateClosed
+func (NoopTwoStepBreaker) State() State {
+	return StateClosed
+}
+
+// Counts always returns zero Counts
+func (NoopTwoStepBreaker) Counts() Counts {
+	return Counts{}
+}
@@ -0,0 +1,56 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoopBreaker(t *testing.T) {
+	errFail := errors.New("fail")
+	var b Breaker = NoopBreaker{}
+	for i := 0; i < 10; i++ {
+		_, err := b.Do(func() (interface{}, error) { return nil, errFail })
+		assert.Equal(t, errFail, err)
+	}
+	result, err := b.Do(func() (interface{}, error) { return 1, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result)
+	assert.Equal(t, StateClosed, b.State())
+	assert.Equal(t, Counts{}, b.Counts())
+
+	result, err = b.DoContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		return 2, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = b.DoContext(ctx, func(ctx context.Context) (interface{}, error) {
+		panic("not called")
+	})
+	assert.Equal(t, context.Canceled, err)
+
+	assert.PanicsWithValue(t, "oops", func() {
+		_, _ = b.Do(func() (interface{}, error) { panic("oops") })
+	})
+}
+
+func TestNoopTwoStepBreaker(t *testing.T) {
+	var b TwoStepBreaker = NoopTwoStepBreaker{}
+	for i := 0; i < 10; i++ {
+		done, err := b.Allow()
+		assert.Nil(t, err)
+		done(false)
+	}
+	assert.Equal(t, StateClosed, b.State())
+	assert.Equal(t, Counts{}, b.Counts())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := b.AllowContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
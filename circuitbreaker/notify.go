@@ -0,0 +1,170 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType identifies what happened to a CircuitBreaker
+type EventType int
+
+const (
+	// EventTrip is sent when the CircuitBreaker goes from closed to open
+	EventTrip EventType = iota
+
+	// EventReset is sent when Reset is called
+	EventReset
+
+	// EventSustainedOpen is sent when a half-open probe fails and the
+	// CircuitBreaker goes back to open
+	EventSustainedOpen
+
+	// EventStateChange is sent on every state transition, ahead of the more
+	// specific event above if there's one
+	EventStateChange
+)
+
+// String implements the stringer interface
+func (t EventType) String() string {
+	switch t {
+	case EventTrip:
+		return "trip"
+	case EventReset:
+		return "reset"
+	case EventSustainedOpen:
+		return "sustained-open"
+	case EventStateChange:
+		return "state-change"
+	default:
+		return fmt.Sprintf("unknown event type: %d", t)
+	}
+}
+
+// Event describes something that happened to a CircuitBreaker
+type Event struct {
+	Type EventType
+
+	// From is the state before the event
+	From State
+
+	// To is the state after the event
+	To State
+
+	// Time is when the event occurred
+	Time time.Time
+
+	// Counts holds the counts right before the event cleared them
+	Counts Counts
+
+	// Generation is the generation the event started
+	Generation uint64
+
+	// FailureLabels holds, oldest first, the labels of up to the last 16
+	// failed requests of the generation that ended, for EventTrip and
+	// EventSustainedOpen. It's empty for the other events and for failed
+	// requests that weren't labeled, see WithLabel
+	FailureLabels []string
+
+	// TraceID and SpanID identify the trace of the failed request that
+	// caused an EventTrip or EventSustainedOpen, see TraceIDs, e.g. to link
+	// the event to it. They're empty for the other events, without TraceIDs
+	// and when the request wasn't made with a context, e.g. through Do
+	TraceID string
+	SpanID  string
+}
+
+// Notifier is an extension point for alerting and metrics systems that want
+// to be told about a CircuitBreaker's events
+type Notifier interface {
+	Notify(event Event)
+}
+
+// FuncNotifier adapts an ordinary function to a Notifier
+type FuncNotifier func(event Event)
+
+// Notify calls f(event)
+func (f FuncNotifier) Notify(event Event) {
+	f(event)
+}
+
+// ChanNotifier sends events on a channel. Events are dropped rather than
+// blocking if the channel isn't ready to receive
+type ChanNotifier chan<- Event
+
+// Notify sends the event on the channel if it's ready to receive
+func (ch ChanNotifier) Notify(event Event) {
+	select {
+	case ch <- event:
+	default:
+	}
+}
+
+// AddNotifier adds a Notifier to those set in Config.Notifiers, e.g. for an
+// integration that's set up once the CircuitBreaker exists. It's sent the
+// events that occur from then on
+func (cb *CircuitBreaker) AddNotifier(n Notifier) {
+	cb.lock()
+	defer cb.unlock()
+
+	// copy on write, since queued deliveries range over the current slice
+	cb.notifiers = append(cb.notifiers[:len(cb.notifiers):len(cb.notifiers)], n)
+}
+
+// queueEvent sends an event on the Events channel and records it to be
+// delivered to the Notifiers once the mutex is released. It must be called
+// with the mutex held
+func (cb *CircuitBreaker) queueEvent(typ EventType, from State, counts Counts, labels []string, trace traceRef, now time.Time) {
+	event := Event{
+		Type:          typ,
+		From:          from,
+		To:            cb.state,
+		Time:          now,
+		Counts:        counts,
+		Generation:    cb.generation,
+		FailureLabels: labels,
+		TraceID:       trace.traceID,
+		SpanID:        trace.spanID,
+	}
+	cb.sendEvent(event)
+	cb.recordEvent(event)
+	if len(cb.notifiers) == 0 {
+		return
+	}
+	notifiers := cb.notifiers
+	cb.pending = append(cb.pending, func() {
+		for _, n := range notifiers {
+			notify(n, event)
+		}
+	})
+}
+
+// eventHistorySize is how many of its latest events a CircuitBreaker keeps
+// for RecentEvents
+const eventHistorySize = 16
+
+// RecentEvents returns, oldest first, up to the last 16 events of the
+// CircuitBreaker, e.g. for a debug page
+func (cb *CircuitBreaker) RecentEvents() []Event {
+	cb.lock()
+	defer cb.unlock()
+
+	cb.currentState(cb.now())
+	return append([]Event(nil), cb.history...)
+}
+
+// recordEvent keeps an event for RecentEvents, evicting the oldest one once
+// the history is full. It must be called with the mutex held
+func (cb *CircuitBreaker) recordEvent(event Event) {
+	if len(cb.history) == eventHistorySize {
+		cb.history = append(cb.history[:0], cb.history[1:]...)
+	}
+	cb.history = append(cb.history, event)
+}
+
+// notify calls the Notifier, containing any panic
+func notify(n Notifier, event Event) {
+	defer func() {
+		_ = recover()
+	}()
+	n.Notify(event)
+}
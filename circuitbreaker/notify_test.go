@@ -0,0 +1,152 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventTypeConstants(t *testing.T) {
+	assert.Equal(t, "trip", EventTrip.String())
+	assert.Equal(t, "reset", EventReset.String())
+	assert.Equal(t, "sustained-open", EventSustainedOpen.String())
+	assert.Equal(t, "unknown event type: 100", EventType(100).String())
+}
+
+func TestNotifiers(t *testing.T) {
+	var got, changes []Event
+	ch := make(chan Event, 10)
+	var cb *CircuitBreaker
+	cb = NewCircuitBreaker(Config{
+		Notifiers: []Notifier{
+			FuncNotifier(func(event Event) {
+				panic("oops")
+			}),
+			FuncNotifier(func(event Event) {
+				// called outside the mutex
+				assert.Equal(t, event.To, cb.State())
+				if event.Type == EventStateChange {
+					changes = append(changes, event)
+					return
+				}
+				got = append(got, event)
+			}),
+			ChanNotifier(ch),
+		},
+	})
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, fail(cb))
+	cb.Reset()
+
+	assert.Len(t, got, 3)
+	assert.Equal(t, EventTrip, got[0].Type)
+	assert.Equal(t, StateClosed, got[0].From)
+	assert.Equal(t, StateOpen, got[0].To)
+	assert.Equal(t, Counts{6, 0, 6, 0, 6, 0}, got[0].Counts)
+	assert.Equal(t, uint64(2), got[0].Generation)
+
+	assert.Equal(t, EventSustainedOpen, got[1].Type)
+	assert.Equal(t, StateHalfOpen, got[1].From)
+	assert.Equal(t, StateOpen, got[1].To)
+	assert.Equal(t, Counts{1, 0, 1, 0, 1, 0}, got[1].Counts)
+
+	assert.Equal(t, EventReset, got[2].Type)
+	assert.Equal(t, StateOpen, got[2].From)
+	assert.Equal(t, StateClosed, got[2].To)
+
+	// every transition is sent as well, ahead of the specific event
+	assert.Len(t, changes, 4)
+	assert.Equal(t, []State{StateOpen, StateHalfOpen, StateOpen, StateClosed},
+		[]State{changes[0].To, changes[1].To, changes[2].To, changes[3].To})
+
+	assert.Len(t, ch, 7)
+	for _, event := range []Event{changes[0], got[0], changes[1], changes[2], got[1], changes[3], got[2]} {
+		assert.Equal(t, event, <-ch)
+	}
+}
+
+func TestAddNotifier(t *testing.T) {
+	var got []EventType
+	cb := NewCircuitBreaker(Config{})
+	cb.AddNotifier(FuncNotifier(func(event Event) {
+		got = append(got, event.Type)
+	}))
+
+	cb.ForceOpen()
+	cb.Reset()
+	assert.Equal(t, []EventType{EventStateChange, EventTrip, EventStateChange, EventReset}, got)
+}
+
+func TestRecentEvents(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	assert.Empty(t, cb.RecentEvents())
+
+	_, _ = cb.DoContext(WithLabel(context.Background(), "tenant-1"), func(context.Context) (interface{}, error) {
+		return nil, errors.New("fail")
+	})
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	events := cb.RecentEvents()
+	assert.Len(t, events, 2)
+	assert.Equal(t, EventTrip, events[1].Type)
+	assert.Equal(t, []string{"tenant-1"}, events[1].FailureLabels)
+
+	// the transition to half-open is made when they're read
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	events = cb.RecentEvents()
+	assert.Len(t, events, 3)
+	assert.Equal(t, StateHalfOpen, events[2].To)
+
+	// only the latest are kept
+	for i := 0; i < 20; i++ {
+		cb.Reset()
+	}
+	events = cb.RecentEvents()
+	assert.Len(t, events, 16)
+	for _, event := range events {
+		assert.Equal(t, EventReset, event.Type)
+	}
+}
+
+type traceKey struct{}
+
+func TestTraceIDs(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		TraceIDs: func(ctx context.Context) (string, string) {
+			ids, _ := ctx.Value(traceKey{}).([2]string)
+			return ids[0], ids[1]
+		},
+	})
+	traced := context.WithValue(context.Background(), traceKey{}, [2]string{"trace-1", "span-1"})
+	failWith := func(ctx context.Context) {
+		_, _ = cb.DoContext(ctx, func(context.Context) (interface{}, error) {
+			return nil, errors.New("fail")
+		})
+	}
+
+	// the trip carries the trace of the failure that caused it
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	failWith(traced)
+	events := cb.RecentEvents()
+	assert.Equal(t, EventTrip, events[1].Type)
+	assert.Equal(t, "trace-1", events[1].TraceID)
+	assert.Equal(t, "span-1", events[1].SpanID)
+
+	// and none without a trace
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	failWith(context.Background())
+	events = cb.RecentEvents()
+	assert.Equal(t, EventSustainedOpen, events[len(events)-1].Type)
+	assert.Equal(t, "", events[len(events)-1].TraceID)
+	assert.Equal(t, "", events[len(events)-1].SpanID)
+}
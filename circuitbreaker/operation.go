@@ -0,0 +1,66 @@
+package circuitbreaker
+
+import "time"
+
+// Operation is a single logical operation that may make several attempts
+// internally, e.g. because it retries. The CircuitBreaker admits it once and
+// sees a single outcome: a success if any attempt succeeded, a failure if all
+// of them failed. This keeps the counts in line with what the caller
+// experienced rather than overstating failures that were retried away. An
+// Operation is not safe for concurrent use
+type Operation struct {
+	cb         *CircuitBreaker
+	generation uint64
+	start      time.Time
+	succeeded  bool
+	failures   uint32
+	ignored    uint32
+	ended      bool
+}
+
+// BeginOperation starts a logical operation if the CircuitBreaker admits it.
+// The operation takes up a single request, or a single half-open slot, no
+// matter how many attempts it makes. End must be called once the operation
+// is over
+func (cb *CircuitBreaker) BeginOperation() (*Operation, error) {
+	_, generation, err := cb.beforeRequest()
+	if err != nil {
+		return nil, err
+	}
+	return &Operation{
+		cb:         cb,
+		generation: generation,
+		start:      cb.now(),
+	}, nil
+}
+
+// Record notes the outcome of an attempt, classified with the
+// CircuitBreaker's Classify or IsSuccessful
+func (op *Operation) Record(err error) {
+	switch op.cb.classifyError(err) {
+	case OutcomeSuccess:
+		op.succeeded = true
+	case OutcomeFailure:
+		op.failures++
+	default:
+		op.ignored++
+	}
+}
+
+// End reports the outcome of the operation to the CircuitBreaker. An operation
+// with no recorded attempts counts as a success, one whose attempts were all
+// ignored is ignored. Calls after the first are no-ops
+func (op *Operation) End() {
+	if op.ended {
+		return
+	}
+	op.ended = true
+
+	result := OutcomeSuccess
+	if !op.succeeded && op.failures > 0 {
+		result = OutcomeFailure
+	} else if !op.succeeded && op.ignored > 0 {
+		result = OutcomeIgnore
+	}
+	op.cb.afterRequest(op.generation, newOutcome(result, op.cb.since(op.start)))
+}
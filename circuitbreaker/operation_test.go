@@ -0,0 +1,48 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperation(t *testing.T) {
+	cb := NewCircuitBreaker(Config{ConsecutiveFailureThreshold: 1})
+	errAttempt := errors.New("attempt failed")
+
+	// fails twice then succeeds
+	op, err := cb.BeginOperation()
+	assert.Nil(t, err)
+	op.Record(errAttempt)
+	op.Record(errAttempt)
+	op.Record(nil)
+	op.End()
+	op.End()
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, cb.Counts())
+
+	// all attempts fail
+	for i := 0; i < 2; i++ {
+		op, err = cb.BeginOperation()
+		assert.Nil(t, err)
+		op.Record(errAttempt)
+		op.Record(errAttempt)
+		op.End()
+	}
+	assert.Equal(t, StateOpen, cb.State())
+
+	_, err = cb.BeginOperation()
+	assert.ErrorIs(t, err, ErrOpenState)
+
+	// a single half-open slot for the whole operation
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	op, err = cb.BeginOperation()
+	assert.Nil(t, err)
+	_, err = cb.BeginOperation()
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+	op.Record(errAttempt)
+	op.Record(nil)
+	op.End()
+	assert.Equal(t, StateClosed, cb.State())
+}
@@ -0,0 +1,58 @@
+package circuitbreaker
+
+import "time"
+
+// Option configures a CircuitBreaker created with New. Each one sets the
+// corresponding Config field, and values that are out of range get the same
+// defaults they would in a Config
+type Option func(cfg *Config)
+
+// New returns a new instance of CircuitBreaker with the given name, configured
+// with opts on top of the defaults. It's shorthand for building a Config and
+// calling NewCircuitBreaker, for the common cases that don't need the rest of
+// the Config. Later options override earlier ones
+func New(name string, opts ...Option) *CircuitBreaker {
+	cfg := Config{Name: name}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return NewCircuitBreaker(cfg)
+}
+
+// WithTimeout sets TimeoutOpenState. A timeout that isn't positive defaults to
+// 60 seconds
+func WithTimeout(timeout time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.TimeoutOpenState = timeout
+	}
+}
+
+// WithMaxRequests sets MaxRequestsWhileHalfOpen. If it is 0, only 1 request
+// is allowed
+func WithMaxRequests(maxRequests uint32) Option {
+	return func(cfg *Config) {
+		cfg.MaxRequestsWhileHalfOpen = maxRequests
+	}
+}
+
+// WithShouldTrip sets ShouldTrip. If it is nil, the default ShouldTrip is used
+func WithShouldTrip(shouldTrip func(counts Counts) bool) Option {
+	return func(cfg *Config) {
+		cfg.ShouldTrip = shouldTrip
+	}
+}
+
+// WithInterval sets Interval. An interval that isn't positive means the
+// closed-state counts are never reset
+func WithInterval(interval time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.Interval = interval
+	}
+}
+
+// WithOnStateChange sets OnStateChange
+func WithOnStateChange(onStateChange func(from State, to State)) Option {
+	return func(cfg *Config) {
+		cfg.OnStateChange = onStateChange
+	}
+}
@@ -0,0 +1,42 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	stateChange := stateChangeTracker{}
+	cb := New("payments",
+		WithTimeout(time.Duration(30)*time.Second),
+		WithMaxRequests(3),
+		WithInterval(time.Duration(10)*time.Second),
+		WithShouldTrip(func(counts Counts) bool {
+			return counts.ConsecutiveFailures >= 2
+		}),
+		WithOnStateChange(func(from, to State) {
+			stateChange = stateChangeTracker{from, to}
+		}),
+	)
+	assert.Equal(t, "payments", cb.Name())
+	assert.Equal(t, time.Duration(30)*time.Second, cb.timeoutOpenState)
+	assert.Equal(t, uint32(3), cb.maxRequestsWhileHalfOpen)
+	assert.Equal(t, time.Duration(10)*time.Second, cb.interval)
+
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, stateChangeTracker{StateClosed, StateOpen}, stateChange)
+
+	// the same defaults as Config, later options win
+	cb = New("", WithTimeout(-time.Second), WithInterval(-time.Second), WithMaxRequests(0), WithShouldTrip(nil))
+	assert.Equal(t, time.Duration(60)*time.Second, cb.timeoutOpenState)
+	assert.Equal(t, time.Duration(0), cb.interval)
+	assert.Equal(t, uint32(1), cb.maxRequestsWhileHalfOpen)
+	assert.NotNil(t, cb.shouldTrip)
+
+	cb = New("", WithMaxRequests(2), WithMaxRequests(4))
+	assert.Equal(t, uint32(4), cb.maxRequestsWhileHalfOpen)
+}
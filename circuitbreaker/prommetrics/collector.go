@@ -0,0 +1,184 @@
+// Package prommetrics exposes the health of circuit breakers as Prometheus
+// metrics
+package prommetrics
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	stateDesc = prometheus.NewDesc(
+		"circuitbreaker_state",
+		"Current state of the circuit breaker: 0 closed, 1 half-open, 2 open",
+		[]string{"name"}, nil,
+	)
+	requestsDesc = prometheus.NewDesc(
+		"circuitbreaker_requests_total",
+		"Requests admitted by the circuit breaker",
+		[]string{"name"}, nil,
+	)
+	successesDesc = prometheus.NewDesc(
+		"circuitbreaker_successes_total",
+		"Successful requests",
+		[]string{"name"}, nil,
+	)
+	failuresDesc = prometheus.NewDesc(
+		"circuitbreaker_failures_total",
+		"Failed requests",
+		[]string{"name"}, nil,
+	)
+	rejectionsDesc = prometheus.NewDesc(
+		"circuitbreaker_rejections_total",
+		"Requests rejected by the circuit breaker",
+		[]string{"name"}, nil,
+	)
+)
+
+var (
+	// ErrDuplicateName is returned by Add for a name that's already taken
+	ErrDuplicateName = errors.New("circuit breaker name already added")
+
+	// ErrDuplicateBreaker is returned by Add for a circuit breaker that's
+	// already reported on, under another name
+	ErrDuplicateBreaker = errors.New("circuit breaker already added")
+)
+
+// Collector is a prometheus.Collector reporting the state, lifetime totals and
+// state transitions of one or more circuit breakers, labeled by name. The
+// request, success, failure and rejection counters are read from Stats, so
+// they only ever go up. State transitions are counted through a Notifier
+// added to each breaker.
+//
+// When a breaker trips or goes back to open, the failure counter carries an
+// exemplar made from the EventTrip or EventSustainedOpen, by default
+// TraceExemplar, linking the trip to the trace of the request that caused it.
+// Exemplars are only exposed in the OpenMetrics format, e.g. with
+// promhttp.HandlerOpts.EnableOpenMetrics
+type Collector struct {
+	mu        sync.RWMutex
+	breakers  map[string]*circuitbreaker.CircuitBreaker
+	exemplars map[string]prometheus.Exemplar
+	exemplar  func(event circuitbreaker.Event) prometheus.Labels
+
+	transitions *prometheus.CounterVec
+}
+
+// Option configures a Collector
+type Option func(c *Collector)
+
+// WithExemplar makes the exemplar labels of the failure counter with f
+// rather than TraceExemplar. f returns nil for no exemplar
+func WithExemplar(f func(event circuitbreaker.Event) prometheus.Labels) Option {
+	return func(c *Collector) {
+		c.exemplar = f
+	}
+}
+
+// TraceExemplar labels an exemplar with the trace_id of the event, see
+// circuitbreaker.Config.TraceIDs, or returns nil if it has none
+func TraceExemplar(event circuitbreaker.Event) prometheus.Labels {
+	if event.TraceID == "" {
+		return nil
+	}
+	return prometheus.Labels{"trace_id": event.TraceID}
+}
+
+// NewCollector returns a Collector reporting on cb under the given name
+func NewCollector(cb *circuitbreaker.CircuitBreaker, name string, opts ...Option) *Collector {
+	c := &Collector{
+		breakers:  make(map[string]*circuitbreaker.CircuitBreaker),
+		exemplars: make(map[string]prometheus.Exemplar),
+		exemplar:  TraceExemplar,
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "circuitbreaker_state_transitions_total",
+			Help: "State transitions of the circuit breaker",
+		}, []string{"name", "from", "to"}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	_ = c.Add(cb, name) // the Collector is empty
+	return c
+}
+
+// Add reports on cb as well, under the given name, and starts counting its
+// state transitions through a Notifier added to cb. Since a Notifier can't be
+// removed, neither can a breaker: Add returns ErrDuplicateName if the name is
+// taken and ErrDuplicateBreaker if cb is already reported on, rather than
+// leave a Notifier counting for a name that's gone or count cb twice
+func (c *Collector) Add(cb *circuitbreaker.CircuitBreaker, name string) error {
+	c.mu.Lock()
+	if _, ok := c.breakers[name]; ok {
+		c.mu.Unlock()
+		return ErrDuplicateName
+	}
+	for _, added := range c.breakers {
+		if added == cb {
+			c.mu.Unlock()
+			return ErrDuplicateBreaker
+		}
+	}
+	c.breakers[name] = cb
+	c.mu.Unlock()
+
+	cb.AddNotifier(circuitbreaker.FuncNotifier(func(event circuitbreaker.Event) {
+		switch event.Type {
+		case circuitbreaker.EventStateChange:
+			c.transitions.WithLabelValues(name, event.From.String(), event.To.String()).Inc()
+		case circuitbreaker.EventTrip, circuitbreaker.EventSustainedOpen:
+			c.recordExemplar(name, event)
+		}
+	}))
+	return nil
+}
+
+// recordExemplar keeps the exemplar of the event that opened the breaker, if
+// it makes one
+func (c *Collector) recordExemplar(name string, event circuitbreaker.Event) {
+	labels := c.exemplar(event)
+	if labels == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exemplars[name] = prometheus.Exemplar{
+		Value:     1,
+		Labels:    labels,
+		Timestamp: event.Time,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stateDesc
+	ch <- requestsDesc
+	ch <- successesDesc
+	ch <- failuresDesc
+	ch <- rejectionsDesc
+	c.transitions.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for name, cb := range c.breakers {
+		stats := cb.Stats()
+		ch <- prometheus.MustNewConstMetric(stateDesc, prometheus.GaugeValue, float64(cb.State()), name)
+		ch <- prometheus.MustNewConstMetric(requestsDesc, prometheus.CounterValue, float64(stats.Requests), name)
+		ch <- prometheus.MustNewConstMetric(successesDesc, prometheus.CounterValue, float64(stats.Successes), name)
+		ch <- prometheus.MustNewConstMetric(rejectionsDesc, prometheus.CounterValue, float64(stats.Rejections), name)
+		failures := prometheus.MustNewConstMetric(failuresDesc, prometheus.CounterValue, float64(stats.Failures), name)
+		if exemplar, ok := c.exemplars[name]; ok {
+			failures = prometheus.MustNewMetricWithExemplars(failures, exemplar)
+		}
+		ch <- failures
+	}
+	c.transitions.Collect(ch)
+}
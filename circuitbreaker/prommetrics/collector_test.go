@@ -0,0 +1,168 @@
+package prommetrics
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bnm3k/kit/circuitbreaker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	c := NewCollector(cb, "payments")
+
+	reg := prometheus.NewPedanticRegistry()
+	assert.Nil(t, reg.Register(c))
+
+	_, _ = cb.Do(func() (interface{}, error) { return nil, nil })
+	_, _ = cb.Do(func() (interface{}, error) { return nil, errors.New("fail") })
+	expected := `
+# HELP circuitbreaker_failures_total Failed requests
+# TYPE circuitbreaker_failures_total counter
+circuitbreaker_failures_total{name="payments"} 1
+# HELP circuitbreaker_rejections_total Requests rejected by the circuit breaker
+# TYPE circuitbreaker_rejections_total counter
+circuitbreaker_rejections_total{name="payments"} 0
+# HELP circuitbreaker_requests_total Requests admitted by the circuit breaker
+# TYPE circuitbreaker_requests_total counter
+circuitbreaker_requests_total{name="payments"} 2
+# HELP circuitbreaker_state Current state of the circuit breaker: 0 closed, 1 half-open, 2 open
+# TYPE circuitbreaker_state gauge
+circuitbreaker_state{name="payments"} 0
+# HELP circuitbreaker_successes_total Successful requests
+# TYPE circuitbreaker_successes_total counter
+circuitbreaker_successes_total{name="payments"} 1
+`
+	assert.Nil(t, testutil.GatherAndCompare(reg, strings.NewReader(expected)))
+
+	cb.ForceOpen()
+	cb.Reset()
+	cb.ForceOpen()
+	_, _ = cb.Do(func() (interface{}, error) { return nil, nil })
+	expected = `
+# HELP circuitbreaker_rejections_total Requests rejected by the circuit breaker
+# TYPE circuitbreaker_rejections_total counter
+circuitbreaker_rejections_total{name="payments"} 1
+# HELP circuitbreaker_requests_total Requests admitted by the circuit breaker
+# TYPE circuitbreaker_requests_total counter
+circuitbreaker_requests_total{name="payments"} 2
+# HELP circuitbreaker_state Current state of the circuit breaker: 0 closed, 1 half-open, 2 open
+# TYPE circuitbreaker_state gauge
+circuitbreaker_state{name="payments"} 2
+# HELP circuitbreaker_state_transitions_total State transitions of the circuit breaker
+# TYPE circuitbreaker_state_transitions_total counter
+circuitbreaker_state_transitions_total{from="closed",name="payments",to="open"} 2
+circuitbreaker_state_transitions_total{from="open",name="payments",to="closed"} 1
+`
+	assert.Nil(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"circuitbreaker_rejections_total", "circuitbreaker_requests_total",
+		"circuitbreaker_state", "circuitbreaker_state_transitions_total"))
+}
+
+func TestCollectorMultipleBreakers(t *testing.T) {
+	c := NewCollector(circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{}), "a")
+	open := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	open.ForceOpen()
+	assert.Nil(t, c.Add(open, "b"))
+
+	expected := `
+# HELP circuitbreaker_state Current state of the circuit breaker: 0 closed, 1 half-open, 2 open
+# TYPE circuitbreaker_state gauge
+circuitbreaker_state{name="a"} 0
+circuitbreaker_state{name="b"} 2
+`
+	assert.Nil(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "circuitbreaker_state"))
+}
+
+func TestCollectorDuplicates(t *testing.T) {
+	a := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	c := NewCollector(a, "a")
+	b := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	assert.Equal(t, ErrDuplicateName, c.Add(b, "a"))
+	assert.Equal(t, ErrDuplicateBreaker, c.Add(a, "b"))
+
+	// a is still the only breaker, and its transitions are counted once
+	a.ForceOpen()
+	b.ForceOpen()
+	expected := `
+# HELP circuitbreaker_state Current state of the circuit breaker: 0 closed, 1 half-open, 2 open
+# TYPE circuitbreaker_state gauge
+circuitbreaker_state{name="a"} 2
+# HELP circuitbreaker_state_transitions_total State transitions of the circuit breaker
+# TYPE circuitbreaker_state_transitions_total counter
+circuitbreaker_state_transitions_total{from="closed",name="a",to="open"} 1
+`
+	assert.Nil(t, testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"circuitbreaker_state", "circuitbreaker_state_transitions_total"))
+}
+
+type traceKey struct{}
+
+// traceIDs stands in for cbotel.TraceIDs, reading the IDs from ctx
+func traceIDs(ctx context.Context) (string, string) {
+	ids, _ := ctx.Value(traceKey{}).([2]string)
+	return ids[0], ids[1]
+}
+
+// failuresExemplar returns the labels of the exemplar on the failure counter,
+// nil if there's none
+func failuresExemplar(t *testing.T, reg *prometheus.Registry) map[string]string {
+	families, err := reg.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() != "circuitbreaker_failures_total" {
+			continue
+		}
+		exemplar := family.GetMetric()[0].GetCounter().GetExemplar()
+		if exemplar == nil {
+			return nil
+		}
+		labels := make(map[string]string)
+		for _, l := range exemplar.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		return labels
+	}
+	return nil
+}
+
+func TestCollectorExemplar(t *testing.T) {
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{TraceIDs: traceIDs})
+	c := NewCollector(cb, "payments")
+	reg := prometheus.NewPedanticRegistry()
+	assert.Nil(t, reg.Register(c))
+	trip := func(ctx context.Context) {
+		for i := 0; i < 6; i++ {
+			_, _ = cb.DoContext(ctx, func(context.Context) (interface{}, error) {
+				return nil, errors.New("fail")
+			})
+		}
+		assert.Equal(t, circuitbreaker.StateOpen, cb.State())
+	}
+
+	// no trace
+	trip(context.Background())
+	assert.Nil(t, failuresExemplar(t, reg))
+
+	cb.Reset()
+	trip(context.WithValue(context.Background(), traceKey{}, [2]string{"trace-1", "span-1"}))
+	assert.Equal(t, map[string]string{"trace_id": "trace-1"}, failuresExemplar(t, reg))
+}
+
+func TestCollectorWithExemplar(t *testing.T) {
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.Config{})
+	c := NewCollector(cb, "payments", WithExemplar(func(event circuitbreaker.Event) prometheus.Labels {
+		return prometheus.Labels{"generation": strconv.FormatUint(event.Generation, 10)}
+	}))
+	reg := prometheus.NewPedanticRegistry()
+	assert.Nil(t, reg.Register(c))
+
+	cb.ForceOpen()
+	assert.Equal(t, map[string]string{"generation": "2"}, failuresExemplar(t, reg))
+}
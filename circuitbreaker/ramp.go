@@ -0,0 +1,52 @@
+package circuitbreaker
+
+import "time"
+
+// rampFloor is the fraction of requests a recovery ramp admits right after
+// closing. Starting from none would turn away the first requests, which the
+// half-open probes just showed the dependency can serve
+const rampFloor = 0.1
+
+// recoveryRamp is the bookkeeping of the RecoveryRampDuration ramp, kept in
+// the closed state rather than as a State of its own so that the states
+// callers switch on stay the same
+type recoveryRamp struct {
+	started  time.Time
+	seen     uint64
+	admitted uint64
+}
+
+// start begins a ramp at now, discarding any earlier one
+func (r *recoveryRamp) start(now time.Time) {
+	*r = recoveryRamp{started: now}
+}
+
+// stop ends the ramp, if any, so that every request is admitted
+func (r *recoveryRamp) stop() {
+	*r = recoveryRamp{}
+}
+
+// rampAdmits reports whether the recovery ramp admits a request made in the
+// given state at now, counting it if the ramp is ongoing. Requests are
+// admitted evenly rather than at random, so that the fraction admitted tracks
+// the time elapsed since closing. It must be called with the mutex held
+func (cb *CircuitBreaker) rampAdmits(state State, now time.Time) bool {
+	r := &cb.ramp
+	if state != StateClosed || r.started.IsZero() {
+		return true
+	}
+	elapsed := now.Sub(r.started)
+	if elapsed >= cb.recoveryRampDuration {
+		r.stop()
+		cb.publish() // back on the atomic hot path, if it's enabled
+		return true
+	}
+
+	fraction := rampFloor + (1-rampFloor)*float64(elapsed)/float64(cb.recoveryRampDuration)
+	r.seen++
+	if float64(r.admitted) >= fraction*float64(r.seen) {
+		return false
+	}
+	r.admitted++
+	return true
+}
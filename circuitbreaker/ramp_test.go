@@ -0,0 +1,70 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoveryRamp(t *testing.T) {
+	clock := &steppingClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cb := NewCircuitBreaker(Config{
+		RecoveryRampDuration: 10 * time.Second,
+		Clock:                clock,
+	})
+
+	// no ramp the first time round
+	assert.Nil(t, succeed(cb))
+
+	trip := func() {
+		for i := 0; i < 6; i++ {
+			assert.Nil(t, fail(cb))
+		}
+		assert.Equal(t, StateOpen, cb.State())
+		clock.now = clock.now.Add(61 * time.Second)
+		assert.Nil(t, succeed(cb))
+		assert.Equal(t, StateClosed, cb.State())
+	}
+	trip()
+
+	// right after closing, the first request is admitted, and a tenth of
+	// them from then on
+	assert.Nil(t, succeed(cb))
+	admitted := 0
+	for i := 0; i < 9; i++ {
+		err := succeed(cb)
+		if err == nil {
+			admitted++
+			continue
+		}
+		assert.True(t, IsRejection(err))
+		assert.Equal(t, RejectedError{State: StateClosed, Err: ErrRecovering}, err)
+	}
+	assert.Equal(t, 0, admitted)
+
+	// halfway through, 55% of the requests since closing are
+	clock.now = clock.now.Add(5 * time.Second)
+	for i := 0; i < 20; i++ {
+		_ = succeed(cb)
+	}
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, uint32(17), cb.Counts().TotalSuccesses) // of 30
+	assert.Zero(t, cb.Counts().TotalFailures)
+
+	// and everything once it's over
+	clock.now = clock.now.Add(5 * time.Second)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+
+	// Reset ends a ramp early
+	trip()
+	cb.Reset()
+	assert.Nil(t, succeed(cb))
+
+	// as does a ForceMode
+	trip()
+	cb.ForceClose()
+	assert.Nil(t, succeed(cb))
+}
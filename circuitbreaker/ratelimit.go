@@ -0,0 +1,48 @@
+package circuitbreaker
+
+import "time"
+
+// RateLimiter decides whether a request may proceed given the rate of
+// requests so far. It's satisfied by *rate.Limiter from golang.org/x/time/rate
+type RateLimiter interface {
+	Allow() bool
+}
+
+// tokenBucket is the RateLimiter for RateLimit. It holds up to burst tokens,
+// refilled at rate tokens per second, and each admitted request takes one. It
+// isn't safe for concurrent use: the CircuitBreaker only consults its
+// RateLimiter with the mutex held
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	clock  Clock
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, clock Clock) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		clock:  clock,
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}
+}
+
+func (b *tokenBucket) Allow() bool {
+	now := b.clock.Now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
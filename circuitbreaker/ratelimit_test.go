@@ -0,0 +1,112 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingLimiter struct {
+	allowed int
+	calls   int
+}
+
+func (l *countingLimiter) Allow() bool {
+	l.calls++
+	return l.calls <= l.allowed
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := &countingLimiter{allowed: 6}
+	var rejected []error
+	cb := NewCircuitBreaker(Config{
+		RateLimiter: limiter,
+		Trace: func(event TraceEvent) {
+			if !event.Admitted {
+				rejected = append(rejected, event.Err)
+			}
+		},
+	})
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Nil(t, succeed(cb))
+
+	// denied by the limiter, not counted
+	assert.ErrorIs(t, succeed(cb), ErrRateLimited)
+	assert.ErrorIs(t, fail(cb), ErrRateLimited)
+	assert.Equal(t, Counts{6, 1, 0, 1, 5, 0}, cb.Counts())
+	assert.Equal(t, StateClosed, cb.State())
+
+	// denied by the state, the limiter isn't consulted
+	limiter.allowed = 100
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	calls := limiter.calls
+	assert.ErrorIs(t, succeed(cb), ErrOpenState)
+	assert.Equal(t, calls, limiter.calls)
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	limiter.allowed = 0
+	assert.ErrorIs(t, succeed(cb), ErrRateLimited)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+	assert.Equal(t, []error{
+		RejectedError{State: StateClosed, Err: ErrRateLimited},
+		RejectedError{State: StateClosed, Err: ErrRateLimited},
+		RejectedError{State: StateOpen, Err: ErrOpenState},
+		RejectedError{State: StateHalfOpen, Err: ErrRateLimited},
+	}, rejected)
+}
+
+type steppingClock struct {
+	now time.Time
+}
+
+func (c *steppingClock) Now() time.Time {
+	return c.now
+}
+
+func TestRateLimit(t *testing.T) {
+	clock := &steppingClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cb := NewCircuitBreaker(Config{
+		RateLimit: 2,
+		Burst:     3,
+		Clock:     clock,
+	})
+
+	// the burst, then nothing until the bucket refills
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	assert.ErrorIs(t, succeed(cb), ErrRateLimited)
+	assert.Equal(t, Counts{3, 3, 0, 3, 0, 0}, cb.Counts())
+
+	clock.now = clock.now.Add(time.Duration(500) * time.Millisecond)
+	assert.Nil(t, succeed(cb))
+	assert.ErrorIs(t, succeed(cb), ErrRateLimited)
+
+	// capped at the burst
+	clock.now = clock.now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	assert.ErrorIs(t, succeed(cb), ErrRateLimited)
+
+	// a clock that goes back doesn't refill
+	clock.now = clock.now.Add(-time.Second)
+	assert.ErrorIs(t, succeed(cb), ErrRateLimited)
+	clock.now = clock.now.Add(time.Second)
+	assert.ErrorIs(t, succeed(cb), ErrRateLimited)
+
+	assert.Equal(t, 2, NewCircuitBreaker(Config{RateLimit: 1.5}).Config().Burst)
+	assert.Nil(t, NewCircuitBreaker(Config{}).rateLimiter)
+
+	// RateLimiter takes precedence
+	limiter := &countingLimiter{allowed: 1}
+	cb = NewCircuitBreaker(Config{RateLimit: 100, RateLimiter: limiter})
+	assert.Nil(t, succeed(cb))
+	assert.ErrorIs(t, succeed(cb), ErrRateLimited)
+}
@@ -0,0 +1,100 @@
+package circuitbreaker
+
+// RatioBasis selects the requests the failure ratio is computed over
+type RatioBasis int
+
+const (
+	// RatioPerGeneration computes the failure ratio over the requests that
+	// completed in the current generation, i.e. since the last state change
+	// or closed-state interval reset
+	RatioPerGeneration RatioBasis = iota
+
+	// RatioLastNRequests computes the failure ratio over the last
+	// RatioSampleSize requests that completed since the last state change.
+	// Unlike RatioPerGeneration, closed-state interval resets don't clear it
+	RatioLastNRequests
+)
+
+// outcomeSample is a ring buffer holding the most recent request outcomes. A
+// nil *outcomeSample is valid and records nothing
+type outcomeSample struct {
+	failed   []bool
+	next     int
+	len      int
+	failures int
+}
+
+func newOutcomeSample(size uint32) *outcomeSample {
+	return &outcomeSample{failed: make([]bool, size)}
+}
+
+func (s *outcomeSample) record(success bool) {
+	if s == nil {
+		return
+	}
+
+	if s.len == len(s.failed) {
+		// evict the oldest outcome
+		if s.failed[s.next] {
+			s.failures--
+		}
+	} else {
+		s.len++
+	}
+
+	s.failed[s.next] = !success
+	if !success {
+		s.failures++
+	}
+	s.next = (s.next + 1) % len(s.failed)
+}
+
+func (s *outcomeSample) ratio() (float64, uint32) {
+	if s.len == 0 {
+		return 0, 0
+	}
+	return float64(s.failures) / float64(s.len), uint32(s.len)
+}
+
+func (s *outcomeSample) reset() {
+	if s == nil {
+		return
+	}
+	*s = outcomeSample{failed: s.failed}
+}
+
+func (s *outcomeSample) clone() *outcomeSample {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.failed = append([]bool(nil), s.failed...)
+	return &c
+}
+
+// FailureRatio returns the share of failed requests among the ones selected
+// by RatioBasis. It's 0 if none have completed yet
+func (cb *CircuitBreaker) FailureRatio() float64 {
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	cb.currentState(now)
+	ratio, _ := cb.failureRatio(cb.tripCounts(now))
+	return ratio
+}
+
+// failureRatio returns the failure ratio along with the number of completed
+// requests it was computed over. Unless RatioBasis is RatioLastNRequests, it's
+// computed from the totals in counts
+func (cb *CircuitBreaker) failureRatio(counts Counts) (float64, uint32) {
+	if cb.sample != nil {
+		return cb.sample.ratio()
+	}
+
+	completed := counts.TotalSuccesses + counts.TotalFailures
+	if completed == 0 {
+		return 0, 0
+	}
+	return float64(counts.TotalFailures) / float64(completed), completed
+}
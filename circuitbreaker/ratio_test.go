@@ -0,0 +1,60 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureRatioPerGeneration(t *testing.T) {
+	cb := NewCircuitBreaker(Config{Interval: time.Duration(30) * time.Second})
+	assert.Equal(t, 0.0, cb.FailureRatio())
+
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, 0.5, cb.FailureRatio())
+
+	// cleared at the closed-state interval
+	pseudoSleep(cb, time.Duration(30)*time.Second)
+	assert.Equal(t, 0.0, cb.FailureRatio())
+}
+
+func TestFailureRatioLastNRequests(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		Interval:        time.Duration(30) * time.Second,
+		RatioBasis:      RatioLastNRequests,
+		RatioSampleSize: 4,
+	})
+	assert.Equal(t, 0.0, cb.FailureRatio())
+
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, succeed(cb))
+	assert.InDelta(t, 2.0/3.0, cb.FailureRatio(), 1e-9)
+
+	// survives the closed-state interval
+	pseudoSleep(cb, time.Duration(30)*time.Second)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+	assert.InDelta(t, 2.0/3.0, cb.FailureRatio(), 1e-9)
+
+	// the oldest outcomes are evicted
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, 0.5, cb.FailureRatio())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, 0.25, cb.FailureRatio())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, 0.0, cb.FailureRatio())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, 0.25, cb.FailureRatio())
+
+	// cleared on state change
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, 0.0, cb.FailureRatio())
+}
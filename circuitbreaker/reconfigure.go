@@ -0,0 +1,131 @@
+package circuitbreaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// configJSON is the JSON form of a Config: its scalar fields, which are all
+// that can be serialized
+type configJSON struct {
+	Name                        string       `json:"name,omitempty"`
+	MaxRequestsWhileHalfOpen    uint32       `json:"max_requests_while_half_open,omitempty"`
+	SuccessThreshold            uint32       `json:"success_threshold,omitempty"`
+	Interval                    jsonDuration `json:"interval,omitempty"`
+	TimeoutOpenState            jsonDuration `json:"timeout_open_state,omitempty"`
+	ConsecutiveFailureThreshold uint32       `json:"consecutive_failure_threshold,omitempty"`
+	FailureRateThreshold        float64      `json:"failure_rate_threshold,omitempty"`
+	MinimumRequests             uint32       `json:"minimum_requests,omitempty"`
+}
+
+// jsonDuration is a time.Duration encoded as a string such as "1m30s". A
+// number of nanoseconds is accepted as well
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if json.Unmarshal(data, &n) != nil {
+			return fmt.Errorf("circuitbreaker: duration must be a string, got %s", data)
+		}
+		*d = jsonDuration(n)
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("circuitbreaker: %w", err)
+	}
+	*d = jsonDuration(parsed)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. Only the scalar fields are encoded:
+// Name, MaxRequestsWhileHalfOpen, SuccessThreshold, Interval,
+// TimeoutOpenState, ConsecutiveFailureThreshold, FailureRateThreshold and
+// MinimumRequests, with the durations as strings such as "30s". Unset ones
+// are left out
+func (cfg Config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(configJSON{
+		Name:                        cfg.Name,
+		MaxRequestsWhileHalfOpen:    cfg.MaxRequestsWhileHalfOpen,
+		SuccessThreshold:            cfg.SuccessThreshold,
+		Interval:                    jsonDuration(cfg.Interval),
+		TimeoutOpenState:            jsonDuration(cfg.TimeoutOpenState),
+		ConsecutiveFailureThreshold: cfg.ConsecutiveFailureThreshold,
+		FailureRateThreshold:        cfg.FailureRateThreshold,
+		MinimumRequests:             cfg.MinimumRequests,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It sets the scalar fields
+// MarshalJSON encodes, those missing from data being unset, and leaves the
+// others, such as callbacks, as they are, so that data can be decoded onto a
+// Config that has them
+func (cfg *Config) UnmarshalJSON(data []byte) error {
+	var c configJSON
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	cfg.Name = c.Name
+	cfg.MaxRequestsWhileHalfOpen = c.MaxRequestsWhileHalfOpen
+	cfg.SuccessThreshold = c.SuccessThreshold
+	cfg.Interval = time.Duration(c.Interval)
+	cfg.TimeoutOpenState = time.Duration(c.TimeoutOpenState)
+	cfg.ConsecutiveFailureThreshold = c.ConsecutiveFailureThreshold
+	cfg.FailureRateThreshold = c.FailureRateThreshold
+	cfg.MinimumRequests = c.MinimumRequests
+	return nil
+}
+
+// Reconfigure swaps in the hot-reloadable settings of cfg, e.g. decoded from
+// JSON, without losing the current state or counts. Those are the scalar
+// fields MarshalJSON encodes but Name, which identifies the CircuitBreaker
+// and so can't change: MaxRequestsWhileHalfOpen, SuccessThreshold, Interval,
+// TimeoutOpenState, ConsecutiveFailureThreshold, FailureRateThreshold and
+// MinimumRequests. Defaults apply to them as they do for NewCircuitBreaker.
+// The rest of cfg, callbacks included, is ignored. The thresholds apply from
+// the next outcome, while Interval and TimeoutOpenState apply from the next
+// generation: the current one keeps its expiry
+func (cb *CircuitBreaker) Reconfigure(cfg Config) {
+	raw := cfg
+	cfg.setDefaults()
+
+	cb.lock()
+	defer cb.unlock()
+
+	cb.maxRequestsWhileHalfOpen = cfg.MaxRequestsWhileHalfOpen
+	cb.successThreshold = cfg.SuccessThreshold
+	cb.interval = cfg.Interval
+	cb.timeoutOpenState = cfg.TimeoutOpenState
+	cb.consecutiveFailureThreshold = cfg.ConsecutiveFailureThreshold
+	cb.failureRateThreshold = cfg.FailureRateThreshold
+	cb.minimumRequests = cfg.MinimumRequests
+	if cb.config.HalfOpenMaxRequests == 0 {
+		cb.halfOpenMaxRequests = cfg.MaxRequestsWhileHalfOpen
+		cb.effective.HalfOpenMaxRequests = cfg.MaxRequestsWhileHalfOpen
+	}
+	if cb.config.HalfOpenAdmissionStrategy == nil {
+		cb.halfOpenAdmission = CapAdmission{Max: cb.halfOpenRequestLimit()}
+	}
+
+	reloadable(&cb.config, raw)
+	reloadable(&cb.effective, cfg)
+}
+
+// reloadable copies the settings Reconfigure swaps in from src to dst
+func reloadable(dst *Config, src Config) {
+	dst.MaxRequestsWhileHalfOpen = src.MaxRequestsWhileHalfOpen
+	dst.SuccessThreshold = src.SuccessThreshold
+	dst.Interval = src.Interval
+	dst.TimeoutOpenState = src.TimeoutOpenState
+	dst.ConsecutiveFailureThreshold = src.ConsecutiveFailureThreshold
+	dst.FailureRateThreshold = src.FailureRateThreshold
+	dst.MinimumRequests = src.MinimumRequests
+}
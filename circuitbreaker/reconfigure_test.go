@@ -0,0 +1,90 @@
+package circuitbreaker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigJSON(t *testing.T) {
+	cfg := Config{
+		Name:                     "db",
+		MaxRequestsWhileHalfOpen: 3,
+		Interval:                 time.Duration(90) * time.Second,
+		FailureRateThreshold:     0.5,
+		ShouldTrip:               func(counts Counts) bool { return false },
+	}
+	b, err := json.Marshal(cfg)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"name": "db",
+		"max_requests_while_half_open": 3,
+		"interval": "1m30s",
+		"failure_rate_threshold": 0.5
+	}`, string(b))
+
+	// the callbacks are kept
+	decoded := Config{
+		MinimumRequests: 10,
+		ShouldTrip:      cfg.ShouldTrip,
+	}
+	assert.Nil(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, "db", decoded.Name)
+	assert.Equal(t, uint32(3), decoded.MaxRequestsWhileHalfOpen)
+	assert.Equal(t, time.Duration(90)*time.Second, decoded.Interval)
+	assert.Equal(t, 0.5, decoded.FailureRateThreshold)
+	assert.Zero(t, decoded.MinimumRequests)
+	assert.NotNil(t, decoded.ShouldTrip)
+
+	assert.Nil(t, json.Unmarshal([]byte(`{"timeout_open_state": 1000000000}`), &decoded))
+	assert.Equal(t, time.Second, decoded.TimeoutOpenState)
+	assert.Error(t, json.Unmarshal([]byte(`{"interval": "soon"}`), &decoded))
+	assert.Error(t, json.Unmarshal([]byte(`{"interval": true}`), &decoded))
+}
+
+func TestReconfigure(t *testing.T) {
+	var changes []State
+	cb := NewCircuitBreaker(Config{
+		Name: "db",
+		OnStateChange: func(from, to State) {
+			changes = append(changes, to)
+		},
+	})
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(cb))
+	}
+
+	var cfg Config
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"name": "other",
+		"max_requests_while_half_open": 2,
+		"timeout_open_state": "10s",
+		"consecutive_failure_threshold": 3
+	}`), &cfg))
+	cb.Reconfigure(cfg)
+	assert.Equal(t, Counts{3, 0, 3, 0, 3, 0}, cb.Counts())
+
+	// the new threshold applies to the counts so far
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.InDelta(t, float64(10*time.Second), float64(time.Until(cb.expiry)), float64(time.Second))
+
+	// as does the number of probes
+	pseudoSleep(cb, time.Duration(10)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, []State{StateOpen, StateHalfOpen, StateClosed}, changes)
+
+	// callbacks and the name are untouched
+	got := cb.Config()
+	assert.Equal(t, "db", got.Name)
+	assert.NotNil(t, got.OnStateChange)
+	assert.Equal(t, uint32(2), got.MaxRequestsWhileHalfOpen)
+	assert.Equal(t, uint32(2), got.SuccessThreshold)
+	assert.Equal(t, time.Duration(10)*time.Second, got.TimeoutOpenState)
+	assert.Equal(t, uint32(2), cb.Clone(nil).maxRequestsWhileHalfOpen)
+}
@@ -0,0 +1,107 @@
+package circuitbreaker
+
+import "time"
+
+// halfOpenRetryAfter is the back-off RetryAfter suggests while half-open,
+// short since a free probe slot or the transition to closed is usually close
+const halfOpenRetryAfter = time.Second
+
+// RecoveryStatus summarizes where a CircuitBreaker is on its way back to the
+// closed state. Fields that don't apply to the current state are zero: a
+// closed CircuitBreaker only sets State
+type RecoveryStatus struct {
+	// State is the current state
+	State State
+
+	// Successes is the number of consecutive successful probes so far while
+	// half-open
+	Successes uint32
+
+	// SuccessesNeeded is the number of consecutive successful probes required
+	// to close while half-open. With HalfOpenSuccessRatio it's the number of
+	// probes sampled instead, and Successes counts all successful probes
+	SuccessesNeeded uint32
+
+	// UntilHalfOpen is the time left before an open CircuitBreaker starts
+	// probing. It is zero if the probe budget is exhausted, in which case the
+	// CircuitBreaker stays open until Reset
+	UntilHalfOpen time.Duration
+
+	// ProbeAttempts is the number of half-open entries used in the current
+	// open episode while open or half-open
+	ProbeAttempts int
+
+	// MaxProbeAttempts is the probe budget while open or half-open, 0 if
+	// unlimited
+	MaxProbeAttempts int
+}
+
+// RecoveryStatus returns a consistent view of the CircuitBreaker's recovery
+// progress
+func (cb *CircuitBreaker) RecoveryStatus() RecoveryStatus {
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	state, _ := cb.currentState(now)
+	status := RecoveryStatus{State: state}
+
+	switch state {
+	case StateOpen:
+		if !cb.expiry.IsZero() {
+			status.UntilHalfOpen = cb.expiry.Sub(now)
+		}
+	case StateHalfOpen:
+		status.Successes = cb.counts.ConsecutiveSuccesses
+		status.SuccessesNeeded = cb.successThreshold
+		if cb.halfOpenSuccessRatio > 0 {
+			status.Successes = cb.counts.TotalSuccesses
+			status.SuccessesNeeded = cb.halfOpenMaxRequests
+		}
+	default:
+		return status
+	}
+	status.ProbeAttempts = cb.probeAttempts
+	status.MaxProbeAttempts = cb.maxProbeAttempts
+	return status
+}
+
+// SubmitProbeResult feeds the result of a dedicated health check, e.g. a
+// health-check RPC, to a half-open CircuitBreaker. It counts towards closing
+// or reopening exactly like a probe request would, but doesn't take up one of
+// the slots available to real requests. It's a no-op in any other state
+func (cb *CircuitBreaker) SubmitProbeResult(success bool) {
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	state, _ := cb.currentState(now)
+	if state != StateHalfOpen {
+		return
+	}
+	cb.setState(cb.evaluate(state, outcome{success: success, probe: true}, now), now)
+}
+
+// RetryAfter suggests how long callers should wait before retrying, e.g. for
+// a Retry-After header. While open it's the time left until the
+// CircuitBreaker goes half-open, which accounts for any adjustment to the
+// open-state timeout, or the full TimeoutOpenState if the probe budget is
+// exhausted. While half-open it's one second, and while closed it's zero
+func (cb *CircuitBreaker) RetryAfter() time.Duration {
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	state, _ := cb.currentState(now)
+	switch state {
+	case StateOpen:
+		if cb.expiry.IsZero() {
+			return cb.timeoutOpenState
+		}
+		return cb.expiry.Sub(now)
+	case StateHalfOpen:
+		return halfOpenRetryAfter
+	default:
+		return 0
+	}
+}
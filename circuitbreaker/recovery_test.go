@@ -0,0 +1,285 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoveryStatus(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		MaxRequestsWhileHalfOpen: 3,
+		MaxProbeAttempts:         2,
+	})
+	assert.Equal(t, RecoveryStatus{State: StateClosed}, cb.RecoveryStatus())
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	pseudoSleep(cb, time.Duration(20)*time.Second)
+	status := cb.RecoveryStatus()
+	assert.Equal(t, StateOpen, status.State)
+	assert.InDelta(t, float64(40*time.Second), float64(status.UntilHalfOpen), float64(time.Second))
+	assert.Equal(t, 0, status.ProbeAttempts)
+	assert.Equal(t, 2, status.MaxProbeAttempts)
+	assert.Zero(t, status.Successes)
+	assert.Zero(t, status.SuccessesNeeded)
+
+	pseudoSleep(cb, time.Duration(40)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, RecoveryStatus{
+		State:            StateHalfOpen,
+		Successes:        1,
+		SuccessesNeeded:  3,
+		ProbeAttempts:    1,
+		MaxProbeAttempts: 2,
+	}, cb.RecoveryStatus())
+
+	// budget exhausted
+	assert.Nil(t, fail(cb))
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, RecoveryStatus{
+		State:            StateOpen,
+		ProbeAttempts:    2,
+		MaxProbeAttempts: 2,
+	}, cb.RecoveryStatus())
+}
+
+func TestSubmitProbeResult(t *testing.T) {
+	cb := NewCircuitBreaker(Config{MaxRequestsWhileHalfOpen: 2, IgnoreFirstN: 1})
+
+	// no-op while closed
+	cb.SubmitProbeResult(false)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.counts)
+
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+
+	// no-op while open
+	cb.SubmitProbeResult(true)
+	assert.Equal(t, StateOpen, cb.State())
+
+	// StateHalfOpen to StateOpen
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	cb.SubmitProbeResult(false)
+	assert.Equal(t, StateOpen, cb.State())
+
+	// StateHalfOpen to StateClosed, without using up request slots
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	cb.SubmitProbeResult(true)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Equal(t, Counts{0, 1, 0, 1, 0, 0}, cb.counts)
+	cb.SubmitProbeResult(true)
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestRetryAfter(t *testing.T) {
+	cb := NewCircuitBreaker(Config{MaxProbeAttempts: 1})
+	assert.Equal(t, time.Duration(0), cb.RetryAfter())
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	prev := cb.RetryAfter()
+	assert.InDelta(t, float64(60*time.Second), float64(prev), float64(time.Second))
+	for i := 0; i < 5; i++ {
+		pseudoSleep(cb, time.Duration(10)*time.Second)
+		retryAfter := cb.RetryAfter()
+		assert.Less(t, retryAfter, prev)
+		assert.Greater(t, retryAfter, time.Duration(0))
+		prev = retryAfter
+	}
+
+	pseudoSleep(cb, time.Duration(10)*time.Second)
+	assert.Equal(t, time.Second, cb.RetryAfter())
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	// probe budget exhausted
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, time.Duration(60)*time.Second, cb.RetryAfter())
+}
+
+func TestHalfOpenSuccessRatio(t *testing.T) {
+	newHalfOpen := func(tolerate bool) *CircuitBreaker {
+		cb := NewCircuitBreaker(Config{
+			HalfOpenSuccessRatio:     0.75,
+			HalfOpenMaxRequests:      4,
+			HalfOpenTolerateFailures: tolerate,
+		})
+		cb.ForceOpen()
+		pseudoSleep(cb, time.Duration(60)*time.Second)
+		assert.Equal(t, StateHalfOpen, cb.State())
+		return cb
+	}
+
+	// a single failure still reopens by default
+	cb := newHalfOpen(false)
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	// 3 out of 4 is enough
+	cb = newHalfOpen(true)
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Equal(t, RecoveryStatus{
+		State:           StateHalfOpen,
+		Successes:       2,
+		SuccessesNeeded: 4,
+		ProbeAttempts:   1,
+	}, cb.RecoveryStatus())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+
+	// reopens as soon as 3 out of 4 is out of reach
+	cb = newHalfOpen(true)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	// up to HalfOpenMaxRequests probes are admitted
+	cb = newHalfOpen(true)
+	for i := 0; i < 4; i++ {
+		succeedLater(cb, time.Duration(20)*time.Millisecond)
+	}
+	time.Sleep(time.Duration(5) * time.Millisecond)
+	assert.ErrorIs(t, succeed(cb), ErrTooManyRequests)
+	time.Sleep(time.Duration(30) * time.Millisecond)
+	assert.Equal(t, StateClosed, cb.State())
+
+	// HalfOpenMaxRequests defaults to MaxRequestsWhileHalfOpen
+	cb = NewCircuitBreaker(Config{MaxRequestsWhileHalfOpen: 3, HalfOpenSuccessRatio: 0.5})
+	assert.Equal(t, uint32(3), cb.halfOpenMaxRequests)
+}
+
+func TestHalfOpenSingleProbe(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		MaxRequestsWhileHalfOpen: 3,
+		HalfOpenSingleProbe:      true,
+	})
+	cb.ForceOpen()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+
+	// one probe at a time
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.ErrorIs(t, succeed(cb), ErrTooManyRequests)
+	assert.Nil(t, <-ch)
+
+	// the next one is admitted once the previous one is over
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+
+	// a panicking probe frees the slot too, by reopening
+	cb.ForceOpen()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Panics(t, func() {
+		_, _ = cb.Do(func() (interface{}, error) { panic("oops") })
+	})
+	assert.Equal(t, StateOpen, cb.State())
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+
+	// as does one whose outcome is ignored
+	cb = NewCircuitBreaker(Config{HalfOpenSingleProbe: true, Classify: classifyNotFound})
+	cb.ForceOpen()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	_, err := cb.Do(func() (interface{}, error) { return nil, errNotFound })
+	assert.ErrorIs(t, err, errNotFound)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestHalfOpenCloseOnFirstSuccess(t *testing.T) {
+	var mu sync.Mutex
+	var changes []State
+	tscb := NewTwoStepCircuitBreaker(Config{
+		MaxRequestsWhileHalfOpen:    10,
+		HalfOpenCloseOnFirstSuccess: true,
+		HalfOpenSuccessRatio:        0.9,
+		OnStateChange: func(from, to State) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, to)
+		},
+	})
+
+	// a failure still reopens
+	tscb.cb.ForceOpen()
+	pseudoSleep(tscb.cb, time.Duration(60)*time.Second)
+	done, err := tscb.Allow()
+	assert.Nil(t, err)
+	done(false)
+	assert.Equal(t, StateOpen, tscb.State())
+
+	// concurrent successful probes close it once
+	pseudoSleep(tscb.cb, time.Duration(60)*time.Second)
+	dones := make([]func(bool), 10)
+	for i := range dones {
+		dones[i], err = tscb.Allow()
+		assert.Nil(t, err)
+	}
+	var wg sync.WaitGroup
+	for _, done := range dones {
+		wg.Add(1)
+		go func(done func(bool)) {
+			defer wg.Done()
+			done(true)
+		}(done)
+	}
+	wg.Wait()
+	assert.Equal(t, StateClosed, tscb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, tscb.Counts())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []State{StateOpen, StateHalfOpen, StateOpen, StateHalfOpen, StateClosed}, changes)
+}
+
+func TestSuccessThreshold(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		MaxRequestsWhileHalfOpen: 5,
+		SuccessThreshold:         2,
+	})
+	assert.Equal(t, uint32(2), cb.successThreshold)
+	assert.Equal(t, uint32(5), cb.halfOpenRequestLimit())
+
+	cb.ForceOpen()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Equal(t, uint32(2), cb.RecoveryStatus().SuccessesNeeded)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+
+	// probes are still admitted up to MaxRequestsWhileHalfOpen
+	tscb := NewTwoStepCircuitBreaker(Config{
+		MaxRequestsWhileHalfOpen: 3,
+		SuccessThreshold:         2,
+	})
+	tscb.cb.ForceOpen()
+	pseudoSleep(tscb.cb, time.Duration(60)*time.Second)
+	for i := 0; i < 3; i++ {
+		_, err := tscb.Allow()
+		assert.Nil(t, err)
+	}
+	_, err := tscb.Allow()
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+
+	// defaults to MaxRequestsWhileHalfOpen, which also caps it
+	assert.Equal(t, uint32(3), NewCircuitBreaker(Config{MaxRequestsWhileHalfOpen: 3}).successThreshold)
+	assert.Equal(t, uint32(3), NewCircuitBreaker(Config{
+		MaxRequestsWhileHalfOpen: 3,
+		SuccessThreshold:         4,
+	}).successThreshold)
+}
@@ -0,0 +1,86 @@
+package circuitbreaker
+
+import "sync"
+
+// Registry holds CircuitBreakers by name, e.g. one per downstream host. It's
+// safe for concurrent use
+type Registry struct {
+	mu       sync.RWMutex
+	breakers map[string]*CircuitBreaker
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{breakers: make(map[string]*CircuitBreaker)}
+}
+
+// GetOrCreate returns the CircuitBreaker registered under name, creating it
+// from cfg if there's none. cfg is ignored if the CircuitBreaker already
+// exists. If cfg.Name is empty, it's set to name
+func (r *Registry) GetOrCreate(name string, cfg Config) *CircuitBreaker {
+	if cb, ok := r.Get(name); ok {
+		return cb
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// created by someone else in the meantime
+	if cb, ok := r.breakers[name]; ok {
+		return cb
+	}
+	if cfg.Name == "" {
+		cfg.Name = name
+	}
+	cb := NewCircuitBreaker(cfg)
+	r.breakers[name] = cb
+	return cb
+}
+
+// Get returns the CircuitBreaker registered under name, if any
+func (r *Registry) Get(name string) (*CircuitBreaker, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cb, ok := r.breakers[name]
+	return cb, ok
+}
+
+// All returns a copy of the registered CircuitBreakers by name
+func (r *Registry) All() map[string]*CircuitBreaker {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	all := make(map[string]*CircuitBreaker, len(r.breakers))
+	for name, cb := range r.breakers {
+		all[name] = cb
+	}
+	return all
+}
+
+// Remove unregisters the CircuitBreaker under name, if any. The
+// CircuitBreaker itself keeps working for whoever still holds it, and the
+// next GetOrCreate for name creates a new one
+func (r *Registry) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.breakers, name)
+}
+
+// ForEach calls f for every CircuitBreaker registered when it's called. The
+// registry isn't locked while f runs, so f is free to use it, and
+// CircuitBreakers added or removed in the meantime don't affect the iteration
+func (r *Registry) ForEach(f func(name string, cb *CircuitBreaker)) {
+	for name, cb := range r.All() {
+		f(name, cb)
+	}
+}
+
+// ResetOpen resets the registered CircuitBreakers that are open, leaving the
+// closed and half-open ones alone, see ResetIfState
+func (r *Registry) ResetOpen() {
+	r.ForEach(func(_ string, cb *CircuitBreaker) {
+		cb.ResetIfState(StateOpen)
+	})
+}
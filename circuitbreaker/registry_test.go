@@ -0,0 +1,93 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	_, ok := r.Get("a")
+	assert.False(t, ok)
+
+	a := r.GetOrCreate("a", Config{MaxRequestsWhileHalfOpen: 3})
+	assert.Equal(t, "a", a.Name())
+	assert.Equal(t, uint32(3), a.maxRequestsWhileHalfOpen)
+
+	// the existing breaker is returned, cfg is ignored
+	assert.Same(t, a, r.GetOrCreate("a", Config{}))
+	got, ok := r.Get("a")
+	assert.True(t, ok)
+	assert.Same(t, a, got)
+
+	b := r.GetOrCreate("b", Config{Name: "payments-api"})
+	assert.Equal(t, "payments-api", b.Name())
+
+	// All returns a copy
+	all := r.All()
+	assert.Equal(t, map[string]*CircuitBreaker{"a": a, "b": b}, all)
+	delete(all, "a")
+	_, ok = r.Get("a")
+	assert.True(t, ok)
+
+	r.Remove("a")
+	_, ok = r.Get("a")
+	assert.False(t, ok)
+	assert.NotSame(t, a, r.GetOrCreate("a", Config{}))
+}
+
+func TestRegistryConcurrentGetOrCreate(t *testing.T) {
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	breakers := make([]*CircuitBreaker, 100)
+	for i := range breakers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			breakers[i] = r.GetOrCreate(fmt.Sprintf("cb-%d", i%2), Config{})
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2, len(r.All()))
+	for i, cb := range breakers {
+		assert.Same(t, breakers[i%2], cb)
+	}
+}
+
+func TestRegistryForEach(t *testing.T) {
+	r := NewRegistry()
+	a := r.GetOrCreate("a", Config{})
+	b := r.GetOrCreate("b", Config{})
+
+	// the registry can be used from within the callback
+	visited := make(map[string]*CircuitBreaker)
+	r.ForEach(func(name string, cb *CircuitBreaker) {
+		visited[name] = cb
+		r.Remove(name)
+		r.GetOrCreate(name+"-new", Config{})
+	})
+	assert.Equal(t, map[string]*CircuitBreaker{"a": a, "b": b}, visited)
+	assert.Equal(t, 2, len(r.All()))
+}
+
+func TestRegistryResetOpen(t *testing.T) {
+	r := NewRegistry()
+	open := r.GetOrCreate("open", Config{})
+	open.ForceOpen()
+	halfOpen := r.GetOrCreate("half-open", Config{})
+	halfOpen.ForceOpen()
+	pseudoSleep(halfOpen, time.Duration(60)*time.Second)
+	closed := r.GetOrCreate("closed", Config{})
+	assert.Nil(t, fail(closed))
+
+	r.ResetOpen()
+	assert.Equal(t, StateClosed, open.State())
+	assert.Equal(t, StateHalfOpen, halfOpen.State())
+	assert.Equal(t, StateClosed, closed.State())
+	assert.Equal(t, Counts{1, 0, 1, 0, 1, 0}, closed.Counts())
+}
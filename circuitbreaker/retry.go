@@ -0,0 +1,98 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy is how DoWithRetry retries a failed request
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, the first one included. If it
+	// is 0, it defaults to 3
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry. If it is 0, it
+	// defaults to 100 milliseconds
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait between attempts. If it is 0, there's no cap
+	MaxBackoff time.Duration
+
+	// Multiplier is what the wait is multiplied by after every retry. If it
+	// is less than 1, it defaults to 2
+	Multiplier float64
+
+	// Jitter, in the range [0, 1], is the fraction of each wait that's drawn
+	// at random, so that callers that failed together don't retry together:
+	// with 0.5, a wait of 100ms becomes anything between 50ms and 100ms
+	Jitter float64
+}
+
+func (p *RetryPolicy) setDefaults() {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = time.Duration(100) * time.Millisecond
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	} else if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+}
+
+// backoff returns the wait before the given retry, the first one being 1
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	wait := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		wait *= p.Multiplier
+		if p.MaxBackoff > 0 && wait >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+		wait = float64(p.MaxBackoff)
+	}
+	wait -= wait * p.Jitter * rand.Float64()
+	return time.Duration(wait)
+}
+
+// DoWithRetry runs req through cb with DoContext, retrying it with
+// exponential backoff as long as it fails. Only errors that count as failures,
+// as classified by Classify or IsSuccessful, are retried. Retries stop right
+// away once cb rejects an attempt, since retrying a short circuit only adds
+// load, and once ctx is done, in which case ctx.Err() is returned. A request
+// that panicked isn't retried. Otherwise, the error of the last attempt is
+// returned. The backoff is timed on real time, not on the Clock
+func DoWithRetry(ctx context.Context, cb *CircuitBreaker, req func(ctx context.Context) (interface{}, error), policy RetryPolicy) (interface{}, error) {
+	policy.setDefaults()
+	for attempt := 1; ; attempt++ {
+		result, err := cb.DoContext(ctx, req)
+		if err == nil || attempt == policy.MaxAttempts || !cb.shouldRetry(err) {
+			return result, err
+		}
+		if wait := policy.backoff(attempt); wait > 0 {
+			err = waitFor(ctx, wait, nil)
+		} else {
+			err = ctx.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// shouldRetry reports whether a request that failed with err is worth
+// retrying
+func (cb *CircuitBreaker) shouldRetry(err error) bool {
+	if IsRejection(err) || errors.Is(err, ErrRequestPanicked) {
+		return false
+	}
+	return cb.classifyError(err) == OutcomeFailure
+}
@@ -0,0 +1,78 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxBackoff: time.Duration(300) * time.Millisecond}
+	p.setDefaults()
+	assert.Equal(t, 3, p.MaxAttempts)
+	assert.Equal(t, time.Duration(100)*time.Millisecond, p.backoff(1))
+	assert.Equal(t, time.Duration(200)*time.Millisecond, p.backoff(2))
+	assert.Equal(t, time.Duration(300)*time.Millisecond, p.backoff(3))
+	assert.Equal(t, time.Duration(300)*time.Millisecond, p.backoff(100))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		wait := p.backoff(1)
+		assert.GreaterOrEqual(t, wait, time.Duration(50)*time.Millisecond)
+		assert.LessOrEqual(t, wait, time.Duration(100)*time.Millisecond)
+	}
+}
+
+func TestDoWithRetry(t *testing.T) {
+	errFail := errors.New("fail")
+	errNotFound := errors.New("not found")
+	cb := NewCircuitBreaker(Config{
+		IsSuccessful: func(err error) bool {
+			return err == nil || err == errNotFound
+		},
+	})
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	var attempts int
+	failing := func(errs ...error) func(ctx context.Context) (interface{}, error) {
+		attempts = 0
+		return func(ctx context.Context) (interface{}, error) {
+			attempts++
+			if attempts <= len(errs) {
+				return nil, errs[attempts-1]
+			}
+			return attempts, nil
+		}
+	}
+
+	result, err := DoWithRetry(context.Background(), cb, failing(errFail, errFail), policy)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, result)
+
+	_, err = DoWithRetry(context.Background(), cb, failing(errFail, errFail, errFail), policy)
+	assert.Equal(t, errFail, err)
+	assert.Equal(t, 3, attempts)
+
+	// a success as far as the breaker is concerned isn't retried
+	_, err = DoWithRetry(context.Background(), cb, failing(errNotFound), policy)
+	assert.Equal(t, errNotFound, err)
+	assert.Equal(t, 1, attempts)
+
+	// nor is a short circuit
+	cb.Reset()
+	policy.MaxAttempts = 10
+	_, err = DoWithRetry(context.Background(), cb, failing(errFail, errFail, errFail, errFail, errFail, errFail, errFail), policy)
+	assert.ErrorIs(t, err, ErrOpenState)
+	assert.Equal(t, 6, attempts)
+
+	// bounded by ctx
+	cb.Reset()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(20)*time.Millisecond)
+	defer cancel()
+	policy.InitialBackoff = time.Hour
+	_, err = DoWithRetry(ctx, cb, failing(errFail), policy)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,73 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"time"
+)
+
+// Severity grades a failure by how long the dependency is likely to take to
+// recover from it
+type Severity int
+
+const (
+	// SeverityNormal failures keep the CircuitBreaker open for
+	// TimeoutOpenState
+	SeverityNormal Severity = iota
+
+	// SeverityTransient failures, e.g. a 503, are likely to clear up soon.
+	// They keep the CircuitBreaker open for half of TimeoutOpenState
+	SeverityTransient
+
+	// SeveritySevere failures, e.g. a persistent 500, point to a deeper
+	// problem. They keep the CircuitBreaker open for twice TimeoutOpenState
+	SeveritySevere
+)
+
+// String implements the stringer interface
+func (s Severity) String() string {
+	switch s {
+	case SeverityNormal:
+		return "normal"
+	case SeverityTransient:
+		return "transient"
+	case SeveritySevere:
+		return "severe"
+	default:
+		return fmt.Sprintf("unknown severity: %d", s)
+	}
+}
+
+// scale adjusts the open-state timeout according to the severity
+func (s Severity) scale(timeout time.Duration) time.Duration {
+	switch s {
+	case SeverityTransient:
+		return timeout / 2
+	case SeveritySevere:
+		return timeout * 2
+	default:
+		return timeout
+	}
+}
+
+func (cb *CircuitBreaker) severityOf(err error) Severity {
+	if cb.failureSeverity == nil || err == nil {
+		return SeverityNormal
+	}
+	return cb.failureSeverity(err)
+}
+
+func (cb *CircuitBreaker) weightOf(err error) float64 {
+	if cb.failureWeight == nil || err == nil {
+		return 1
+	}
+	return cb.failureWeight(err)
+}
+
+// failureWeight is the weight the outcome adds to TripContext.WeightedFailures
+// if it's a failure
+func (o outcome) failureWeight() float64 {
+	if o.weight <= 0 {
+		return 1
+	}
+	return o.weight
+}
@@ -0,0 +1,102 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeverityConstants(t *testing.T) {
+	assert.Equal(t, "normal", SeverityNormal.String())
+	assert.Equal(t, "transient", SeverityTransient.String())
+	assert.Equal(t, "severe", SeveritySevere.String())
+	assert.Equal(t, "unknown severity: 100", Severity(100).String())
+}
+
+func TestFailureSeverity(t *testing.T) {
+	errTransient := errors.New("unavailable")
+	errSevere := errors.New("internal")
+	newCB := func() *CircuitBreaker {
+		return NewCircuitBreaker(Config{
+			ConsecutiveFailureThreshold: 1,
+			FailureSeverity: func(err error) Severity {
+				switch err {
+				case errTransient:
+					return SeverityTransient
+				case errSevere:
+					return SeveritySevere
+				}
+				return SeverityNormal
+			},
+		})
+	}
+	failWith := func(cb *CircuitBreaker, err error) {
+		_, _ = cb.Do(func() (interface{}, error) { return nil, err })
+		_, _ = cb.Do(func() (interface{}, error) { return nil, err })
+		assert.Equal(t, StateOpen, cb.State())
+	}
+	openFor := func(cb *CircuitBreaker) time.Duration {
+		return time.Until(cb.expiry).Round(time.Second)
+	}
+
+	transient, normal, severe := newCB(), newCB(), newCB()
+	failWith(transient, errTransient)
+	failWith(normal, errors.New("other"))
+	failWith(severe, errSevere)
+	assert.Equal(t, time.Duration(30)*time.Second, openFor(transient))
+	assert.Equal(t, time.Duration(60)*time.Second, openFor(normal))
+	assert.Equal(t, time.Duration(120)*time.Second, openFor(severe))
+
+	// the failing probe decides how long to reopen for
+	pseudoSleep(severe, time.Duration(120)*time.Second)
+	assert.Equal(t, StateHalfOpen, severe.State())
+	_, _ = severe.Do(func() (interface{}, error) { return nil, errTransient })
+	assert.Equal(t, StateOpen, severe.State())
+	assert.Equal(t, time.Duration(30)*time.Second, openFor(severe))
+}
+
+func TestFailureWeight(t *testing.T) {
+	errTimeout, errFail := errors.New("timeout"), errors.New("fail")
+	var weighted []float64
+	cb := NewCircuitBreaker(Config{
+		FailureWeight: func(err error) float64 {
+			switch err {
+			case errTimeout:
+				return 3
+			case errFail:
+				return 0.5
+			}
+			return 0
+		},
+		ShouldTripContext: func(tc TripContext) bool {
+			weighted = append(weighted, tc.WeightedFailures)
+			return tc.WeightedFailures >= 5
+		},
+	})
+	failWith := func(err error) {
+		_, _ = cb.Do(func() (interface{}, error) { return nil, err })
+	}
+
+	failWith(errFail)
+	failWith(errors.New("other"))
+	assert.Nil(t, succeed(cb))
+	failWith(errTimeout)
+	assert.Equal(t, []float64{0.5, 1.5, 4.5}, weighted)
+	assert.Equal(t, Counts{4, 0, 1, 1, 3, 0}, cb.Counts())
+
+	// a dry run doesn't add to the weight
+	assert.True(t, cb.WouldTripOn(false))
+	assert.Equal(t, StateClosed, cb.State())
+	failWith(errFail)
+	assert.Equal(t, []float64{0.5, 1.5, 4.5, 5.5, 5}, weighted)
+	assert.Equal(t, StateOpen, cb.State())
+
+	// the weight starts over with the generation
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	failWith(errTimeout)
+	assert.Equal(t, float64(3), weighted[len(weighted)-1])
+}
@@ -0,0 +1,70 @@
+package circuitbreaker
+
+import "sync/atomic"
+
+// ShadowDivergence counts how often the shadow policies disagreed with the
+// active ones. The shadow policies never affect the CircuitBreaker, so these
+// are the only trace they leave
+type ShadowDivergence struct {
+	// ShadowOnlyTrips is the number of times ShadowShouldTrip would have
+	// tripped when ShouldTrip didn't. A shadow policy that would trip keeps
+	// being consulted with counts that a real trip would have cleared, so a
+	// sustained disagreement counts every further failure
+	ShadowOnlyTrips uint64
+
+	// ActiveOnlyTrips is the number of times ShouldTrip tripped when
+	// ShadowShouldTrip wouldn't have
+	ActiveOnlyTrips uint64
+
+	// Classifications is the number of requests ShadowIsSuccessful classified
+	// differently from IsSuccessful
+	Classifications uint64
+}
+
+// shadowDivergence holds the live counters behind ShadowDivergence
+type shadowDivergence struct {
+	// trips is guarded by the mutex
+	trips struct {
+		shadowOnly uint64
+		activeOnly uint64
+	}
+	classifications atomic.Uint64
+}
+
+// evaluateShadowTrip runs ShadowShouldTrip with the counts ShouldTrip just
+// saw. It must be called with the mutex held
+func (cb *CircuitBreaker) evaluateShadowTrip(counts Counts, trip bool) {
+	if cb.shadowShouldTrip == nil {
+		return
+	}
+	shadowTrip := cb.shadowShouldTrip(counts)
+	if shadowTrip && !trip {
+		cb.shadow.trips.shadowOnly++
+	} else if trip && !shadowTrip {
+		cb.shadow.trips.activeOnly++
+	}
+}
+
+// evaluateShadowClassification runs ShadowIsSuccessful on a request's error
+func (cb *CircuitBreaker) evaluateShadowClassification(err error, success bool) {
+	if cb.shadowIsSuccessful == nil {
+		return
+	}
+	if cb.shadowIsSuccessful(err) != success {
+		cb.shadow.classifications.Add(1)
+	}
+}
+
+// ShadowDivergence returns how often the shadow policies have disagreed with
+// the active ones since the CircuitBreaker was created
+func (cb *CircuitBreaker) ShadowDivergence() ShadowDivergence {
+	cb.lock()
+	defer cb.unlock()
+
+	cb.currentState(cb.now())
+	return ShadowDivergence{
+		ShadowOnlyTrips: cb.shadow.trips.shadowOnly,
+		ActiveOnlyTrips: cb.shadow.trips.activeOnly,
+		Classifications: cb.shadow.classifications.Load(),
+	}
+}
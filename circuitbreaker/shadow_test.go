@@ -0,0 +1,58 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShadowPolicies(t *testing.T) {
+	errNotFound := errors.New("not found")
+	var stateChanges int
+	cb := NewCircuitBreaker(Config{
+		ShadowShouldTrip: func(counts Counts) bool {
+			return counts.ConsecutiveFailures > 2
+		},
+		ShadowIsSuccessful: func(err error) bool {
+			return err == nil || err == errNotFound
+		},
+		OnStateChange: func(from State, to State) {
+			stateChanges++
+		},
+	})
+	assert.Equal(t, ShadowDivergence{}, cb.ShadowDivergence())
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.False(t, cb.WouldTripOn(false)) // dry runs aren't counted
+	assert.Equal(t, ShadowDivergence{ShadowOnlyTrips: 1}, cb.ShadowDivergence())
+
+	_, err := cb.Do(func() (interface{}, error) { return nil, errNotFound })
+	assert.Equal(t, errNotFound, err)
+	assert.Equal(t, ShadowDivergence{ShadowOnlyTrips: 2, Classifications: 1}, cb.ShadowDivergence())
+
+	// the shadow never affects the breaker
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, 0, stateChanges)
+	assert.Equal(t, Counts{4, 0, 4, 0, 4, 0}, cb.Counts())
+
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, ShadowDivergence{ShadowOnlyTrips: 3, Classifications: 1}, cb.ShadowDivergence())
+}
+
+func TestShadowActiveOnlyTrips(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		ConsecutiveFailureThreshold: 1,
+		ShadowShouldTrip: func(counts Counts) bool {
+			return false
+		},
+	})
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, ShadowDivergence{ActiveOnlyTrips: 1}, cb.ShadowDivergence())
+}
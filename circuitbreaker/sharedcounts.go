@@ -0,0 +1,94 @@
+package circuitbreaker
+
+import "sync/atomic"
+
+// SharedCounts aggregates the closed-state outcomes of a group of
+// CircuitBreakers guarding the same dependency, e.g. one per worker, so that
+// they trip together on the failures they see between them, see
+// Config.Counts. Implementations must be safe for concurrent use and may be
+// backed by an external store. Their methods are called with the mutex of the
+// calling CircuitBreaker held, so they should return quickly
+type SharedCounts interface {
+	// Record counts the outcome of a request completed while closed
+	Record(success bool)
+
+	// Snapshot returns the aggregate Counts. CurrRequests is the number of
+	// outcomes recorded since the last Reset
+	Snapshot() Counts
+
+	// Reset clears the aggregate Counts
+	Reset()
+}
+
+// atomicCounts is the in-memory SharedCounts returned by NewSharedCounts
+type atomicCounts struct {
+	consecutiveSuccesses atomic.Uint32
+	consecutiveFailures  atomic.Uint32
+	totalSuccesses       atomic.Uint32
+	totalFailures        atomic.Uint32
+}
+
+// NewSharedCounts returns an in-memory SharedCounts for CircuitBreakers in
+// the same process. It's lock-free, so a Snapshot taken while outcomes are
+// being recorded may be off by the outcomes in flight
+func NewSharedCounts() SharedCounts {
+	return &atomicCounts{}
+}
+
+func (c *atomicCounts) Record(success bool) {
+	if success {
+		c.totalSuccesses.Add(1)
+		c.consecutiveSuccesses.Add(1)
+		c.consecutiveFailures.Store(0)
+	} else {
+		c.totalFailures.Add(1)
+		c.consecutiveFailures.Add(1)
+		c.consecutiveSuccesses.Store(0)
+	}
+}
+
+func (c *atomicCounts) Snapshot() Counts {
+	counts := Counts{
+		ConsecutiveSuccesses: c.consecutiveSuccesses.Load(),
+		ConsecutiveFailures:  c.consecutiveFailures.Load(),
+		TotalSuccesses:       c.totalSuccesses.Load(),
+		TotalFailures:        c.totalFailures.Load(),
+	}
+	counts.CurrRequests = counts.TotalSuccesses + counts.TotalFailures
+	return counts
+}
+
+func (c *atomicCounts) Reset() {
+	c.consecutiveSuccesses.Store(0)
+	c.consecutiveFailures.Store(0)
+	c.totalSuccesses.Store(0)
+	c.totalFailures.Store(0)
+}
+
+// recordShared records the outcome of a closed-state request in the
+// SharedCounts, if any. Dry runs aren't recorded
+func (cb *CircuitBreaker) recordShared(state State, o outcome) {
+	if cb.sharedCounts != nil && state == StateClosed && !o.dryRun {
+		cb.sharedCounts.Record(o.success)
+	}
+}
+
+// sharedTripCounts returns the aggregate counts ShouldTrip is evaluated
+// against when the CircuitBreaker is part of a group. The failure being
+// evaluated by a dry run wasn't recorded so it's added here
+func (cb *CircuitBreaker) sharedTripCounts(dryRun bool) Counts {
+	counts := cb.sharedCounts.Snapshot()
+	if dryRun {
+		counts.CurrRequests++
+		counts.TotalFailures++
+		counts.ConsecutiveFailures++
+		counts.ConsecutiveSuccesses = 0
+	}
+	return counts
+}
+
+func (cb *CircuitBreaker) resetShared() {
+	if cb.sharedCounts != nil {
+		cb.sharedCounts.Reset()
+	}
+}
@@ -0,0 +1,69 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSharedCounts(t *testing.T) {
+	counts := NewSharedCounts()
+	counts.Record(true)
+	counts.Record(false)
+	counts.Record(false)
+	assert.Equal(t, Counts{3, 0, 2, 1, 2, 0}, counts.Snapshot())
+
+	counts.Record(true)
+	assert.Equal(t, Counts{4, 1, 0, 2, 2, 0}, counts.Snapshot())
+
+	counts.Reset()
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, counts.Snapshot())
+}
+
+func TestSharedCounts(t *testing.T) {
+	shared := NewSharedCounts()
+	cfg := Config{
+		Interval: time.Duration(30) * time.Second,
+		Counts:   shared,
+	}
+	a, b := NewCircuitBreaker(cfg), NewCircuitBreaker(cfg)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(a))
+		assert.Nil(t, fail(b))
+	}
+	// neither has seen more than 5 failures on its own
+	assert.Equal(t, Counts{3, 0, 3, 0, 3, 0}, a.Counts())
+	assert.Equal(t, StateOpen, b.State())
+	assert.Equal(t, StateClosed, a.State())
+
+	// a dry run sees the aggregate without recording into it
+	assert.True(t, a.WouldTripOn(false))
+	assert.Equal(t, Counts{6, 0, 6, 0, 6, 0}, shared.Snapshot())
+
+	// interval resets and trips don't clear the aggregate
+	pseudoSleep(a, time.Duration(31)*time.Second)
+	assert.Equal(t, StateClosed, a.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, a.Counts())
+	assert.Nil(t, fail(a))
+	assert.Equal(t, StateOpen, a.State())
+
+	// recovering clears it for the whole group
+	pseudoSleep(b, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(b))
+	assert.Equal(t, StateClosed, b.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, shared.Snapshot())
+
+	// half-open probes aren't recorded
+	pseudoSleep(a, time.Duration(60)*time.Second)
+	assert.Nil(t, fail(a))
+	assert.Equal(t, StateOpen, a.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, shared.Snapshot())
+
+	assert.Nil(t, succeed(b))
+	assert.Nil(t, fail(b))
+	assert.Equal(t, Counts{2, 0, 1, 1, 1, 0}, shared.Snapshot())
+	b.Reset()
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, shared.Snapshot())
+}
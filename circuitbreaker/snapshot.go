@@ -0,0 +1,116 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"time"
+)
+
+// Snapshot is the persistable state of a CircuitBreaker, see Snapshot and
+// RestoreCircuitBreaker
+type Snapshot struct {
+	State         State     `json:"state"`
+	Counts        Counts    `json:"counts"`
+	Generation    uint64    `json:"generation"`
+	Expiry        time.Time `json:"expiry"`
+	ProbeAttempts int       `json:"probe_attempts"`
+	ForceMode     ForceMode `json:"force_mode"`
+}
+
+// Snapshot returns the current state of the CircuitBreaker so that it can be
+// persisted, e.g. across restarts of a short-lived process. Sliding windows
+// and the RatioLastNRequests sample aren't part of it
+func (cb *CircuitBreaker) Snapshot() Snapshot {
+	cb.lock()
+	defer cb.unlock()
+
+	state, generation := cb.currentState(cb.now())
+	return Snapshot{
+		State:         state,
+		Counts:        cb.counts,
+		Generation:    generation,
+		Expiry:        cb.expiry,
+		ProbeAttempts: cb.probeAttempts,
+		ForceMode:     cb.forceMode,
+	}
+}
+
+// RestoreOption controls how RestoreCircuitBreaker picks up a snapshot
+type RestoreOption int
+
+const (
+	// RestoreAsHalfOpen sends a CircuitBreaker that was open straight to
+	// half-open instead of waiting out the rest of its open-state timeout,
+	// e.g. because the restarted process may well reach a healthy backend.
+	// It doesn't override ForceOpenIndefinitely or an exhausted
+	// MaxProbeAttempts budget
+	RestoreAsHalfOpen RestoreOption = iota + 1
+)
+
+// String implements stringer interface
+func (o RestoreOption) String() string {
+	switch o {
+	case RestoreAsHalfOpen:
+		return "as-half-open"
+	default:
+		return fmt.Sprintf("unknown restore option: %d", o)
+	}
+}
+
+// RestoreCircuitBreaker returns a new instance of CircuitBreaker with the
+// given configuration, picking up where the one snap was taken from left off.
+// OnStateChange isn't called for the restored state. An expiry that has passed
+// in the meantime takes effect on first use, e.g. an open CircuitBreaker whose
+// timeout is over becomes half-open. By default, an open CircuitBreaker
+// whose timeout isn't over stays open for the rest of it, see
+// RestoreAsHalfOpen.
+//
+// A snap that couldn't have been taken isn't trusted: an unknown state or
+// force mode is restored as closed or no override, with cleared counts, and a
+// missing expiry is recomputed from cfg, so that e.g. an open snap without
+// one doesn't leave the CircuitBreaker open until Reset.
+//
+// Requests that were in flight when snap was taken never complete, so their
+// half-open slots are handed back
+func RestoreCircuitBreaker(cfg Config, snap Snapshot, opts ...RestoreOption) *CircuitBreaker {
+	cb := NewCircuitBreaker(cfg)
+
+	cb.lock()
+	defer cb.unlock()
+
+	cb.state = snap.State
+	cb.counts = snap.Counts
+	cb.generation = snap.Generation
+	cb.expiry = snap.Expiry
+	cb.probeAttempts = snap.ProbeAttempts
+	cb.forceMode = snap.ForceMode
+	if !validForceMode(cb.forceMode) {
+		cb.forceMode = ForceModeNone
+	}
+	if !validState(cb.state) {
+		cb.state = StateClosed
+		cb.toNewGeneration(cb.now())
+	}
+	if cb.expiry.IsZero() {
+		cb.expiry = cb.generationExpiry(cb.now())
+	}
+	for _, opt := range opts {
+		if opt == RestoreAsHalfOpen && cb.state == StateOpen && !cb.expiry.IsZero() {
+			cb.probeAttempts++
+			cb.setState(StateHalfOpen, cb.now())
+		}
+	}
+	if cb.state == StateHalfOpen {
+		// a half-open request that completed without reopening succeeded
+		cb.counts.CurrRequests = cb.counts.ConsecutiveSuccesses
+	}
+	cb.publish()
+	return cb
+}
+
+func validState(s State) bool {
+	return s == StateClosed || s == StateHalfOpen || s == StateOpen
+}
+
+func validForceMode(m ForceMode) bool {
+	return m >= ForceModeNone && m <= ForceModeClosed
+}
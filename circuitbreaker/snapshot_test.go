@@ -0,0 +1,164 @@
+package circuitbreaker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	stateChange := stateChangeTracker{}
+	cb := newCustom(nil)
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+
+	snap := cb.Snapshot()
+	assert.Equal(t, StateClosed, snap.State)
+	assert.Equal(t, Counts{2, 0, 1, 1, 1, 0}, snap.Counts)
+	assert.Equal(t, cb.generation, snap.Generation)
+	assert.Equal(t, cb.expiry, snap.Expiry)
+
+	// round trips through JSON
+	b, err := json.Marshal(snap)
+	assert.Nil(t, err)
+	var decoded Snapshot
+	assert.Nil(t, json.Unmarshal(b, &decoded))
+	assert.True(t, snap.Expiry.Equal(decoded.Expiry))
+	decoded.Expiry = snap.Expiry
+	assert.Equal(t, snap, decoded)
+
+	cfg := Config{
+		MaxRequestsWhileHalfOpen: 3,
+		Interval:                 time.Duration(30) * time.Second,
+		TimeoutOpenState:         time.Duration(90) * time.Second,
+		OnStateChange: func(from, to State) {
+			stateChange = stateChangeTracker{from, to}
+		},
+	}
+	restored := RestoreCircuitBreaker(cfg, snap)
+	assert.Equal(t, StateClosed, restored.State())
+	assert.Equal(t, snap.Counts, restored.Counts())
+	assert.Equal(t, snap.Generation, restored.generation)
+	assert.Equal(t, stateChangeTracker{}, stateChange)
+}
+
+func TestRestoreExpiredOpen(t *testing.T) {
+	stateChange := stateChangeTracker{}
+	cb := NewCircuitBreaker(Config{})
+	cb.ForceOpen()
+	snap := cb.Snapshot()
+	assert.Equal(t, StateOpen, snap.State)
+
+	// the timeout ran out while the process was down
+	snap.Expiry = time.Now().Add(-time.Second)
+	restored := RestoreCircuitBreaker(Config{
+		OnStateChange: func(from, to State) {
+			stateChange = stateChangeTracker{from, to}
+		},
+	}, snap)
+	assert.Equal(t, StateHalfOpen, restored.State())
+	assert.Equal(t, stateChangeTracker{StateOpen, StateHalfOpen}, stateChange)
+	assert.Equal(t, snap.ProbeAttempts+1, restored.probeAttempts)
+}
+
+func TestRestoreHalfOpenInFlight(t *testing.T) {
+	cb := NewCircuitBreaker(Config{MaxRequestsWhileHalfOpen: 2})
+	cb.ForceOpen()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+
+	// one probe in flight when the snapshot is taken
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	snap := cb.Snapshot()
+	assert.Nil(t, <-ch)
+	assert.Equal(t, StateHalfOpen, snap.State)
+	assert.Equal(t, Counts{2, 1, 0, 1, 0, 0}, snap.Counts)
+
+	// its slot is handed back, so the restored breaker can close
+	restored := RestoreCircuitBreaker(Config{MaxRequestsWhileHalfOpen: 2}, snap)
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, restored.Counts())
+	assert.Nil(t, succeed(restored))
+	assert.Equal(t, StateClosed, restored.State())
+}
+
+func TestRestoreForceOpenIndefinitely(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	cb.ForceOpenIndefinitely()
+	snap := cb.Snapshot()
+	assert.Equal(t, ForceModeOpenIndefinitely, snap.ForceMode)
+
+	b, err := json.Marshal(snap)
+	assert.Nil(t, err)
+	var decoded Snapshot
+	assert.Nil(t, json.Unmarshal(b, &decoded))
+
+	// stays open past the timeout, until the override is cleared
+	restored := RestoreCircuitBreaker(Config{}, decoded)
+	assert.Equal(t, ForceModeOpenIndefinitely, restored.ForceMode())
+	assert.True(t, restored.expiry.IsZero())
+	pseudoSleep(restored, time.Duration(61)*time.Second)
+	assert.Equal(t, StateOpen, restored.State())
+	restored.ClearForce()
+	assert.Equal(t, ForceModeNone, restored.ForceMode())
+}
+
+func TestRestoreInvalidSnapshot(t *testing.T) {
+	// open without an expiry
+	snap := Snapshot{State: StateOpen, Generation: 3}
+	restored := RestoreCircuitBreaker(Config{}, snap)
+	assert.Equal(t, StateOpen, restored.State())
+	assert.False(t, restored.expiry.IsZero())
+	pseudoSleep(restored, time.Duration(61)*time.Second)
+	assert.Equal(t, StateHalfOpen, restored.State())
+
+	// closed without an expiry despite an interval
+	snap = Snapshot{State: StateClosed}
+	restored = RestoreCircuitBreaker(Config{Interval: time.Duration(30) * time.Second}, snap)
+	assert.False(t, restored.expiry.IsZero())
+
+	// unknown state and force mode
+	snap = Snapshot{State: State(7), Counts: Counts{5, 0, 5, 0, 5, 0}, Generation: 3, ForceMode: ForceMode(9)}
+	restored = RestoreCircuitBreaker(Config{}, snap)
+	assert.Equal(t, StateClosed, restored.State())
+	assert.Equal(t, ForceModeNone, restored.ForceMode())
+	assert.Equal(t, Counts{}, restored.Counts())
+	assert.Equal(t, uint64(4), restored.generation)
+}
+
+func TestRestoreAsHalfOpen(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	cb.ForceOpen()
+	snap := cb.Snapshot()
+
+	// by default, the rest of the timeout is respected
+	restored := RestoreCircuitBreaker(Config{}, snap)
+	assert.Equal(t, StateOpen, restored.State())
+	assert.Equal(t, snap.Expiry, restored.expiry)
+
+	stateChange := stateChangeTracker{}
+	restored = RestoreCircuitBreaker(Config{
+		OnStateChange: func(from, to State) {
+			stateChange = stateChangeTracker{from, to}
+		},
+	}, snap, RestoreAsHalfOpen)
+	assert.Equal(t, StateHalfOpen, restored.State())
+	assert.Equal(t, stateChangeTracker{StateOpen, StateHalfOpen}, stateChange)
+	assert.Equal(t, snap.ProbeAttempts+1, restored.probeAttempts)
+	assert.Nil(t, succeed(restored))
+	assert.Equal(t, StateClosed, restored.State())
+
+	// manual overrides still hold
+	cb.ForceOpenIndefinitely()
+	restored = RestoreCircuitBreaker(Config{}, cb.Snapshot(), RestoreAsHalfOpen)
+	assert.Equal(t, StateOpen, restored.State())
+
+	// closed breakers are unaffected
+	restored = RestoreCircuitBreaker(Config{}, NewCircuitBreaker(Config{}).Snapshot(), RestoreAsHalfOpen)
+	assert.Equal(t, StateClosed, restored.State())
+
+	assert.Equal(t, "as-half-open", RestoreAsHalfOpen.String())
+	assert.Equal(t, "unknown restore option: 0", RestoreOption(0).String())
+}
@@ -0,0 +1,72 @@
+package circuitbreaker
+
+// stateChange is a transition waiting to be delivered to OnStateChange
+type stateChange struct {
+	from State
+	to   State
+}
+
+// startDispatcher starts the goroutine that delivers transitions to
+// OnStateChange when AsyncStateChange is set
+func (cb *CircuitBreaker) startDispatcher(queueSize int) {
+	cb.stateChanges = make(chan stateChange, queueSize)
+	cb.dispatcherDone = make(chan struct{})
+	go func() {
+		defer close(cb.dispatcherDone)
+		for sc := range cb.stateChanges {
+			cb.onStateChange(sc.from, sc.to)
+		}
+	}()
+}
+
+// notifyStateChange calls OnStateChange directly or queues the transition for
+// the dispatcher. It must be called with the mutex held, which also makes it
+// the only sender on the queue
+func (cb *CircuitBreaker) notifyStateChange(from State, to State) {
+	if cb.stateChanges == nil {
+		cb.onStateChange(from, to)
+		return
+	}
+	if cb.closed {
+		return
+	}
+
+	sc := stateChange{from, to}
+	for {
+		select {
+		case cb.stateChanges <- sc:
+			return
+		default:
+		}
+
+		// queue is full, drop the oldest transition
+		select {
+		case <-cb.stateChanges:
+		default:
+		}
+	}
+}
+
+// Close stops the goroutine delivering OnStateChange calls when
+// AsyncStateChange is set, after delivering all transitions queued so far,
+// and closes the Events channel. Transitions that occur after Close are not
+// delivered. Close is safe to call more than once
+func (cb *CircuitBreaker) Close() {
+	cb.lock()
+	if cb.closed {
+		cb.mu.Unlock()
+		return
+	}
+	cb.closed = true
+	if cb.stateChanges != nil {
+		close(cb.stateChanges)
+	}
+	if cb.events != nil {
+		close(cb.events)
+	}
+	cb.mu.Unlock()
+
+	if cb.dispatcherDone != nil {
+		<-cb.dispatcherDone
+	}
+}
@@ -0,0 +1,76 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsyncStateChange(t *testing.T) {
+	var mu sync.Mutex
+	var changes []stateChangeTracker
+	cb := NewCircuitBreaker(Config{
+		AsyncStateChange: true,
+		OnStateChange: func(from State, to State) {
+			time.Sleep(time.Duration(100) * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, stateChangeTracker{from, to})
+		},
+	})
+
+	// a slow callback doesn't delay Do
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Less(t, time.Since(start), time.Duration(100)*time.Millisecond)
+
+	// Close delivers everything queued so far, in order
+	cb.Close()
+	assert.Equal(t, []stateChangeTracker{
+		{StateClosed, StateOpen},
+		{StateOpen, StateHalfOpen},
+		{StateHalfOpen, StateClosed},
+	}, changes)
+
+	// transitions after Close aren't delivered
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Len(t, changes, 3)
+	cb.Close()
+}
+
+func TestAsyncStateChangeDropsOldest(t *testing.T) {
+	release := make(chan struct{})
+	var changes []stateChangeTracker
+	cb := NewCircuitBreaker(Config{
+		AsyncStateChange:     true,
+		StateChangeQueueSize: 1,
+		OnStateChange: func(from State, to State) {
+			<-release
+			changes = append(changes, stateChangeTracker{from, to})
+		},
+	})
+
+	// the dispatcher holds the first transition, the queue the next one
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb)) // open -> half-open -> closed
+
+	close(release)
+	cb.Close()
+	assert.Equal(t, []stateChangeTracker{
+		{StateClosed, StateOpen},
+		{StateHalfOpen, StateClosed},
+	}, changes)
+}
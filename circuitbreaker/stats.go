@@ -0,0 +1,98 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Stats holds lifetime totals for a CircuitBreaker. Unlike Counts, they're
+// never cleared, not by state changes, interval resets nor Reset, so they
+// suit monitoring that takes its own deltas, e.g. Prometheus counters
+type Stats struct {
+	// Requests is the number of requests admitted
+	Requests uint64 `json:"requests"`
+
+	// Successes is the number of requests counted as successes
+	Successes uint64 `json:"successes"`
+
+	// Failures is the number of requests counted as failures
+	Failures uint64 `json:"failures"`
+
+	// Trips is the number of times the CircuitBreaker went to the open
+	// state, from closed or half-open
+	Trips uint64 `json:"trips"`
+
+	// Rejections is the number of requests rejected, for whichever reason
+	Rejections uint64 `json:"rejections"`
+}
+
+// lifetimeStats backs Stats. It's updated with the mutex held but read
+// without it
+type lifetimeStats struct {
+	requests   atomic.Uint64
+	successes  atomic.Uint64
+	failures   atomic.Uint64
+	trips      atomic.Uint64
+	rejections atomic.Uint64
+}
+
+// Stats returns the lifetime totals of the CircuitBreaker. It doesn't take the
+// mutex, so the totals may be a request apart from each other
+func (cb *CircuitBreaker) Stats() Stats {
+	return Stats{
+		Requests:   cb.stats.requests.Load(),
+		Successes:  cb.stats.successes.Load(),
+		Failures:   cb.stats.failures.Load(),
+		Trips:      cb.stats.trips.Load(),
+		Rejections: cb.stats.rejections.Load(),
+	}
+}
+
+// recordStats adds a counted outcome to the lifetime totals. Dry runs aren't
+// counted
+func (cb *CircuitBreaker) recordStats(o outcome) {
+	switch {
+	case o.dryRun:
+	case o.success:
+		cb.stats.successes.Add(1)
+	default:
+		cb.stats.failures.Add(1)
+	}
+}
+
+// StatsCursor takes deltas of the Stats of a CircuitBreaker for a single
+// consumer, e.g. a pull-based metrics scraper that wants the number of
+// requests since its last scrape rather than lifetime totals. Each consumer
+// needs a cursor of its own: consumers sharing one would split the deltas
+// between them. It's safe for concurrent use
+type StatsCursor struct {
+	cb *CircuitBreaker
+
+	mu   sync.Mutex
+	prev Stats
+}
+
+// NewStatsCursor returns a StatsCursor whose first delta covers what happens
+// from now on
+func (cb *CircuitBreaker) NewStatsCursor() *StatsCursor {
+	return &StatsCursor{cb: cb, prev: cb.Stats()}
+}
+
+// StatsDelta returns how much the Stats have grown since the last call, or
+// since the StatsCursor was created. Since the totals are unsigned, a delta
+// stays correct even if a total wraps around in between
+func (c *StatsCursor) StatsDelta() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	curr := c.cb.Stats()
+	delta := Stats{
+		Requests:   curr.Requests - c.prev.Requests,
+		Successes:  curr.Successes - c.prev.Successes,
+		Failures:   curr.Failures - c.prev.Failures,
+		Trips:      curr.Trips - c.prev.Trips,
+		Rejections: curr.Rejections - c.prev.Rejections,
+	}
+	c.prev = curr
+	return delta
+}
@@ -0,0 +1,62 @@
+package circuitbreaker
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	cb := NewCircuitBreaker(Config{Interval: time.Duration(30) * time.Second})
+	assert.Equal(t, Stats{}, cb.Stats())
+
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	pseudoSleep(cb, time.Duration(31)*time.Second)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+	assert.Equal(t, Stats{Requests: 2, Successes: 1, Failures: 1}, cb.Stats())
+
+	// dry runs aren't counted
+	assert.False(t, cb.WouldTripOn(false))
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.ErrorIs(t, succeed(cb), ErrOpenState)
+	assert.Equal(t, Stats{Requests: 8, Successes: 1, Failures: 7, Trips: 1, Rejections: 1}, cb.Stats())
+
+	// a failed probe goes back to open
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, fail(cb))
+	cb.Reset()
+	assert.Equal(t, Stats{Requests: 9, Successes: 1, Failures: 8, Trips: 2, Rejections: 1}, cb.Stats())
+}
+
+func TestStatsDelta(t *testing.T) {
+	cb := NewCircuitBreaker(Config{})
+	assert.Nil(t, succeed(cb))
+
+	// what happened before a cursor was created isn't part of its deltas
+	a := cb.NewStatsCursor()
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	b := cb.NewStatsCursor()
+	assert.Equal(t, Stats{Requests: 2, Successes: 1, Failures: 1}, a.StatsDelta())
+	assert.Equal(t, Stats{}, a.StatsDelta())
+
+	// each cursor has its own deltas
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.ErrorIs(t, succeed(cb), ErrOpenState)
+	assert.Equal(t, Stats{Requests: 5, Failures: 5, Trips: 1, Rejections: 1}, a.StatsDelta())
+	assert.Equal(t, Stats{Requests: 5, Failures: 5, Trips: 1, Rejections: 1}, b.StatsDelta())
+
+	// a total that wraps around still yields the right delta
+	cb.stats.requests.Store(math.MaxUint64)
+	a.prev.Requests = math.MaxUint64 - 1
+	cb.stats.requests.Add(2)
+	assert.Equal(t, uint64(3), a.StatsDelta().Requests)
+}
@@ -0,0 +1,82 @@
+package circuitbreaker
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+)
+
+// response is what a request running on its own goroutine hands back
+type response[T any] struct {
+	result   T
+	err      error
+	panicked bool
+	panic    interface{}
+	stack    []byte
+}
+
+// doRequestWithTimeout runs an admitted request on its own goroutine and waits
+// up to RequestTimeout for it. A request that times out is recorded as a
+// failure right away, whatever it eventually returns is dropped. A panic in a
+// request that hasn't timed out is recorded as a failure and raised again on
+// the caller's goroutine, or returned as a PanicError with RecoverPanics. A
+// panic after the timeout is swallowed
+func doRequestWithTimeout[T any](cb *CircuitBreaker, ctx context.Context, generation uint64, req func(ctx context.Context) (T, error)) (T, error) {
+	parent := ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	reqCtx, cancel := context.WithTimeout(parent, cb.requestTimeout)
+	defer cancel()
+
+	// buffered so that an abandoned request doesn't leak its goroutine
+	ch := make(chan response[T], 1)
+	start := cb.now()
+	go func() {
+		var resp response[T]
+		defer func() {
+			if e := recover(); e != nil {
+				resp.panicked, resp.panic = true, e
+				if cb.recoverPanics {
+					resp.stack = debug.Stack()
+				}
+			}
+			ch <- resp
+		}()
+		resp.result, resp.err = req(reqCtx)
+	}()
+
+	timer := time.NewTimer(cb.requestTimeout)
+	defer timer.Stop()
+
+	select {
+	case resp := <-ch:
+		if resp.panicked {
+			cb.afterRequest(generation, outcome{
+				success:  false,
+				duration: cb.since(start),
+				panicked: true,
+				err:      ErrRequestPanicked,
+			})
+			if !cb.recoverPanics {
+				panic(resp.panic)
+			}
+			var zero T
+			return zero, PanicError{Value: resp.panic, Stack: resp.stack}
+		}
+		cb.afterResponse(ctx, generation, boxResult(cb, resp.result), resp.err, start)
+		return resp.result, resp.err
+	case <-timer.C:
+		cb.afterRequest(generation, outcome{
+			success:  false,
+			duration: cb.since(start),
+			severity: cb.severityOf(ErrRequestTimeout),
+			weight:   cb.weightOf(ErrRequestTimeout),
+			err:      ErrRequestTimeout,
+			label:    labelFrom(ctx),
+			trace:    cb.traceOf(ctx),
+		})
+		var zero T
+		return zero, ErrRequestTimeout
+	}
+}
@@ -0,0 +1,71 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	cb := NewCircuitBreaker(Config{
+		ConsecutiveFailureThreshold: 1,
+		RequestTimeout:              time.Duration(50) * time.Millisecond,
+	})
+
+	result, err := cb.Do(func() (interface{}, error) { return 1, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result)
+
+	errFail := errors.New("fail")
+	_, err = cb.Do(func() (interface{}, error) { return nil, errFail })
+	assert.Equal(t, errFail, err)
+	assert.Equal(t, Counts{2, 0, 1, 1, 1, 0}, cb.Counts())
+
+	// the request sees the deadline
+	finished := make(chan struct{})
+	start := time.Now()
+	result, err = cb.DoContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		defer close(finished)
+		<-ctx.Done()
+		time.Sleep(time.Duration(50) * time.Millisecond)
+		return 2, nil
+	})
+	assert.Equal(t, ErrRequestTimeout, err)
+	assert.Nil(t, result)
+	assert.Less(t, time.Since(start), time.Duration(100)*time.Millisecond)
+	assert.Equal(t, StateOpen, cb.State())
+
+	// the abandoned request's result is discarded
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	<-finished
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+}
+
+func TestRequestTimeoutPanic(t *testing.T) {
+	cb := NewCircuitBreaker(Config{RequestTimeout: time.Second})
+	assert.PanicsWithValue(t, "oops", func() {
+		_, _ = cb.Do(func() (interface{}, error) {
+			panic("oops")
+		})
+	})
+	assert.Equal(t, Counts{1, 0, 1, 0, 1, 1}, cb.Counts())
+
+	// a panic after the timeout is swallowed
+	cb = NewCircuitBreaker(Config{RequestTimeout: time.Duration(10) * time.Millisecond})
+	panicked := make(chan struct{})
+	_, err := cb.Do(func() (interface{}, error) {
+		time.Sleep(time.Duration(50) * time.Millisecond)
+		close(panicked)
+		panic("oops")
+	})
+	assert.Equal(t, ErrRequestTimeout, err)
+	<-panicked
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.Equal(t, Counts{1, 0, 1, 0, 1, 0}, cb.Counts())
+}
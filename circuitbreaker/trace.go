@@ -0,0 +1,64 @@
+package circuitbreaker
+
+// TraceEvent describes the decision a CircuitBreaker made about a single
+// request
+type TraceEvent struct {
+	// Admitted is set if the request was let through. Otherwise Err holds
+	// the reason it was rejected and the outcome fields are zero
+	Admitted bool
+
+	// Err is ErrOpenState, ErrTooManyRequests, ErrTooManyConcurrent,
+	// ErrRateLimited, ErrRecovering, ErrSharedCountsUnavailable or
+	// ErrDraining for rejected requests
+	Err error
+
+	// State is the state the CircuitBreaker was in when the request was
+	// rejected or its outcome reported
+	State State
+
+	// Generation is the generation the request was admitted or rejected in
+	Generation uint64
+
+	// Success is the reported outcome of an admitted request
+	Success bool
+
+	// Ignored is set if the outcome was OutcomeIgnore, in which case Success
+	// is false but the request didn't count as a failure
+	Ignored bool
+
+	// Stale is set if the outcome was discarded because the generation the
+	// request was admitted in has since ended
+	Stale bool
+
+	// Transitioned is set if recording the outcome changed the state
+	Transitioned bool
+
+	// To is the state after the decision
+	To State
+}
+
+// traceRequest queues a TraceEvent to be delivered once the mutex is released.
+// It must be called with the mutex held
+func (cb *CircuitBreaker) traceRequest(event TraceEvent) {
+	if cb.trace == nil {
+		return
+	}
+	cb.pending = append(cb.pending, func() {
+		cb.trace(event)
+	})
+}
+
+// traceOutcome traces an admitted request whose outcome has just been
+// recorded, or discarded if it was from an earlier generation
+func (cb *CircuitBreaker) traceOutcome(state State, generation uint64, o outcome, stale bool) {
+	cb.traceRequest(TraceEvent{
+		Admitted:     true,
+		State:        state,
+		Generation:   generation,
+		Success:      o.success,
+		Ignored:      o.ignore,
+		Stale:        stale,
+		Transitioned: cb.state != state,
+		To:           cb.state,
+	})
+}
@@ -0,0 +1,44 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrace(t *testing.T) {
+	var events []TraceEvent
+	var cb *CircuitBreaker
+	cb = NewCircuitBreaker(Config{
+		ConsecutiveFailureThreshold: 1,
+		Interval:                    time.Duration(30) * time.Second,
+		Trace: func(event TraceEvent) {
+			// called outside the mutex
+			assert.Equal(t, event.To, cb.State())
+			events = append(events, event)
+		},
+	})
+
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb)) // trips
+	assert.Error(t, succeed(cb))
+	assert.Equal(t, []TraceEvent{
+		{Admitted: true, State: StateClosed, Generation: 1, Success: true, To: StateClosed},
+		{Admitted: true, State: StateClosed, Generation: 1, To: StateClosed},
+		{Admitted: true, State: StateClosed, Generation: 1, Transitioned: true, To: StateOpen},
+		{Err: RejectedError{State: StateOpen, Err: ErrOpenState}, State: StateOpen, Generation: 2, To: StateOpen},
+	}, events)
+
+	// outcomes from an earlier generation are stale
+	events = nil
+	cb.Reset()
+	ch := succeedLater(cb, time.Duration(100)*time.Millisecond)
+	time.Sleep(time.Duration(50) * time.Millisecond)
+	pseudoSleep(cb, time.Duration(30)*time.Second)
+	assert.Nil(t, <-ch)
+	assert.Equal(t, []TraceEvent{
+		{Admitted: true, State: StateClosed, Generation: 3, Success: true, Stale: true, To: StateClosed},
+	}, events)
+}
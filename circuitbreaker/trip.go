@@ -0,0 +1,97 @@
+package circuitbreaker
+
+import "time"
+
+// TripContext is what ShouldTripContext decides on
+type TripContext struct {
+	// Counts is what ShouldTrip would have been called with
+	Counts Counts
+
+	// State is the current state. It's always StateClosed since only failures
+	// in the closed state can trip the CircuitBreaker
+	State State
+
+	// PreviousState is the state the CircuitBreaker was in before the current
+	// one, e.g. StateHalfOpen if it just recovered. It's StateClosed if the
+	// CircuitBreaker never changed state
+	PreviousState State
+
+	// Generation is the current generation
+	Generation uint64
+
+	// ConsecutiveTrips is the number of times the CircuitBreaker tripped since
+	// it was last Reset or went through a whole closed-state generation
+	// without tripping, e.g. an Interval. A CircuitBreaker that keeps tripping
+	// soon after recovering has a growing count
+	ConsecutiveTrips uint32
+
+	// WeightedFailures is the sum of the weights of the failures in the
+	// current generation, see Config.FailureWeight. It's the number of
+	// failures if FailureWeight isn't set
+	WeightedFailures float64
+}
+
+// callShouldTrip consults ShouldTripContext if it's set, ShouldTripFunc if
+// that's set, and ShouldTrip otherwise. It also returns how long to stay open
+// for if ShouldTripFunc decided, 0 meaning the usual. It must be called with
+// the mutex held
+func (cb *CircuitBreaker) callShouldTrip(counts Counts) (bool, time.Duration) {
+	if cb.shouldTripContext == nil {
+		if cb.shouldTripFunc != nil {
+			return cb.shouldTripFunc(counts)
+		}
+		return cb.shouldTrip(counts), 0
+	}
+	return cb.shouldTripContext(TripContext{
+		Counts:           counts,
+		State:            cb.state,
+		PreviousState:    cb.prevState,
+		Generation:       cb.generation,
+		ConsecutiveTrips: cb.consecutiveTrips,
+		WeightedFailures: cb.weighted,
+	}), 0
+}
+
+// AnyShouldTrip returns a ShouldTrip that trips as soon as one of the
+// policies does, e.g. on either a consecutive-failure or an error-ratio
+// threshold. The policies are consulted in order and the rest are skipped
+// once one trips. Nil policies are ignored, and with none left it never trips
+func AnyShouldTrip(policies ...func(counts Counts) bool) func(counts Counts) bool {
+	policies = nonNilPolicies(policies)
+	return func(counts Counts) bool {
+		for _, policy := range policies {
+			if policy(counts) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AllShouldTrip returns a ShouldTrip that trips only if every one of the
+// policies does. The policies are consulted in order and the rest are skipped
+// once one doesn't trip. Nil policies are ignored, and with none left it
+// never trips, rather than always
+func AllShouldTrip(policies ...func(counts Counts) bool) func(counts Counts) bool {
+	policies = nonNilPolicies(policies)
+	return func(counts Counts) bool {
+		for _, policy := range policies {
+			if !policy(counts) {
+				return false
+			}
+		}
+		return len(policies) > 0
+	}
+}
+
+// nonNilPolicies returns a copy of policies without the nil ones, so that
+// later changes to the caller's slice don't affect the combined policy
+func nonNilPolicies(policies []func(counts Counts) bool) []func(counts Counts) bool {
+	var kept []func(counts Counts) bool
+	for _, policy := range policies {
+		if policy != nil {
+			kept = append(kept, policy)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,157 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldTripContext(t *testing.T) {
+	var seen []TripContext
+	cb := NewCircuitBreaker(Config{
+		Interval: time.Duration(30) * time.Second,
+		ShouldTrip: func(Counts) bool {
+			panic("not called when ShouldTripContext is set")
+		},
+		// trips on the 3rd consecutive failure, or the 1st right after
+		// recovering
+		ShouldTripContext: func(tc TripContext) bool {
+			seen = append(seen, tc)
+			if tc.PreviousState == StateHalfOpen {
+				return true
+			}
+			return tc.Counts.ConsecutiveFailures >= 3
+		},
+	})
+
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, TripContext{
+		Counts:           Counts{1, 0, 1, 0, 1, 0},
+		State:            StateClosed,
+		PreviousState:    StateClosed,
+		Generation:       1,
+		ConsecutiveTrips: 0,
+		WeightedFailures: 1,
+	}, seen[0])
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	// recovers, then trips on the first failure
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, TripContext{
+		Counts:           Counts{1, 0, 1, 0, 1, 0},
+		State:            StateClosed,
+		PreviousState:    StateHalfOpen,
+		Generation:       4,
+		ConsecutiveTrips: 1,
+		WeightedFailures: 1,
+	}, seen[len(seen)-1])
+
+	// a whole interval without tripping clears the consecutive trips
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	pseudoSleep(cb, time.Duration(31)*time.Second)
+	assert.Nil(t, fail(cb))
+	tc := seen[len(seen)-1]
+	assert.Equal(t, uint32(0), tc.ConsecutiveTrips)
+
+	// as does Reset
+	cb = NewCircuitBreaker(Config{
+		ShouldTripContext: func(tc TripContext) bool {
+			seen = append(seen, tc)
+			return true
+		},
+	})
+	assert.Nil(t, fail(cb))
+	cb.Reset()
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, uint32(0), seen[len(seen)-1].ConsecutiveTrips)
+	assert.Equal(t, StateOpen, seen[len(seen)-1].PreviousState)
+}
+
+func TestShouldTripFunc(t *testing.T) {
+	var openFor time.Duration
+	cb := NewCircuitBreaker(Config{
+		ShouldTrip: func(counts Counts) bool {
+			panic("not called when ShouldTripFunc is set")
+		},
+		ShouldTripFunc: func(counts Counts) (bool, time.Duration) {
+			return counts.ConsecutiveFailures >= 2, openFor
+		},
+	})
+	openUntil := func() time.Duration {
+		return time.Until(cb.expiry).Round(time.Second)
+	}
+
+	openFor = time.Duration(5) * time.Second
+	assert.Nil(t, fail(cb))
+	assert.False(t, cb.WouldTripOn(true))
+	assert.True(t, cb.WouldTripOn(false))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, openFor, openUntil())
+
+	// only for that open period, a failed probe reopens for the usual timeout
+	pseudoSleep(cb, openFor)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, time.Duration(60)*time.Second, openUntil())
+
+	// 0 means the usual timeout
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	openFor = 0
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, time.Duration(60)*time.Second, openUntil())
+}
+
+func TestAnyAllShouldTrip(t *testing.T) {
+	var calls []string
+	policy := func(name string, trip bool) func(counts Counts) bool {
+		return func(counts Counts) bool {
+			calls = append(calls, name)
+			return trip
+		}
+	}
+	yes, no := policy("yes", true), policy("no", false)
+	check := func(shouldTrip func(counts Counts) bool, want bool, wantCalls ...string) {
+		t.Helper()
+		calls = nil
+		assert.Equal(t, want, shouldTrip(Counts{}))
+		assert.Equal(t, wantCalls, calls)
+	}
+
+	check(AnyShouldTrip(no, yes, no), true, "no", "yes")
+	check(AnyShouldTrip(no, nil, no), false, "no", "no")
+	check(AnyShouldTrip(), false)
+	check(AnyShouldTrip(nil), false)
+
+	check(AllShouldTrip(yes, no, yes), false, "yes", "no")
+	check(AllShouldTrip(yes, nil, yes), true, "yes", "yes")
+	check(AllShouldTrip(), false)
+	check(AllShouldTrip(nil, nil), false)
+
+	// combined as a ShouldTrip
+	cb := NewCircuitBreaker(Config{
+		ShouldTrip: AnyShouldTrip(
+			func(counts Counts) bool { return counts.ConsecutiveFailures >= 2 },
+			func(counts Counts) bool { return counts.TotalFailures >= 3 },
+		),
+	})
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+}
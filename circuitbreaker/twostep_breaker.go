@@ -1,5 +1,11 @@
 package circuitbreaker
 
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
 // TwoStepCircuitBreaker provides the same functionality as a CircuitBreaker but
 // does not wrap a request, instead it checks whether a request can proceed and
 // excepts the caller to report the outcome in a separate step using a callback
@@ -15,6 +21,11 @@ func NewTwoStepCircuitBreaker(cfg Config) *TwoStepCircuitBreaker {
 	}
 }
 
+// Name returns the name of the TwoStepCircuitBreaker
+func (tscb *TwoStepCircuitBreaker) Name() string {
+	return tscb.cb.Name()
+}
+
 // State returns the current state
 func (tscb *TwoStepCircuitBreaker) State() State {
 	return tscb.cb.State()
@@ -25,16 +36,170 @@ func (tscb *TwoStepCircuitBreaker) Counts() Counts {
 	return tscb.cb.Counts()
 }
 
+// Reset returns the TwoStepCircuitBreaker to the closed state with cleared
+// counts, see CircuitBreaker.Reset
+func (tscb *TwoStepCircuitBreaker) Reset() {
+	tscb.cb.Reset()
+}
+
+// ClearConsecutive zeroes the consecutive counts, see
+// CircuitBreaker.ClearConsecutive
+func (tscb *TwoStepCircuitBreaker) ClearConsecutive() {
+	tscb.cb.ClearConsecutive()
+}
+
+// AllowContext is like Allow but first checks ctx: if it's already done,
+// AllowContext returns ctx.Err() without taking up a request
+func (tscb *TwoStepCircuitBreaker) AllowContext(ctx context.Context) (done func(success bool), err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return tscb.Allow()
+}
+
+// AllowDeadline is like AllowContext but also returns the deadline the
+// request is expected to be over by: the earlier of RequestTimeout from now and
+// ctx's deadline, or the zero time if there's neither, meaning no limit. If
+// done hasn't been called by the deadline, the request is recorded as a
+// failure, like a request that runs past RequestTimeout, and the later call to
+// done is a no-op
+func (tscb *TwoStepCircuitBreaker) AllowDeadline(ctx context.Context) (done func(success bool), deadline time.Time, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	cb := tscb.cb
+	_, generation, err := cb.beforeRequest()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// the deadline is on real time, like ctx's, see Config.Clock
+	start := cb.now()
+	if cb.requestTimeout > 0 {
+		deadline = time.Now().Add(cb.requestTimeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+
+	return cb.reportBy(generation, start, deadline), deadline, nil
+}
+
+// AllowWithTimeout is like Allow but if done hasn't been called within max,
+// the request is recorded as a failure, like a request that runs past
+// RequestTimeout, so that a caller that never reports back doesn't hold on to
+// a half-open slot forever. Only the first report counts: calling done after
+// the timeout, or more than once, is a no-op. If max is 0 or less, there's no
+// timeout but done is still idempotent
+func (tscb *TwoStepCircuitBreaker) AllowWithTimeout(max time.Duration) (done func(success bool), err error) {
+	_, generation, err := tscb.cb.beforeRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	start := tscb.cb.now()
+	var deadline time.Time
+	if max > 0 {
+		deadline = time.Now().Add(max)
+	}
+	return tscb.cb.reportBy(generation, start, deadline), nil
+}
+
+// reportBy returns the done callback of a two-step request admitted in the
+// given generation that's recorded as a failure if it isn't reported by the
+// deadline, which is on real time. A zero deadline means no limit. Only the
+// first report counts
+func (cb *CircuitBreaker) reportBy(generation uint64, start, deadline time.Time) func(success bool) {
+	var reported atomic.Bool
+	report := func(o outcome) {
+		if reported.CompareAndSwap(false, true) {
+			cb.afterRequest(generation, o)
+		}
+	}
+	var timer *time.Timer
+	if !deadline.IsZero() {
+		timer = time.AfterFunc(time.Until(deadline), func() {
+			report(outcome{
+				success:  false,
+				duration: cb.since(start),
+				severity: cb.severityOf(ErrRequestTimeout),
+				weight:   cb.weightOf(ErrRequestTimeout),
+				err:      ErrRequestTimeout,
+			})
+		})
+	}
+	return func(success bool) {
+		if timer != nil {
+			timer.Stop()
+		}
+		report(outcome{
+			success:  success,
+			duration: cb.since(start),
+		})
+	}
+}
+
+// DetailedCounts returns the same Counts as the underlying CircuitBreaker,
+// including the half-open probe counts, so that two-step and wrapped requests
+// can be compared directly
+func (tscb *TwoStepCircuitBreaker) DetailedCounts() Counts {
+	return tscb.cb.Counts()
+}
+
+// SubmitProbeResult feeds the result of a dedicated health check to the
+// half-open CircuitBreaker, see CircuitBreaker.SubmitProbeResult
+func (tscb *TwoStepCircuitBreaker) SubmitProbeResult(success bool) {
+	tscb.cb.SubmitProbeResult(success)
+}
+
 // Allow checks if a new request can proceed. It returns a callback that should
 // be used to register the success or failure in a separate step. If the circuit
 // breaker doesn't allow requests, it returns an error.
 func (tscb *TwoStepCircuitBreaker) Allow() (done func(success bool), err error) {
-	generation, err := tscb.cb.beforeRequest()
+	_, generation, err := tscb.cb.beforeRequest()
 	if err != nil {
 		return nil, err
 	}
 
+	start := tscb.cb.now()
 	return func(success bool) {
-		tscb.cb.afterRequest(generation, success)
+		tscb.cb.afterRequest(generation, outcome{
+			success:  success,
+			duration: tscb.cb.since(start),
+		})
+	}, nil
+}
+
+// AllowOutcome is like Allow but the returned callback takes the request's
+// Outcome, so that a request can be reported as neither a success nor a
+// failure, see OutcomeIgnore
+func (tscb *TwoStepCircuitBreaker) AllowOutcome() (done func(result Outcome), err error) {
+	_, generation, err := tscb.cb.beforeRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	start := tscb.cb.now()
+	return func(result Outcome) {
+		tscb.cb.afterRequest(generation, newOutcome(result, tscb.cb.since(start)))
+	}, nil
+}
+
+// AllowBulk is like Allow but for a request that fans out into several
+// operations. The request takes up a single slot, and the returned callback
+// reports how many of its operations succeeded and failed. While half-open,
+// the request is a single probe: it counts as a success if none of its
+// operations failed, and the slot is released once the callback is called. A
+// report with no outcomes at all counts as a single success
+func (tscb *TwoStepCircuitBreaker) AllowBulk() (done func(successes, failures uint32), err error) {
+	_, generation, err := tscb.cb.beforeRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	start := tscb.cb.now()
+	return func(successes, failures uint32) {
+		tscb.cb.afterBulkRequest(generation, successes, failures, tscb.cb.since(start))
 	}, nil
 }
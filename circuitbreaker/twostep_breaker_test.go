@@ -1,6 +1,7 @@
 package circuitbreaker
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -35,27 +36,27 @@ func TestTwoStepCircuitBreaker(t *testing.T) {
 	}
 
 	assert.Equal(t, StateClosed, tscb.State())
-	assert.Equal(t, Counts{5, 0, 5}, tscb.cb.counts)
+	assert.Equal(t, Counts{5, 0, 5, 0, 5, 0}, tscb.cb.counts)
 
 	assert.Nil(t, succeed2Step(tscb))
 	assert.Equal(t, StateClosed, tscb.State())
-	assert.Equal(t, Counts{6, 1, 0}, tscb.cb.counts)
+	assert.Equal(t, Counts{6, 1, 0, 1, 5, 0}, tscb.cb.counts)
 
 	assert.Nil(t, fail2Step(tscb))
 	assert.Equal(t, StateClosed, tscb.State())
-	assert.Equal(t, Counts{7, 0, 1}, tscb.cb.counts)
+	assert.Equal(t, Counts{7, 0, 1, 1, 6, 0}, tscb.cb.counts)
 
 	// StateClosed to StateOpen
 	for i := 0; i < 5; i++ {
 		assert.Nil(t, fail2Step(tscb)) // 6 consecutive failures
 	}
 	assert.Equal(t, StateOpen, tscb.State())
-	assert.Equal(t, Counts{0, 0, 0}, tscb.cb.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, tscb.cb.counts)
 	assert.False(t, tscb.cb.expiry.IsZero())
 
 	assert.Error(t, succeed2Step(tscb))
 	assert.Error(t, fail2Step(tscb))
-	assert.Equal(t, Counts{0, 0, 0}, tscb.cb.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, tscb.cb.counts)
 
 	pseudoSleep(tscb.cb, time.Duration(59)*time.Second)
 	assert.Equal(t, StateOpen, tscb.State())
@@ -68,7 +69,7 @@ func TestTwoStepCircuitBreaker(t *testing.T) {
 	// StateHalfOpen to StateOpen
 	assert.Nil(t, fail2Step(tscb))
 	assert.Equal(t, StateOpen, tscb.State())
-	assert.Equal(t, Counts{0, 0, 0}, tscb.cb.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, tscb.cb.counts)
 	assert.False(t, tscb.cb.expiry.IsZero())
 
 	// StateOpen to StateHalfOpen
@@ -79,6 +80,185 @@ func TestTwoStepCircuitBreaker(t *testing.T) {
 	// StateHalfOpen to StateClosed
 	assert.Nil(t, succeed2Step(tscb))
 	assert.Equal(t, StateClosed, tscb.State())
-	assert.Equal(t, Counts{0, 0, 0}, tscb.cb.counts)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, tscb.cb.counts)
 	assert.True(t, tscb.cb.expiry.IsZero())
 }
+
+func TestTwoStepDetailedCounts(t *testing.T) {
+	cb := NewCircuitBreaker(Config{MaxRequestsWhileHalfOpen: 2})
+	tscb := NewTwoStepCircuitBreaker(Config{MaxRequestsWhileHalfOpen: 2})
+
+	outcomes := []bool{false, true, false, false, true, true, false}
+	for _, success := range outcomes {
+		if success {
+			assert.Nil(t, succeed(cb))
+			assert.Nil(t, succeed2Step(tscb))
+		} else {
+			assert.Nil(t, fail(cb))
+			assert.Nil(t, fail2Step(tscb))
+		}
+		assert.Equal(t, cb.Counts(), tscb.DetailedCounts())
+	}
+	assert.Equal(t, Counts{7, 0, 1, 3, 4, 0}, tscb.DetailedCounts())
+
+	// half-open
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+		assert.Nil(t, fail2Step(tscb))
+	}
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	pseudoSleep(tscb.cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, succeed2Step(tscb))
+	assert.Equal(t, StateHalfOpen, tscb.State())
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, tscb.DetailedCounts())
+	assert.Equal(t, cb.Counts(), tscb.DetailedCounts())
+}
+
+func TestTwoStepAllowBulk(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Config{})
+
+	done, err := tscb.AllowBulk()
+	assert.Nil(t, err)
+	done(3, 2)
+	assert.Equal(t, StateClosed, tscb.State())
+	assert.Equal(t, Counts{1, 0, 2, 3, 2, 0}, tscb.DetailedCounts())
+
+	done, err = tscb.AllowBulk()
+	assert.Nil(t, err)
+	done(1, 0)
+	assert.Equal(t, Counts{2, 1, 0, 4, 2, 0}, tscb.DetailedCounts())
+
+	// StateClosed to StateOpen
+	done, err = tscb.AllowBulk()
+	assert.Nil(t, err)
+	done(0, 10)
+	assert.Equal(t, StateOpen, tscb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, tscb.DetailedCounts())
+
+	// a single mixed probe reopens
+	pseudoSleep(tscb.cb, time.Duration(60)*time.Second)
+	done, err = tscb.AllowBulk()
+	assert.Nil(t, err)
+	done(4, 1)
+	assert.Equal(t, StateOpen, tscb.State())
+
+	// StateHalfOpen to StateClosed
+	pseudoSleep(tscb.cb, time.Duration(60)*time.Second)
+	done, err = tscb.AllowBulk()
+	assert.Nil(t, err)
+	_, err = tscb.AllowBulk()
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+	done(4, 0)
+	assert.Equal(t, StateClosed, tscb.State())
+}
+
+func TestTwoStepAllowContext(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Config{})
+
+	done, err := tscb.AllowContext(context.Background())
+	assert.Nil(t, err)
+	done(true)
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, tscb.DetailedCounts())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done, err = tscb.AllowContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, done)
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, tscb.DetailedCounts())
+}
+
+func TestTwoStepAllowDeadline(t *testing.T) {
+	// no limit
+	tscb := NewTwoStepCircuitBreaker(Config{})
+	done, deadline, err := tscb.AllowDeadline(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, deadline.IsZero())
+	done(true)
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, tscb.Counts())
+
+	// the earlier of RequestTimeout and ctx's deadline
+	tscb = NewTwoStepCircuitBreaker(Config{RequestTimeout: time.Duration(20) * time.Millisecond})
+	start := time.Now()
+	done, deadline, err = tscb.AllowDeadline(context.Background())
+	assert.Nil(t, err)
+	assert.WithinDuration(t, start.Add(time.Duration(20)*time.Millisecond), deadline, time.Duration(5)*time.Millisecond)
+	done(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10)*time.Millisecond)
+	defer cancel()
+	ctxDeadline, _ := ctx.Deadline()
+	_, deadline, err = tscb.AllowDeadline(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, ctxDeadline, deadline)
+
+	// that request is never reported, so it fails at the deadline
+	time.Sleep(time.Until(deadline) + time.Duration(5)*time.Millisecond)
+	assert.Equal(t, Counts{2, 0, 1, 1, 1, 0}, tscb.Counts())
+
+	// reporting after the deadline is a no-op
+	done, deadline, err = tscb.AllowDeadline(context.Background())
+	assert.Nil(t, err)
+	time.Sleep(time.Until(deadline) + time.Duration(5)*time.Millisecond)
+	done(true)
+	assert.Equal(t, Counts{3, 0, 2, 1, 2, 0}, tscb.Counts())
+
+	// rejected
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	done, deadline, err = tscb.AllowDeadline(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, done)
+	assert.True(t, deadline.IsZero())
+}
+
+func TestTwoStepAllowWithTimeout(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Config{
+		HalfOpenSuccessRatio:     0.5,
+		HalfOpenMaxRequests:      2,
+		HalfOpenTolerateFailures: true,
+	})
+
+	// idempotent
+	done, err := tscb.AllowWithTimeout(0)
+	assert.Nil(t, err)
+	done(true)
+	done(false)
+	assert.Equal(t, Counts{1, 1, 0, 1, 0, 0}, tscb.Counts())
+
+	tscb.cb.ForceOpen()
+	pseudoSleep(tscb.cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, tscb.State())
+
+	// an abandoned probe releases its slot once it times out
+	_, err = tscb.AllowWithTimeout(time.Duration(10) * time.Millisecond)
+	assert.Nil(t, err)
+	done, err = tscb.AllowWithTimeout(time.Duration(10) * time.Millisecond)
+	assert.Nil(t, err)
+	_, err = tscb.Allow()
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+	done(true)
+
+	time.Sleep(time.Duration(20) * time.Millisecond)
+	assert.Equal(t, StateClosed, tscb.State()) // 1/2
+	done(false)
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, tscb.Counts())
+}
+
+func TestTwoStepReset(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Config{})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail2Step(tscb))
+	}
+	assert.Equal(t, StateOpen, tscb.State())
+
+	// a request admitted before the Reset doesn't count afterwards
+	tscb.Reset()
+	done, err := tscb.Allow()
+	assert.Nil(t, err)
+	tscb.Reset()
+	done(false)
+	assert.Equal(t, StateClosed, tscb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, tscb.Counts())
+}
@@ -0,0 +1,81 @@
+package circuitbreaker
+
+import (
+	"context"
+	"time"
+)
+
+// DoContextWait is like DoContext but, rather than failing right away while
+// the CircuitBreaker is open, it waits for it to become half-open, or to be
+// closed, e.g. by Reset, and then attempts the request. This suits background
+// jobs that would rather wait out a short outage. It can block for up to
+// TimeoutOpenState, plus TimeoutJitter, or indefinitely while the
+// CircuitBreaker is forced open, so ctx should bound the wait: if ctx is done
+// first, DoContextWait returns ctx.Err() without running the request. Once the
+// wait is over, the request can still be rejected, e.g. if the half-open
+// probes are already taken. The wait is timed on real time, not on the Clock
+func (cb *CircuitBreaker) DoContextWait(ctx context.Context, req func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	for {
+		open, remaining, changed := cb.openWait()
+		if !open {
+			break
+		}
+
+		if err := waitFor(ctx, remaining, changed); err != nil {
+			return nil, err
+		}
+	}
+	return cb.DoContext(ctx, req)
+}
+
+// waitFor waits until changed is closed, d is over, unless it's 0, or ctx is
+// done, in which case it returns ctx.Err()
+func waitFor(ctx context.Context, d time.Duration, changed <-chan struct{}) error {
+	var expired <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-changed:
+		return nil
+	case <-expired:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// openWait reports whether the CircuitBreaker is open and, if it is, how long
+// until it's due to become half-open, 0 if there's no timeout, and a channel
+// that's closed when the current generation is over
+func (cb *CircuitBreaker) openWait() (bool, time.Duration, <-chan struct{}) {
+	cb.lock()
+	defer cb.unlock()
+
+	now := cb.now()
+	state, _ := cb.currentState(now)
+	if state != StateOpen {
+		return false, 0, nil
+	}
+
+	var remaining time.Duration
+	if !cb.expiry.IsZero() {
+		// the transition to half-open happens once the expiry is past
+		remaining = cb.expiry.Sub(now) + time.Millisecond
+	}
+	if cb.newGeneration == nil {
+		cb.newGeneration = make(chan struct{})
+	}
+	return true, remaining, cb.newGeneration
+}
+
+// signalGeneration wakes up the requests waiting in DoContextWait for the
+// current generation to be over. It must be called with the mutex held
+func (cb *CircuitBreaker) signalGeneration() {
+	if cb.newGeneration != nil {
+		close(cb.newGeneration)
+		cb.newGeneration = nil
+	}
+}
@@ -0,0 +1,48 @@
+package circuitbreaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoContextWait(t *testing.T) {
+	cb := NewCircuitBreaker(Config{TimeoutOpenState: time.Duration(50) * time.Millisecond})
+	req := func(ctx context.Context) (interface{}, error) {
+		return 1, nil
+	}
+
+	// doesn't wait while closed
+	result, err := cb.DoContextWait(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result)
+
+	// waits out the open-state timeout, then probes
+	cb.ForceOpen()
+	start := time.Now()
+	result, err = cb.DoContextWait(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result)
+	assert.GreaterOrEqual(t, time.Since(start), time.Duration(50)*time.Millisecond)
+	assert.Equal(t, StateClosed, cb.State())
+
+	// bounded by ctx
+	cb.ForceOpenIndefinitely()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(20)*time.Millisecond)
+	defer cancel()
+	_, err = cb.DoContextWait(ctx, func(ctx context.Context) (interface{}, error) {
+		panic("not called")
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// woken up by a state change
+	go func() {
+		time.Sleep(time.Duration(20) * time.Millisecond)
+		cb.Reset()
+	}()
+	result, err = cb.DoContextWait(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result)
+}
@@ -0,0 +1,196 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"time"
+)
+
+// WindowType selects how the counts passed to ShouldTrip are aggregated
+type WindowType int
+
+const (
+	// WindowInterval aggregates the counts since the start of the current
+	// generation, i.e. since the last state change or closed-state interval
+	// reset
+	WindowInterval WindowType = iota
+
+	// WindowCountBased aggregates the counts over the last WindowSize
+	// requests that completed since the last state change
+	WindowCountBased
+
+	// WindowTimeBased aggregates the counts over the requests that completed
+	// in the last WindowSize seconds since the last state change
+	WindowTimeBased
+)
+
+// String implements stringer interface
+func (w WindowType) String() string {
+	switch w {
+	case WindowInterval:
+		return "interval"
+	case WindowCountBased:
+		return "count-based"
+	case WindowTimeBased:
+		return "time-based"
+	default:
+		return fmt.Sprintf("unknown window type: %d", w)
+	}
+}
+
+// slidingWindow aggregates request outcomes over a rolling window. Unlike
+// Counts, closed-state interval resets don't clear it
+type slidingWindow interface {
+	record(now time.Time, success bool)
+	totals(now time.Time) (successes, failures uint32)
+	flush(now time.Time)
+	reset()
+	clone() slidingWindow
+}
+
+func newSlidingWindow(windowType WindowType, size uint32) slidingWindow {
+	switch windowType {
+	case WindowCountBased:
+		return &countWindow{sample: newOutcomeSample(size)}
+	case WindowTimeBased:
+		return &timeWindow{buckets: make([]windowBucket, size)}
+	default:
+		return nil
+	}
+}
+
+// countWindow aggregates the last N outcomes
+type countWindow struct {
+	sample *outcomeSample
+}
+
+func (w *countWindow) record(_ time.Time, success bool) {
+	w.sample.record(success)
+}
+
+func (w *countWindow) totals(_ time.Time) (uint32, uint32) {
+	return uint32(w.sample.len - w.sample.failures), uint32(w.sample.failures)
+}
+
+func (w *countWindow) flush(_ time.Time) {}
+
+func (w *countWindow) reset() {
+	w.sample.reset()
+}
+
+func (w *countWindow) clone() slidingWindow {
+	return &countWindow{sample: w.sample.clone()}
+}
+
+// timeWindowBucketSpan is the period each bucket of a timeWindow covers
+const timeWindowBucketSpan = time.Second
+
+type windowBucket struct {
+	successes uint32
+	failures  uint32
+}
+
+// timeWindow aggregates the outcomes of the last N seconds in one-second
+// buckets. The bucket at head covers [headStart, headStart+1s)
+type timeWindow struct {
+	buckets   []windowBucket
+	head      int
+	headStart time.Time
+	successes uint32
+	failures  uint32
+}
+
+// advance moves head forward to the bucket covering now, evicting the buckets
+// that fell out of the window
+func (w *timeWindow) advance(now time.Time) {
+	if w.headStart.IsZero() {
+		w.headStart = now
+		return
+	}
+
+	elapsed := int(now.Sub(w.headStart) / timeWindowBucketSpan)
+	if elapsed <= 0 {
+		return
+	}
+	if elapsed >= len(w.buckets) {
+		w.reset()
+		w.headStart = now
+		return
+	}
+	for i := 0; i < elapsed; i++ {
+		w.head = (w.head + 1) % len(w.buckets)
+		w.successes -= w.buckets[w.head].successes
+		w.failures -= w.buckets[w.head].failures
+		w.buckets[w.head] = windowBucket{}
+	}
+	w.headStart = w.headStart.Add(time.Duration(elapsed) * timeWindowBucketSpan)
+}
+
+func (w *timeWindow) record(now time.Time, success bool) {
+	w.advance(now)
+	if success {
+		w.buckets[w.head].successes++
+		w.successes++
+	} else {
+		w.buckets[w.head].failures++
+		w.failures++
+	}
+}
+
+func (w *timeWindow) totals(now time.Time) (uint32, uint32) {
+	w.advance(now)
+	return w.successes, w.failures
+}
+
+func (w *timeWindow) flush(now time.Time) {
+	w.advance(now)
+}
+
+func (w *timeWindow) reset() {
+	for i := range w.buckets {
+		w.buckets[i] = windowBucket{}
+	}
+	*w = timeWindow{buckets: w.buckets}
+}
+
+func (w *timeWindow) clone() slidingWindow {
+	c := *w
+	c.buckets = append([]windowBucket(nil), w.buckets...)
+	return &c
+}
+
+// Flush evicts the outcomes that fell out of a WindowTimeBased window by now.
+// The window otherwise only rotates its buckets when a request completes or
+// its totals are read, so Flush lets tests and tools advance it
+// deterministically. It's a no-op for the other window types
+func (cb *CircuitBreaker) Flush() {
+	cb.lock()
+	defer cb.unlock()
+
+	if cb.window != nil {
+		cb.window.flush(cb.now())
+	}
+}
+
+// tripCounts returns the counts ShouldTrip is evaluated against. With a
+// sliding window, the totals are aggregated over the window rather than the
+// current generation
+func (cb *CircuitBreaker) tripCounts(now time.Time) Counts {
+	counts := cb.counts
+	if cb.window != nil {
+		counts.TotalSuccesses, counts.TotalFailures = cb.window.totals(now)
+	}
+	return counts
+}
+
+func (cb *CircuitBreaker) resetWindow() {
+	if cb.window != nil {
+		cb.window.reset()
+	}
+}
+
+func (cb *CircuitBreaker) cloneWindow() slidingWindow {
+	if cb.window == nil {
+		return nil
+	}
+	return cb.window.clone()
+}
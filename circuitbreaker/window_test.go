@@ -0,0 +1,125 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowTypeString(t *testing.T) {
+	assert.Equal(t, "interval", WindowInterval.String())
+	assert.Equal(t, "count-based", WindowCountBased.String())
+	assert.Equal(t, "time-based", WindowTimeBased.String())
+	assert.Equal(t, "unknown window type: 3", WindowType(3).String())
+}
+
+func TestCountBasedWindow(t *testing.T) {
+	var tripCounts Counts
+	cb := NewCircuitBreaker(Config{
+		Interval:   time.Duration(30) * time.Second,
+		WindowType: WindowCountBased,
+		WindowSize: 4,
+		ShouldTrip: func(counts Counts) bool {
+			tripCounts = counts
+			return counts.TotalFailures >= 3
+		},
+	})
+
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, Counts{3, 0, 1, 1, 2, 0}, tripCounts)
+
+	// survives the closed-state interval
+	pseudoSleep(cb, time.Duration(30)*time.Second)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, Counts{0, 0, 0, 0, 0, 0}, cb.Counts())
+
+	// the oldest failure is evicted before it can trip the breaker
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, Counts{2, 0, 1, 2, 2, 0}, tripCounts)
+	assert.Equal(t, StateClosed, cb.State())
+
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, Counts{3, 0, 2, 1, 3, 0}, tripCounts)
+	assert.Equal(t, StateOpen, cb.State())
+
+	// cleared on state change
+	assert.Equal(t, 0.0, cb.FailureRatio())
+}
+
+func TestTimeBasedWindow(t *testing.T) {
+	var tripCounts Counts
+	cb := NewCircuitBreaker(Config{
+		WindowType: WindowTimeBased,
+		WindowSize: 10,
+		ShouldTrip: func(counts Counts) bool {
+			tripCounts = counts
+			return counts.TotalFailures >= 3
+		},
+	})
+
+	assert.Nil(t, fail(cb))
+	pseudoSleep(cb, time.Duration(4)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, Counts{3, 0, 1, 1, 2, 0}, tripCounts)
+	assert.InDelta(t, 2.0/3.0, cb.FailureRatio(), 1e-9)
+
+	// the first failure falls out of the window
+	pseudoSleep(cb, time.Duration(7)*time.Second)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, Counts{4, 0, 2, 1, 2, 0}, tripCounts)
+	assert.Equal(t, StateClosed, cb.State())
+
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, Counts{5, 0, 3, 1, 3, 0}, tripCounts)
+	assert.Equal(t, StateOpen, cb.State())
+
+	// everything falls out of the window
+	w := NewCircuitBreaker(Config{WindowType: WindowTimeBased, WindowSize: 10})
+	assert.Nil(t, fail(w))
+	pseudoSleep(w, time.Duration(10)*time.Second)
+	assert.Equal(t, 0.0, w.FailureRatio())
+}
+
+func TestFlush(t *testing.T) {
+	cb := NewCircuitBreaker(Config{WindowType: WindowTimeBased, WindowSize: 10})
+	assert.Nil(t, fail(cb))
+	pseudoSleep(cb, time.Duration(4)*time.Second)
+	assert.Nil(t, succeed(cb))
+
+	w := cb.window.(*timeWindow)
+	pseudoSleep(cb, time.Duration(7)*time.Second)
+	assert.Equal(t, uint32(1), w.failures)
+	cb.Flush()
+	assert.Equal(t, uint32(0), w.failures)
+	assert.Equal(t, uint32(1), w.successes)
+
+	pseudoSleep(cb, time.Duration(10)*time.Second)
+	cb.Flush()
+	assert.Equal(t, uint32(0), w.successes)
+
+	// no-op without a time-based window
+	for _, cb := range []*CircuitBreaker{
+		NewCircuitBreaker(Config{}),
+		NewCircuitBreaker(Config{WindowType: WindowCountBased}),
+	} {
+		assert.Nil(t, fail(cb))
+		pseudoSleep(cb, time.Duration(30)*time.Second)
+		cb.Flush()
+		assert.Equal(t, 1.0, cb.FailureRatio())
+	}
+}
+
+func TestWindowDefaults(t *testing.T) {
+	assert.Nil(t, NewCircuitBreaker(Config{}).window)
+
+	cb := NewCircuitBreaker(Config{WindowType: WindowCountBased})
+	assert.Equal(t, 100, len(cb.window.(*countWindow).sample.failed))
+
+	cb = NewCircuitBreaker(Config{WindowType: WindowTimeBased})
+	assert.Equal(t, 60, len(cb.window.(*timeWindow).buckets))
+}